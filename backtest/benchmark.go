@@ -0,0 +1,207 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 单月超额收益 比例都已经乘了100
+type MonthlyExcessReturn struct {
+	Month           string // 202401
+	StrategyReturn  float64
+	BenchmarkReturn float64
+	ExcessReturn    float64
+}
+
+// @func 相对基准的指标 比例都已经乘了100
+type BenchmarkMetrics struct {
+	Benchmark data_center.StockItem
+	Bars      int // 参与计算的k线数
+
+	StrategyReturn     float64 // 策略总收益率
+	BenchmarkReturn    float64 // 基准总收益率
+	ExcessReturn       float64 // 总超额收益率 策略减基准
+	AnnualExcessReturn float64 // 年化超额收益率 策略年化减基准年化
+
+	Alpha            float64 // 年化alpha
+	Beta             float64
+	Correlation      float64 // 日收益率相关系数
+	TrackingError    float64 // 年化跟踪误差
+	InformationRatio float64 // 年化超额收益除以跟踪误差
+	UpCapture        float64 // 基准上涨日策略平均收益除以基准平均收益
+	DownCapture      float64 // 基准下跌日策略平均收益除以基准平均收益 越小越好
+
+	MonthlyExcess []MonthlyExcessReturn
+}
+
+// @func 计算回测结果相对基准的指标 不限制资金时以占用本金作为期初资产
+// @benchmark_kline_items 基准的日k线 按日期排序
+func CalculateResultBenchmarkMetrics(result Result, benchmark data_center.StockItem, benchmark_kline_items []data_center.KlineItem) BenchmarkMetrics {
+	equity, initial_equity := resultEquity(result)
+	return CalculateBenchmarkMetrics(equity, initial_equity, benchmark, benchmark_kline_items)
+}
+
+// @func 根据资金曲线计算相对基准的指标
+// 基准在资金曲线的每个日期取当日或之前最近的收盘价 停牌或者日期不一致时收益为0
+// @equity 按日期排序的资金曲线
+// @initial_equity 第一根k线之前的资产 单位元
+// @benchmark_kline_items 基准的日k线 按日期排序
+func CalculateBenchmarkMetrics(equity []EquityPoint, initial_equity float64, benchmark data_center.StockItem, benchmark_kline_items []data_center.KlineItem) BenchmarkMetrics {
+	metrics := BenchmarkMetrics{Benchmark: benchmark}
+	equity_len := len(equity)
+	if equity_len <= 0 || initial_equity <= 0 || len(benchmark_kline_items) <= 0 {
+		return metrics
+	}
+
+	pre_benchmark_close, benchmark_close := alignBenchmarkClose(equity, benchmark_kline_items)
+	if pre_benchmark_close <= 0 {
+		return metrics
+	}
+
+	strategy_returns := make([]float64, equity_len)
+	benchmark_returns := make([]float64, equity_len)
+	excess_returns := make([]float64, equity_len)
+	pre_equity := initial_equity
+	pre_close := pre_benchmark_close
+	for i, point := range equity {
+		if pre_equity > 0 {
+			strategy_returns[i] = point.Equity/pre_equity - 1.0
+		}
+		if pre_close > 0 && benchmark_close[i] > 0 {
+			benchmark_returns[i] = benchmark_close[i]/pre_close - 1.0
+		}
+		excess_returns[i] = strategy_returns[i] - benchmark_returns[i]
+		pre_equity = point.Equity
+		if benchmark_close[i] > 0 {
+			pre_close = benchmark_close[i]
+		}
+	}
+
+	metrics.Bars = equity_len
+	years := float64(equity_len) / kTradeDaysPerYear
+	strategy_total := equity[equity_len-1].Equity / initial_equity
+	benchmark_total := pre_close / pre_benchmark_close
+	metrics.StrategyReturn = (strategy_total - 1.0) * 100.0
+	metrics.BenchmarkReturn = (benchmark_total - 1.0) * 100.0
+	metrics.ExcessReturn = metrics.StrategyReturn - metrics.BenchmarkReturn
+	if strategy_total > 0 && benchmark_total > 0 {
+		metrics.AnnualExcessReturn = (math.Pow(strategy_total, 1.0/years) - math.Pow(benchmark_total, 1.0/years)) * 100.0
+	}
+
+	// alpha beta 基于日收益率回归
+	strategy_avg := calculateAverage(strategy_returns)
+	benchmark_avg := calculateAverage(benchmark_returns)
+	covariance := 0.0
+	strategy_variance := 0.0
+	benchmark_variance := 0.0
+	for i := 0; i < equity_len; i++ {
+		covariance += (strategy_returns[i] - strategy_avg) * (benchmark_returns[i] - benchmark_avg)
+		strategy_variance += (strategy_returns[i] - strategy_avg) * (strategy_returns[i] - strategy_avg)
+		benchmark_variance += (benchmark_returns[i] - benchmark_avg) * (benchmark_returns[i] - benchmark_avg)
+	}
+	if benchmark_variance > 0 {
+		metrics.Beta = covariance / benchmark_variance
+	}
+	if strategy_variance > 0 && benchmark_variance > 0 {
+		metrics.Correlation = covariance / math.Sqrt(strategy_variance*benchmark_variance)
+	}
+	daily_risk_free := kRiskFreeRate / kTradeDaysPerYear
+	metrics.Alpha = ((strategy_avg - daily_risk_free) - metrics.Beta*(benchmark_avg-daily_risk_free)) * kTradeDaysPerYear * 100.0
+
+	tracking_error := CalculateStd(excess_returns) * math.Sqrt(kTradeDaysPerYear)
+	metrics.TrackingError = tracking_error * 100.0
+	if tracking_error > 0 {
+		metrics.InformationRatio = calculateAverage(excess_returns) * kTradeDaysPerYear / tracking_error
+	}
+
+	// 上涨下跌捕获率
+	up_strategy, up_benchmark := 0.0, 0.0
+	down_strategy, down_benchmark := 0.0, 0.0
+	for i := 0; i < equity_len; i++ {
+		if benchmark_returns[i] > 0 {
+			up_strategy += strategy_returns[i]
+			up_benchmark += benchmark_returns[i]
+		} else if benchmark_returns[i] < 0 {
+			down_strategy += strategy_returns[i]
+			down_benchmark += benchmark_returns[i]
+		}
+	}
+	if up_benchmark != 0 {
+		metrics.UpCapture = up_strategy / up_benchmark * 100.0
+	}
+	if down_benchmark != 0 {
+		metrics.DownCapture = down_strategy / down_benchmark * 100.0
+	}
+
+	// 按月复利
+	for i := 0; i < equity_len; i++ {
+		month := equity[i].Date[0:6]
+		months_len := len(metrics.MonthlyExcess)
+		if months_len <= 0 || metrics.MonthlyExcess[months_len-1].Month != month {
+			metrics.MonthlyExcess = append(metrics.MonthlyExcess, MonthlyExcessReturn{Month: month, StrategyReturn: 1.0, BenchmarkReturn: 1.0})
+			months_len++
+		}
+		item := &metrics.MonthlyExcess[months_len-1]
+		item.StrategyReturn *= 1.0 + strategy_returns[i]
+		item.BenchmarkReturn *= 1.0 + benchmark_returns[i]
+	}
+	for i := range metrics.MonthlyExcess {
+		item := &metrics.MonthlyExcess[i]
+		item.StrategyReturn = (item.StrategyReturn - 1.0) * 100.0
+		item.BenchmarkReturn = (item.BenchmarkReturn - 1.0) * 100.0
+		item.ExcessReturn = item.StrategyReturn - item.BenchmarkReturn
+	}
+
+	return metrics
+}
+
+// @func 基准收盘价对齐到资金曲线日期 取当日或之前最近的收盘价
+// @return 第一根k线之前的收盘价 没有时取第一天的收盘价 对齐后的收盘价
+func alignBenchmarkClose(equity []EquityPoint, benchmark_kline_items []data_center.KlineItem) (float64, []float64) {
+	benchmark_close := make([]float64, len(equity))
+	if len(equity) <= 0 {
+		return 0.0, benchmark_close
+	}
+
+	pre_benchmark_close := 0.0
+	j := 0
+	for j < len(benchmark_kline_items) && benchmark_kline_items[j].Date < equity[0].Date {
+		pre_benchmark_close = float64(benchmark_kline_items[j].Close)
+		j++
+	}
+	last_close := pre_benchmark_close
+	for i, point := range equity {
+		for j < len(benchmark_kline_items) && benchmark_kline_items[j].Date <= point.Date {
+			last_close = float64(benchmark_kline_items[j].Close)
+			j++
+		}
+		benchmark_close[i] = last_close
+	}
+	if pre_benchmark_close <= 0 {
+		pre_benchmark_close = benchmark_close[0]
+	}
+	return pre_benchmark_close, benchmark_close
+}
+
+func calculateAverage(array []float64) float64 {
+	if len(array) <= 0 {
+		return 0.0
+	}
+	sum := 0.0
+	for _, value := range array {
+		sum += value
+	}
+	return sum / float64(len(array))
+}
+
+func (metrics BenchmarkMetrics) String() string {
+	result := fmt.Sprintf("基准 %s(%s) 策略收益率 %.2f%% 基准收益率 %.2f%% 超额收益率 %.2f%% 年化超额 %.2f%%\n", metrics.Benchmark.Name, metrics.Benchmark.Symbol, metrics.StrategyReturn, metrics.BenchmarkReturn, metrics.ExcessReturn, metrics.AnnualExcessReturn)
+	result += fmt.Sprintf("alpha %.2f%% beta %.2f 相关系数 %.2f 跟踪误差 %.2f%% 信息比率 %.2f 上涨捕获 %.2f%% 下跌捕获 %.2f%%\n", metrics.Alpha, metrics.Beta, metrics.Correlation, metrics.TrackingError, metrics.InformationRatio, metrics.UpCapture, metrics.DownCapture)
+	result += "月份 策略 基准 超额"
+	for _, item := range metrics.MonthlyExcess {
+		result += fmt.Sprintf("\n%s %.2f%% %.2f%% %.2f%%", item.Month, item.StrategyReturn, item.BenchmarkReturn, item.ExcessReturn)
+	}
+	return result
+}
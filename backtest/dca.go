@@ -0,0 +1,302 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+type DcaSchedule = int64
+
+const (
+	DcaSchedule_Weekly  DcaSchedule = 0 // 每周 当周第一个星期几不早于Day的交易日
+	DcaSchedule_Monthly DcaSchedule = 1 // 每月 当月第一个日期不早于Day的交易日
+)
+
+type DcaMode = int64
+
+const (
+	DcaMode_Fixed          DcaMode = 0 // 每期固定金额
+	DcaMode_ValueAveraging DcaMode = 1 // 价值平均 每期市值目标增加Amount 补足差额
+	DcaMode_Valuation      DcaMode = 2 // 估值定投 每期金额乘以Multiplier
+)
+
+// @func 定投配置
+type DcaConfig struct {
+	Schedule    DcaSchedule
+	Day         int     // 每周的星期几 1-5 或者每月的几号 1-31
+	Amount      float64 // 每期基准金额 单位元
+	Mode        DcaMode
+	MaxMultiple float64 // 单期金额最多为Amount的几倍 价值平均和估值定投使用 0表示不限制
+	AllowSell   bool    // 价值平均超过目标时是否卖出
+	// 估值定投的金额倍数 如估值分位低时大于1 高时小于1
+	Multiplier func(kline_items []data_center.KlineItem, index int) float64
+	Rules      TradingRules
+}
+
+// @func 默认配置 每月第一个交易日定投1000元 单期最多3倍
+func DefaultDcaConfig() DcaConfig {
+	return DcaConfig{
+		Schedule:    DcaSchedule_Monthly,
+		Day:         1,
+		Amount:      1000,
+		Mode:        DcaMode_Fixed,
+		MaxMultiple: 3.0,
+		Rules:       DefaultEtfTradingRules(),
+	}
+}
+
+// @func 定投每天的状态
+type DcaPoint struct {
+	Date     string
+	Invested float64 // 累计净投入 单位元
+	Value    float64 // 持仓市值加未用完的现金 单位元
+	Nav      float64 // 时间加权净值 从1开始 不受投入时间影响
+}
+
+// @func 定投结果 比例都已经乘了100
+type DcaResult struct {
+	Points      []DcaPoint
+	Fills       []Fill
+	Equity      []EquityPoint // 按不限制资金的方式记录 投入记为现金减少 用于导出
+	Periods     int           // 定投期数
+	Invested    float64       // 累计净投入 单位元
+	FinalValue  float64       // 期末市值 单位元
+	ProfitRate  float64       // 收益除以累计净投入
+	Irr         float64       // 按现金流日期计算的年化内部收益率
+	MaxDrawdown float64       // 时间加权净值的最大回撤
+	MaxLossRate float64       // 最大浮亏占当时累计投入的比例
+
+	// 开始日一次性投入全部金额并持有
+	LumpSumValue       float64
+	LumpSumProfitRate  float64
+	LumpSumIrr         float64
+	LumpSumMaxDrawdown float64
+}
+
+// @func 定投的日期 每周或每月第一个满足Day的交易日
+// 周期内没有满足Day的交易日时 如周五休市或者2月没有31号 取该周期最后一个交易日
+// 最后一个周期在end之后还有k线时才能确认已经结束
+// @return 定投日期的k线索引
+func DcaScheduleIndexes(kline_items []data_center.KlineItem, start int, end int, schedule DcaSchedule, day int) []int {
+	// 周期和周期内的日子 日期无效时返回false
+	periodOf := func(index int) (string, int, bool) {
+		date, err := time.ParseInLocation("20060102", kline_items[index].Date, time.Local)
+		if err != nil {
+			return "", 0, false
+		}
+		if schedule == DcaSchedule_Weekly {
+			year, week := date.ISOWeek()
+			return fmt.Sprintf("%d%02d", year, week), int(date.Weekday()), true
+		}
+		return kline_items[index].Date[0:6], date.Day(), true
+	}
+
+	result := make([]int, 0)
+	last_period := ""
+	last_index := -1
+	scheduled := false // 当前周期是否已经定投
+	for i := start; i < end; i++ {
+		period, day_value, ok := periodOf(i)
+		if !ok {
+			continue
+		}
+		if period != last_period {
+			if last_index >= 0 && !scheduled {
+				result = append(result, last_index)
+			}
+			last_period = period
+			scheduled = false
+		}
+		if !scheduled && day_value >= day {
+			result = append(result, i)
+			scheduled = true
+		}
+		last_index = i
+	}
+
+	if last_index >= 0 && !scheduled && end < len(kline_items) {
+		if period, _, ok := periodOf(end); ok && period != last_period {
+			result = append(result, last_index)
+		}
+	}
+	return result
+}
+
+// @func 模拟定投 按定投日收盘价买入 未用完的现金留到下期
+// @start 开始索引 包含
+// @end 结束索引 不含
+func SimulateDca(stock_item data_center.StockItem, kline_items []data_center.KlineItem, start int, end int, config DcaConfig) DcaResult {
+	result := DcaResult{}
+	if start < 0 || end > len(kline_items) || start >= end {
+		return result
+	}
+
+	indexes := DcaScheduleIndexes(kline_items, start, end, config.Schedule, config.Day)
+	result.Periods = len(indexes)
+
+	var hold_shares int64 = 0
+	cash := 0.0
+	nav := 1.0
+	peak_nav := 1.0
+	pre_value := 0.0
+	flow_dates := make([]string, 0)
+	flows := make([]float64, 0)
+	cursor := 0
+	for i := start; i < end; i++ {
+		kline_item := kline_items[i]
+		price_yuan := PriceToYuan(kline_item.Close)
+
+		// 当日收益计入净值 再处理当日的投入
+		value := float64(hold_shares)*price_yuan + cash
+		if pre_value > 0 {
+			nav *= value / pre_value
+		}
+
+		contribution := 0.0
+		if cursor < len(indexes) && indexes[cursor] == i {
+			cursor++
+			contribution = config.Amount
+			if config.Mode == DcaMode_ValueAveraging {
+				contribution = config.Amount*float64(cursor) - value
+				if !config.AllowSell && contribution < 0 {
+					contribution = 0
+				}
+			} else if config.Mode == DcaMode_Valuation && config.Multiplier != nil {
+				contribution = config.Amount * math.Max(config.Multiplier(kline_items, i), 0.0)
+			}
+			if config.MaxMultiple > 0 && config.Mode != DcaMode_Fixed {
+				contribution = math.Min(contribution, config.Amount*config.MaxMultiple)
+			}
+		}
+
+		if contribution > 0 {
+			cash += contribution
+			if config.Rules.CanBuy(stock_item, kline_item, kline_item.Close) {
+				quantity := config.Rules.AffordableQuantity(cash, kline_item.Close)
+				if quantity > 0 {
+					trade_value := float64(quantity) * price_yuan
+					fee := config.Rules.Fees.Calculate(OrderSide_Buy, trade_value)
+					cash -= trade_value + fee
+					hold_shares += quantity
+					result.Fills = append(result.Fills, Fill{Stock: stock_item, Side: OrderSide_Buy, Date: kline_item.Date, Price: kline_item.Close, Quantity: quantity, Fee: fee})
+				}
+			}
+		} else if contribution < 0 && hold_shares > 0 && config.Rules.CanSell(stock_item, kline_item, kline_item.Close) {
+			// 价值平均超出目标的部分卖出 取出的现金记为负投入
+			quantity := int64(math.Min(float64(hold_shares), math.Ceil(-contribution/price_yuan)))
+			trade_value := float64(quantity) * price_yuan
+			fee := config.Rules.Fees.Calculate(OrderSide_Sell, trade_value)
+			hold_shares -= quantity
+			contribution = -(trade_value - fee)
+			result.Fills = append(result.Fills, Fill{Stock: stock_item, Side: OrderSide_Sell, Date: kline_item.Date, Price: kline_item.Close, Quantity: quantity, Fee: fee})
+		} else {
+			contribution = 0
+		}
+
+		if contribution != 0 {
+			result.Invested += contribution
+			flow_dates = append(flow_dates, kline_item.Date)
+			flows = append(flows, -contribution)
+		}
+
+		value = float64(hold_shares)*price_yuan + cash
+		pre_value = value
+		result.Points = append(result.Points, DcaPoint{Date: kline_item.Date, Invested: result.Invested, Value: value, Nav: nav})
+
+		position_value := float64(hold_shares) * price_yuan
+		point := EquityPoint{Date: kline_item.Date, Cash: cash - result.Invested, PositionValue: position_value, Equity: value - result.Invested}
+		if hold_shares > 0 {
+			point.Positions = 1
+			point.Holdings = []Holding{{Stock: stock_item, Quantity: hold_shares, Value: position_value}}
+		}
+		result.Equity = append(result.Equity, point)
+
+		peak_nav = math.Max(peak_nav, nav)
+		result.MaxDrawdown = math.Max(result.MaxDrawdown, (1.0-nav/peak_nav)*100.0)
+		if result.Invested > 0 {
+			result.MaxLossRate = math.Max(result.MaxLossRate, (result.Invested-value)/result.Invested*100.0)
+		}
+	}
+
+	last_date := kline_items[end-1].Date
+	result.FinalValue = result.Points[len(result.Points)-1].Value
+	if result.Invested > 0 {
+		result.ProfitRate = (result.FinalValue - result.Invested) / result.Invested * 100.0
+	}
+	flow_dates = append(flow_dates, last_date)
+	flows = append(flows, result.FinalValue)
+	result.Irr = CalculateXirr(flow_dates, flows) * 100.0
+
+	// 同样金额在第一个定投日一次性买入
+	if len(indexes) > 0 && result.Invested > 0 {
+		first := indexes[0]
+		price_yuan := PriceToYuan(kline_items[first].Close)
+		quantity := config.Rules.AffordableQuantity(result.Invested, kline_items[first].Close)
+		lump_cash := result.Invested - float64(quantity)*price_yuan - config.Rules.Fees.Calculate(OrderSide_Buy, float64(quantity)*price_yuan)
+		peak := 0.0
+		for i := first; i < end; i++ {
+			value := float64(quantity)*PriceToYuan(kline_items[i].Close) + lump_cash
+			peak = math.Max(peak, value)
+			result.LumpSumMaxDrawdown = math.Max(result.LumpSumMaxDrawdown, (1.0-value/peak)*100.0)
+			result.LumpSumValue = value
+		}
+		result.LumpSumProfitRate = (result.LumpSumValue - result.Invested) / result.Invested * 100.0
+		result.LumpSumIrr = CalculateXirr([]string{kline_items[first].Date, last_date}, []float64{-result.Invested, result.LumpSumValue}) * 100.0
+	}
+
+	return result
+}
+
+// @func 不定期现金流的年化内部收益率 二分法求解
+// @dates 现金流日期 20240523
+// @flows 现金流 投入为负 取出为正
+// @return 年化收益率 0.1表示10% 无解时返回0
+func CalculateXirr(dates []string, flows []float64) float64 {
+	if len(dates) != len(flows) || len(dates) < 2 {
+		return 0.0
+	}
+
+	first, err := time.ParseInLocation("20060102", dates[0], time.Local)
+	if err != nil {
+		return 0.0
+	}
+	years := make([]float64, len(dates))
+	for i, date := range dates {
+		day, err := time.ParseInLocation("20060102", date, time.Local)
+		if err != nil {
+			return 0.0
+		}
+		years[i] = day.Sub(first).Hours() / 24.0 / 365.0
+	}
+
+	// 净现值随收益率单调递减
+	npv := func(rate float64) float64 {
+		result := 0.0
+		for i, flow := range flows {
+			result += flow / math.Pow(1.0+rate, years[i])
+		}
+		return result
+	}
+	low, high := -0.99, 10.0
+	if npv(low)*npv(high) > 0 {
+		return 0.0
+	}
+	for iteration := 0; iteration < 200; iteration++ {
+		middle := (low + high) / 2.0
+		if npv(middle) > 0 {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2.0
+}
+
+func (result DcaResult) String() string {
+	output := fmt.Sprintf("定投 %d 期 累计投入 %.2f 期末市值 %.2f 收益率 %.2f%% 年化内部收益率 %.2f%% 最大回撤 %.2f%% 最大浮亏 %.2f%%\n", result.Periods, result.Invested, result.FinalValue, result.ProfitRate, result.Irr, result.MaxDrawdown, result.MaxLossRate)
+	output += fmt.Sprintf("一次性投入 期末市值 %.2f 收益率 %.2f%% 年化收益率 %.2f%% 最大回撤 %.2f%%", result.LumpSumValue, result.LumpSumProfitRate, result.LumpSumIrr, result.LumpSumMaxDrawdown)
+	return output
+}
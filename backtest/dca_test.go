@@ -0,0 +1,64 @@
+package backtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 从first到last的工作日k线 去掉休市的日期
+func newDcaKlineItems(first string, last string, holidays ...string) []data_center.KlineItem {
+	holiday_set := make(map[string]bool)
+	for _, holiday := range holidays {
+		holiday_set[holiday] = true
+	}
+
+	kline_items := make([]data_center.KlineItem, 0)
+	date, _ := time.ParseInLocation("20060102", first, time.Local)
+	for ; date.Format("20060102") <= last; date = date.AddDate(0, 0, 1) {
+		day := date.Format("20060102")
+		if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday || holiday_set[day] {
+			continue
+		}
+		kline_items = append(kline_items, data_center.KlineItem{Date: day, Open: 10000, High: 10000, Low: 10000, Close: 10000, Volume: 100})
+	}
+	return kline_items
+}
+
+func TestDcaScheduleIndexes(t *testing.T) {
+	cases := []struct {
+		name        string
+		kline_items []data_center.KlineItem
+		end         int // 0表示全部k线
+		schedule    DcaSchedule
+		day         int
+		want        []string
+	}{
+		{"每月1号", newDcaKlineItems("20240101", "20240331", "20240101"), 0, DcaSchedule_Monthly, 1, []string{"20240102", "20240201", "20240301"}},
+		{"每月31号取月末最后一个交易日", newDcaKlineItems("20240101", "20240405"), 0, DcaSchedule_Monthly, 31, []string{"20240131", "20240229", "20240329"}},
+		{"每周五", newDcaKlineItems("20240101", "20240119"), 0, DcaSchedule_Weekly, 5, []string{"20240105", "20240112", "20240119"}},
+		{"周五休市取周四", newDcaKlineItems("20240101", "20240119", "20240105"), 0, DcaSchedule_Weekly, 5, []string{"20240104", "20240112", "20240119"}},
+		{"周四周五都休市取周三", newDcaKlineItems("20240101", "20240112", "20240104", "20240105"), 0, DcaSchedule_Weekly, 5, []string{"20240103", "20240112"}},
+		{"整周休市", newDcaKlineItems("20240101", "20240119", "20240108", "20240109", "20240110", "20240111", "20240112"), 0, DcaSchedule_Weekly, 3, []string{"20240103", "20240117"}},
+		{"数据结束时周期没有走完", newDcaKlineItems("20240101", "20240228"), 0, DcaSchedule_Monthly, 31, []string{"20240131"}},
+		{"end之后的k线在下个月 确认周期结束", newDcaKlineItems("20240101", "20240304"), 44, DcaSchedule_Monthly, 31, []string{"20240131", "20240229"}},
+		{"end之后的k线还在同一个月", newDcaKlineItems("20240101", "20240304"), 42, DcaSchedule_Monthly, 31, []string{"20240131"}},
+	}
+
+	for _, c := range cases {
+		end := c.end
+		if end <= 0 {
+			end = len(c.kline_items)
+		}
+		indexes := DcaScheduleIndexes(c.kline_items, 0, end, c.schedule, c.day)
+		got := make([]string, len(indexes))
+		for i, index := range indexes {
+			got[i] = c.kline_items[index].Date
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package backtest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// 价格单位 毫
+const kPriceUnit float64 = 10000.0
+
+// @func 价格从毫转换为元
+func PriceToYuan(price int64) float64 {
+	return float64(price) / kPriceUnit
+}
+
+type OrderSide = int64
+
+const (
+	OrderSide_Buy  OrderSide = 0 // 买入
+	OrderSide_Sell OrderSide = 1 // 卖出
+)
+
+type OrderType = int64
+
+const (
+	OrderType_NextOpen  OrderType = 0 // 下一根k线开盘成交
+	OrderType_NextClose OrderType = 1 // 下一根k线收盘成交
+)
+
+type Order struct {
+	Id         int64
+	Stock      data_center.StockItem
+	Side       OrderSide
+	Type       OrderType
+	Quantity   int64   // 股数
+	Value      float64 // 按金额买入 单位元 Quantity为0时使用
+	LotId      int64   // 卖出指定批次 0表示先进先出
+	Score      float64 // 开仓信号强度 仓位不足时优先高分
+	SignalDate string  // 下单日期
+	Reason     string  // 下单原因
+}
+
+type Fill struct {
+	OrderId  int64
+	Stock    data_center.StockItem
+	Side     OrderSide
+	Date     string
+	Price    int64   // 成交价 单位毫
+	Quantity int64   // 成交股数
+	Fee      float64 // 交易费用 单位元
+}
+
+// @func 持仓批次 每笔买入成交一个批次
+type Lot struct {
+	Id         int64   // 买入订单id
+	SignalDate string  // 信号日期
+	EntryDate  string  // 买入日期
+	EntryIndex int     // 买入k线索引
+	EntryPrice int64   // 买入价 单位毫
+	Quantity   int64   // 剩余股数
+	EntryFee   float64 // 剩余股数对应的买入费用 单位元
+}
+
+type Position struct {
+	Stock     data_center.StockItem
+	Lots      []Lot
+	LastPrice int64 // 最新收盘价 单位毫
+}
+
+// @func 持仓总股数
+func (position *Position) Quantity() int64 {
+	var result int64 = 0
+	for _, lot := range position.Lots {
+		result += lot.Quantity
+	}
+	return result
+}
+
+// @func 持仓市值 单位元
+func (position *Position) MarketValue() float64 {
+	return float64(position.Quantity()) * PriceToYuan(position.LastPrice)
+}
+
+// @func 一笔完整交易 买入批次到卖出
+type Trade struct {
+	Stock       data_center.StockItem
+	SignalDate  string
+	EntryDate   string
+	ExitDate    string
+	EntryPrice  int64 // 单位毫
+	ExitPrice   int64 // 单位毫
+	Quantity    int64
+	Fee         float64 // 买卖费用合计 单位元
+	Profit      float64 // 扣除费用后的收益 单位元
+	ReturnRate  float64 // 收益率 已经乘了100
+	HoldingBars int     // 持有k线数
+	ExitReason  string
+}
+
+type EquityPoint struct {
+	Date          string
+	Cash          float64   // 现金 单位元 不限制资金时可为负
+	PositionValue float64   // 持仓市值 单位元
+	Equity        float64   // 总资产 单位元
+	Positions     int       // 持仓股票数
+	Holdings      []Holding // 每只股票的持仓 按代号排序
+}
+
+// @func 某一天单只股票的持仓
+type Holding struct {
+	Stock    data_center.StockItem
+	Quantity int64
+	Value    float64 // 按当日收盘价计算的市值 单位元
+}
+
+type Result struct {
+	InitialCash    float64
+	Equity         []EquityPoint
+	Trades         []Trade
+	Fills          []Fill
+	SkippedSignals int64  // 仓位已满 资金不足或者已经持有该股票放弃的开仓信号
+	DataSnapshotId string // 数据快照ID 由股票池和k线内容计算 数据不变时相同
+
+	data_fingerprints []uint64 // 每只股票k线的指纹 按股票顺序
+	order_dates       []string // 每个订单的下单日期 订单id从1开始 合并时按串行回测的顺序重新编号
+}
+
+type Config struct {
+	InitialCash       float64                                             // 初始资金 单位元 0表示不限制资金 现金可为负
+	Rules             TradingRules                                        // 交易规则和费用
+	MaxPositions      int                                                 // 最大同时持仓股票数 0表示不限制
+	Sizer             PositionSizer                                       // 开仓信号的仓位模型 空表示使用信号自带金额
+	ExitRules         []ExitRule                                          // 对全部持仓生效的平仓规则
+	FillModel         FillModel                                           // 成交模型 空表示按参考价全部成交
+	StartDate         string                                              // 开始日期 20100101 之前的k线只作为历史数据
+	EndDate           string                                              // 结束日期 不含 空表示到最后
+	KlineType         data_center.KlineType                               // k线类型
+	KlineCount        uint64                                              // k线数量
+	KlineLoader       func(data_center.StockItem) []data_center.KlineItem // k线获取 空表示使用data_center.GetKlineItems
+	Workers           int                                                 // 并行协程数 0表示CPU核数
+	ShowProgress      bool                                                // 打印进度和预计剩余时间
+	ReleaseKlineItems bool                                                // RunParallel每只股票回测完释放k线缓存 全市场回测节省内存
+}
+
+// @func 默认配置 日k 不限制资金
+func DefaultConfig() Config {
+	return Config{
+		InitialCash: 0,
+		Rules:       DefaultStockTradingRules(),
+		KlineType:   data_center.KlineType_Day,
+		KlineCount:  250 * 14,
+	}
+}
+
+type symbolState struct {
+	stock       data_center.StockItem
+	kline_items []data_center.KlineItem
+	cursor      int // 下一根待处理的k线
+	pending     []Order
+}
+
+type Engine struct {
+	config      Config
+	strategy    Strategy
+	states      []*symbolState
+	day_entries []entryRequest
+	rebalances  []rebalanceOrder
+	cash        float64
+	positions   map[string]*Position
+	result      Result
+}
+
+func NewEngine(config Config) *Engine {
+	return &Engine{config: config}
+}
+
+// @func 运行回测 按日期推进 同一日期按stock_items顺序处理
+// @strategy 策略
+// @stock_items 股票池
+// @return 回测结果
+func (engine *Engine) Run(strategy Strategy, stock_items []data_center.StockItem) Result {
+	engine.strategy = strategy
+	engine.cash = engine.config.InitialCash
+	engine.positions = make(map[string]*Position)
+	engine.result = Result{InitialCash: engine.config.InitialCash}
+
+	// 并行加载k线 按stock_items顺序保存
+	var progress *Progress = nil
+	if engine.config.ShowProgress {
+		progress = NewProgress("加载k线", len(stock_items))
+	}
+	states := make([]*symbolState, len(stock_items))
+	ParallelFor(len(stock_items), engine.config.Workers, progress, func(index int) {
+		states[index] = &symbolState{stock: stock_items[index], kline_items: engine.loadKlineItems(stock_items[index])}
+	})
+	engine.states = states
+	engine.day_entries = nil
+	engine.rebalances = nil
+
+	for _, state := range states {
+		engine.result.data_fingerprints = append(engine.result.data_fingerprints, calculateKlineFingerprint(state.stock, state.kline_items))
+	}
+	engine.result.DataSnapshotId = calculateDataSnapshotId(engine.result.data_fingerprints)
+
+	dates := engine.collectDates(states)
+	if engine.config.ShowProgress {
+		progress = NewProgress("回测", len(dates))
+	}
+	for _, date := range dates {
+		engine.fillRebalanceOrders(date)
+		for _, state := range states {
+			for state.cursor < len(state.kline_items) && state.kline_items[state.cursor].Date < date {
+				state.cursor++
+			}
+			if state.cursor >= len(state.kline_items) || state.kline_items[state.cursor].Date != date {
+				continue
+			}
+
+			engine.onBar(state, state.cursor)
+			state.cursor++
+		}
+
+		engine.onDate(date)
+		engine.admitEntries()
+		engine.recordEquity(date)
+		progress.Add(1)
+	}
+
+	return engine.result
+}
+
+// @func 单只股票k线的指纹 包含股票代号和每根k线的日期 价格 成交量
+func calculateKlineFingerprint(stock_item data_center.StockItem, kline_items []data_center.KlineItem) uint64 {
+	hash := fnv.New64a()
+	buffer := []byte(stock_item.Symbol)
+	for _, kline_item := range kline_items {
+		buffer = append(buffer, '|')
+		buffer = append(buffer, kline_item.Date...)
+		for _, value := range []int64{kline_item.Open, kline_item.High, kline_item.Low, kline_item.Close, int64(kline_item.Volume)} {
+			buffer = append(buffer, ',')
+			buffer = strconv.AppendInt(buffer, value, 10)
+		}
+	}
+	hash.Write(buffer)
+	return hash.Sum64()
+}
+
+// @func 按股票顺序合并指纹
+func calculateDataSnapshotId(fingerprints []uint64) string {
+	hash := fnv.New64a()
+	for _, fingerprint := range fingerprints {
+		fmt.Fprintf(hash, "%016x", fingerprint)
+	}
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// @func 分配订单id 同时也是成交后的批次id 按下单顺序从1开始
+// @date 下单日期
+func (engine *Engine) newOrderId(date string) int64 {
+	engine.result.order_dates = append(engine.result.order_dates, date)
+	return int64(len(engine.result.order_dates))
+}
+
+func (engine *Engine) loadKlineItems(stock_item data_center.StockItem) []data_center.KlineItem {
+	if engine.config.KlineLoader != nil {
+		return engine.config.KlineLoader(stock_item)
+	}
+	return data_center.GetKlineItems(engine.config.KlineType, stock_item, engine.config.KlineCount)
+}
+
+// @func 回测区间内全部交易日
+func (engine *Engine) collectDates(states []*symbolState) []string {
+	date_set := make(map[string]bool)
+	for _, state := range states {
+		for _, kline_item := range state.kline_items {
+			if kline_item.Date < engine.config.StartDate {
+				continue
+			}
+			if len(engine.config.EndDate) > 0 && kline_item.Date >= engine.config.EndDate {
+				continue
+			}
+			date_set[kline_item.Date] = true
+		}
+	}
+
+	result := make([]string, 0, len(date_set))
+	for date := range date_set {
+		result = append(result, date)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func (engine *Engine) onBar(state *symbolState, index int) {
+	kline_item := state.kline_items[index]
+
+	// 先成交上一根k线的订单 开盘单先于收盘单 同一时点卖出优先释放资金
+	pending := state.pending
+	state.pending = nil
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Type != pending[j].Type {
+			return pending[i].Type < pending[j].Type
+		}
+		return pending[i].Side > pending[j].Side
+	})
+	for _, order := range pending {
+		if !engine.fillOrder(state, &order, index) && order.Side == OrderSide_Sell {
+			// 卖单因停牌 跌停 T+1未成交部分顺延到下一根k线
+			state.pending = append(state.pending, order)
+		}
+	}
+
+	engine.checkExits(state, index)
+
+	position, ok := engine.positions[state.stock.Symbol]
+	if ok {
+		position.LastPrice = kline_item.Close
+	}
+
+	// 策略只能看到当前k线及之前的数据
+	ctx := &BarContext{
+		engine:     engine,
+		state:      state,
+		Stock:      state.stock,
+		KlineItems: state.kline_items[0 : index+1 : index+1],
+		Index:      index,
+		Date:       kline_item.Date,
+	}
+	engine.strategy.OnBar(ctx)
+}
+
+// @func 成交订单 买单不满足条件直接取消
+// @return 订单是否已经全部完成 卖单未完成时order.Quantity会更新为剩余股数
+func (engine *Engine) fillOrder(state *symbolState, order *Order, index int) bool {
+	kline_item := state.kline_items[index]
+
+	price := kline_item.Open
+	if order.Type == OrderType_NextClose {
+		price = kline_item.Close
+	}
+	if price <= 0 {
+		return false
+	}
+
+	if order.Side == OrderSide_Buy {
+		if engine.config.Rules.CanBuy(state.stock, kline_item, price) {
+			engine.fillBuyOrder(state, *order, index, price)
+		}
+		return true
+	}
+
+	if !engine.config.Rules.CanSell(state.stock, kline_item, price) {
+		return false
+	}
+	return engine.fillSellOrder(state, order, index, price)
+}
+
+func (engine *Engine) fillBuyOrder(state *symbolState, order Order, index int, price int64) {
+	rules := engine.config.Rules
+
+	quantity := order.Quantity
+	if quantity <= 0 {
+		quantity = int64(order.Value / PriceToYuan(price))
+	}
+
+	// 成交模型决定滑点和成交量限制 买单未成交部分直接取消
+	price, quantity = engine.fillModel().Fill(FillRequest{Side: OrderSide_Buy, Price: price, Quantity: quantity, KlineItem: state.kline_items[index]})
+	quantity = rules.RoundLot(quantity)
+
+	// 资金不足时按可用资金减少股数
+	if engine.config.InitialCash > 0 {
+		affordable := rules.AffordableQuantity(engine.cash, price)
+		if quantity > affordable {
+			quantity = affordable
+		}
+	}
+	if quantity <= 0 {
+		return
+	}
+
+	value := float64(quantity) * PriceToYuan(price)
+	fee := rules.Fees.Calculate(OrderSide_Buy, value)
+	engine.cash -= value + fee
+
+	position, ok := engine.positions[state.stock.Symbol]
+	if !ok {
+		position = &Position{Stock: state.stock}
+		engine.positions[state.stock.Symbol] = position
+	}
+	position.LastPrice = price
+	position.Lots = append(position.Lots, Lot{
+		Id:         order.Id,
+		SignalDate: order.SignalDate,
+		EntryDate:  state.kline_items[index].Date,
+		EntryIndex: index,
+		EntryPrice: price,
+		Quantity:   quantity,
+		EntryFee:   fee,
+	})
+
+	engine.result.Fills = append(engine.result.Fills, Fill{OrderId: order.Id, Stock: state.stock, Side: OrderSide_Buy, Date: state.kline_items[index].Date, Price: price, Quantity: quantity, Fee: fee})
+}
+
+// @return 是否全部完成 T+1限制和成交量限制未成交的部分留到下一根k线
+func (engine *Engine) fillSellOrder(state *symbolState, order *Order, index int, price int64) bool {
+	position, ok := engine.positions[state.stock.Symbol]
+	if !ok {
+		return true
+	}
+
+	date := state.kline_items[index].Date
+	remain := order.Quantity
+	if remain <= 0 {
+		remain = position.Quantity()
+	}
+
+	// 先统计可卖股数 整笔订单只收一次佣金
+	var filled int64 = 0
+	blocked := false
+	for _, lot := range position.Lots {
+		if order.LotId > 0 && lot.Id != order.LotId {
+			continue
+		}
+		if engine.config.Rules.TPlusOne && lot.EntryDate == date {
+			blocked = true
+			continue
+		}
+		filled += lot.Quantity
+	}
+	if filled > remain {
+		filled = remain
+	}
+	if filled <= 0 {
+		return !blocked
+	}
+
+	// 成交量限制导致的部分成交 剩余部分顺延
+	fill_price, fill_quantity := engine.fillModel().Fill(FillRequest{Side: OrderSide_Sell, Price: price, Quantity: filled, KlineItem: state.kline_items[index]})
+	price = fill_price
+	if fill_quantity < filled {
+		filled = fill_quantity
+		blocked = true
+	}
+	if filled <= 0 {
+		return false
+	}
+
+	fee_total := engine.config.Rules.Fees.Calculate(OrderSide_Sell, float64(filled)*PriceToYuan(price))
+	engine.cash += float64(filled)*PriceToYuan(price) - fee_total
+
+	remain = filled
+	lots := make([]Lot, 0, len(position.Lots))
+	for _, lot := range position.Lots {
+		if remain <= 0 || (order.LotId > 0 && lot.Id != order.LotId) || (engine.config.Rules.TPlusOne && lot.EntryDate == date) {
+			lots = append(lots, lot)
+			continue
+		}
+
+		quantity := lot.Quantity
+		if quantity > remain {
+			quantity = remain
+		}
+		remain -= quantity
+
+		value := float64(quantity) * PriceToYuan(price)
+		fee := fee_total * float64(quantity) / float64(filled)
+		entry_fee := lot.EntryFee * float64(quantity) / float64(lot.Quantity)
+
+		profit := value - fee - float64(quantity)*PriceToYuan(lot.EntryPrice) - entry_fee
+		engine.result.Trades = append(engine.result.Trades, Trade{
+			Stock:       state.stock,
+			SignalDate:  lot.SignalDate,
+			EntryDate:   lot.EntryDate,
+			ExitDate:    date,
+			EntryPrice:  lot.EntryPrice,
+			ExitPrice:   price,
+			Quantity:    quantity,
+			Fee:         fee + entry_fee,
+			Profit:      profit,
+			ReturnRate:  profit / (float64(quantity)*PriceToYuan(lot.EntryPrice) + entry_fee) * 100.0,
+			HoldingBars: index - lot.EntryIndex,
+			ExitReason:  order.Reason,
+		})
+
+		lot.EntryFee -= entry_fee
+		lot.Quantity -= quantity
+		if lot.Quantity > 0 {
+			lots = append(lots, lot)
+		}
+	}
+	position.Lots = lots
+
+	engine.result.Fills = append(engine.result.Fills, Fill{OrderId: order.Id, Stock: state.stock, Side: OrderSide_Sell, Date: date, Price: price, Quantity: filled, Fee: fee_total})
+	if len(position.Lots) <= 0 {
+		delete(engine.positions, state.stock.Symbol)
+	}
+
+	if order.Quantity > 0 {
+		order.Quantity -= filled
+		return !blocked || order.Quantity <= 0
+	}
+	return !blocked
+}
+
+// @func 按股票代号排序的持仓 保证累加顺序固定
+func (engine *Engine) sortedPositions() []*Position {
+	result := make([]*Position, 0, len(engine.positions))
+	for _, position := range engine.positions {
+		result = append(result, position)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Stock.Symbol < result[j].Stock.Symbol
+	})
+	return result
+}
+
+func (engine *Engine) recordEquity(date string) {
+	position_value := 0.0
+	holdings := make([]Holding, 0, len(engine.positions))
+	for _, position := range engine.sortedPositions() {
+		position_value += position.MarketValue()
+		holdings = append(holdings, Holding{Stock: position.Stock, Quantity: position.Quantity(), Value: position.MarketValue()})
+	}
+
+	engine.result.Equity = append(engine.result.Equity, EquityPoint{
+		Date:          date,
+		Cash:          engine.cash,
+		PositionValue: position_value,
+		Equity:        engine.cash + position_value,
+		Positions:     len(engine.positions),
+		Holdings:      holdings,
+	})
+}
+
+func (engine *Engine) equity() float64 {
+	result := engine.cash
+	for _, position := range engine.sortedPositions() {
+		result += position.MarketValue()
+	}
+	return result
+}
+
+// @func 计算占用的本金 即现金从高点回落的最大值
+// @return 单位元
+func CalculateInvestedCapital(equity []EquityPoint) float64 {
+	peak_cash := 0.0
+	invested := 0.0
+	for _, point := range equity {
+		peak_cash = math.Max(peak_cash, point.Cash)
+		invested = math.Max(invested, peak_cash-point.Cash)
+	}
+	return invested
+}
@@ -0,0 +1,279 @@
+package backtest
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+type ExitPhase = int64
+
+// 多头持仓的保守价格路径 开盘->最低->最高->收盘 同一根k线止损先于止盈
+const (
+	ExitPhase_Open     ExitPhase = 0 // 开盘跳空触发 按开盘价成交
+	ExitPhase_Low      ExitPhase = 1 // 盘中下探触发 按触发价成交
+	ExitPhase_High     ExitPhase = 2 // 盘中上冲触发 按触发价成交
+	ExitPhase_Close    ExitPhase = 3 // 收盘成交
+	ExitPhase_NextOpen ExitPhase = 4 // 收盘后才能确认 下一根k线开盘成交
+)
+
+// @func 平仓检查上下文 每个持仓批次每根k线检查一次
+type ExitContext struct {
+	Stock       data_center.StockItem
+	KlineItems  []data_center.KlineItem // 截止到当前k线
+	Index       int                     // 当前k线索引
+	Lot         Lot                     // 持仓批次
+	HighestHigh int64                   // 买入后到上一根k线的最高价 单位毫
+}
+
+// @func 平仓信号
+type ExitSignal struct {
+	Phase  ExitPhase
+	Price  int64 // 成交价 单位毫 ExitPhase_NextOpen时无效
+	Reason string
+}
+
+// @func 平仓规则
+type ExitRule interface {
+	Check(ctx ExitContext) (ExitSignal, bool)
+}
+
+// @func 价格跌破止损价 开盘跳空低于止损价时按开盘价成交
+func checkStopPrice(kline_item data_center.KlineItem, stop_price int64, reason string) (ExitSignal, bool) {
+	if stop_price <= 0 {
+		return ExitSignal{}, false
+	}
+	if kline_item.Open <= stop_price {
+		return ExitSignal{Phase: ExitPhase_Open, Price: kline_item.Open, Reason: reason}, true
+	}
+	if kline_item.Low <= stop_price {
+		return ExitSignal{Phase: ExitPhase_Low, Price: stop_price, Reason: reason}, true
+	}
+	return ExitSignal{}, false
+}
+
+// @func 固定比例止损
+type StopLossExit struct {
+	Percent float64 // 0.05表示亏损5%止损
+}
+
+func (rule StopLossExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	stop_price := int64(float64(ctx.Lot.EntryPrice) * (1.0 - rule.Percent))
+	return checkStopPrice(ctx.KlineItems[ctx.Index], stop_price, "stop_loss")
+}
+
+// @func ATR止损 止损价在买入时按之前的ATR确定
+type AtrStopLossExit struct {
+	Period   int     // ATR周期
+	Multiple float64 // ATR倍数
+}
+
+func (rule AtrStopLossExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	atr := CalculateAtr(ctx.KlineItems[0:ctx.Lot.EntryIndex], rule.Period)
+	if atr <= 0 {
+		return ExitSignal{}, false
+	}
+	stop_price := ctx.Lot.EntryPrice - int64(atr*rule.Multiple)
+	return checkStopPrice(ctx.KlineItems[ctx.Index], stop_price, "atr_stop_loss")
+}
+
+// @func 固定比例止盈 开盘跳空高于止盈价时按开盘价成交
+type TakeProfitExit struct {
+	Percent float64 // 0.1表示盈利10%止盈
+}
+
+func (rule TakeProfitExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	kline_item := ctx.KlineItems[ctx.Index]
+	target_price := int64(float64(ctx.Lot.EntryPrice) * (1.0 + rule.Percent))
+	if kline_item.Open >= target_price {
+		return ExitSignal{Phase: ExitPhase_Open, Price: kline_item.Open, Reason: "take_profit"}, true
+	}
+	if kline_item.High >= target_price {
+		return ExitSignal{Phase: ExitPhase_High, Price: target_price, Reason: "take_profit"}, true
+	}
+	return ExitSignal{}, false
+}
+
+// @func 移动止损 从买入后的最高价回撤 只用到上一根k线的最高价
+type TrailingStopExit struct {
+	Percent     float64 // 回撤比例 0.08表示8% 为0时使用ATR
+	AtrPeriod   int     // ATR周期
+	AtrMultiple float64 // ATR倍数
+}
+
+func (rule TrailingStopExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	if ctx.HighestHigh <= 0 {
+		return ExitSignal{}, false
+	}
+
+	var stop_price int64 = 0
+	if rule.Percent > 0 {
+		stop_price = int64(float64(ctx.HighestHigh) * (1.0 - rule.Percent))
+	} else {
+		atr := CalculateAtr(ctx.KlineItems[0:ctx.Index], rule.AtrPeriod)
+		if atr <= 0 {
+			return ExitSignal{}, false
+		}
+		stop_price = ctx.HighestHigh - int64(atr*rule.AtrMultiple)
+	}
+	return checkStopPrice(ctx.KlineItems[ctx.Index], stop_price, "trailing_stop")
+}
+
+// @func 最长持有 持有k线数达到后收盘卖出
+type MaxHoldingExit struct {
+	Bars int
+}
+
+func (rule MaxHoldingExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	if ctx.Index-ctx.Lot.EntryIndex < rule.Bars {
+		return ExitSignal{}, false
+	}
+	return ExitSignal{Phase: ExitPhase_Close, Price: ctx.KlineItems[ctx.Index].Close, Reason: "max_holding"}, true
+}
+
+// @func 形态平仓 形态在收盘时才能确认 下一根k线开盘卖出
+type PatternExit struct {
+	Name  string
+	Match func([]data_center.KlineItem, int) bool
+}
+
+func (rule PatternExit) Check(ctx ExitContext) (ExitSignal, bool) {
+	if ctx.Index <= ctx.Lot.EntryIndex || !rule.Match(ctx.KlineItems, ctx.Index) {
+		return ExitSignal{}, false
+	}
+	return ExitSignal{Phase: ExitPhase_NextOpen, Reason: "pattern_" + rule.Name}, true
+}
+
+// @func 计算最近period根k线的平均真实波幅
+// @return 单位毫 数据不足返回0
+func CalculateAtr(kline_items []data_center.KlineItem, period int) float64 {
+	kline_items_len := len(kline_items)
+	if period <= 0 || kline_items_len < period+1 {
+		return 0.0
+	}
+
+	tr_sum := 0.0
+	for i := kline_items_len - period; i < kline_items_len; i++ {
+		pre_close := kline_items[i-1].Close
+		high := int64(math.Max(float64(kline_items[i].High), float64(pre_close)))
+		low := int64(math.Min(float64(kline_items[i].Low), float64(pre_close)))
+		tr_sum += float64(high - low)
+	}
+
+	return tr_sum / float64(period)
+}
+
+// @func 检查持仓批次的平仓规则 同一根k线按价格路径取最先触发的
+func (engine *Engine) checkExits(state *symbolState, index int) {
+	if len(engine.config.ExitRules) <= 0 {
+		return
+	}
+	position, ok := engine.positions[state.stock.Symbol]
+	if !ok {
+		return
+	}
+
+	kline_item := state.kline_items[index]
+	kline_items := state.kline_items[0 : index+1 : index+1]
+	lots := make([]Lot, len(position.Lots))
+	copy(lots, position.Lots)
+
+	for _, lot := range lots {
+		// 当日买入的批次不检查 T+1也无法卖出
+		if lot.EntryIndex >= index {
+			continue
+		}
+		if engine.hasPendingLotSell(state, lot.Id) {
+			continue
+		}
+
+		var highest_high int64 = 0
+		for i := lot.EntryIndex; i < index; i++ {
+			if kline_items[i].High > highest_high {
+				highest_high = kline_items[i].High
+			}
+		}
+
+		ctx := ExitContext{Stock: state.stock, KlineItems: kline_items, Index: index, Lot: lot, HighestHigh: highest_high}
+		found := false
+		var best ExitSignal
+		for _, rule := range engine.config.ExitRules {
+			signal, triggered := rule.Check(ctx)
+			if triggered && (!found || signal.Phase < best.Phase) {
+				best = signal
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+
+		order := Order{Id: engine.newOrderId(kline_item.Date), Stock: state.stock, Side: OrderSide_Sell, Type: OrderType_NextOpen, LotId: lot.Id, SignalDate: kline_item.Date, Reason: best.Reason}
+		if best.Phase == ExitPhase_NextOpen {
+			state.pending = append(state.pending, order)
+			continue
+		}
+		// 跌停等无法卖出时和成交量限制未卖完的部分一样 下一根k线开盘继续卖出
+		if !engine.config.Rules.CanSell(state.stock, kline_item, best.Price) {
+			state.pending = append(state.pending, order)
+			continue
+		}
+		if !engine.fillSellOrder(state, &order, index, best.Price) {
+			state.pending = append(state.pending, order)
+		}
+	}
+}
+
+func (engine *Engine) hasPendingLotSell(state *symbolState, lot_id int64) bool {
+	for _, order := range state.pending {
+		if order.Side == OrderSide_Sell && (order.LotId == lot_id || order.LotId == 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// @func 按平仓原因统计
+type ExitReasonStat struct {
+	Reason         string
+	Count          int64
+	WinRate        float64 // 已经乘了100
+	AvgReturnRate  float64 // 已经乘了100
+	TotalProfit    float64 // 单位元
+	AvgHoldingBars float64
+}
+
+// @func 按平仓原因统计交易
+func CalculateExitReasonStats(trades []Trade) []ExitReasonStat {
+	stat_map := make(map[string]*ExitReasonStat)
+	win_map := make(map[string]int64)
+	holding_map := make(map[string]int64)
+	for _, trade := range trades {
+		stat, ok := stat_map[trade.ExitReason]
+		if !ok {
+			stat = &ExitReasonStat{Reason: trade.ExitReason}
+			stat_map[trade.ExitReason] = stat
+		}
+		stat.Count++
+		stat.AvgReturnRate += trade.ReturnRate
+		stat.TotalProfit += trade.Profit
+		holding_map[trade.ExitReason] += int64(trade.HoldingBars)
+		if trade.Profit > 0 {
+			win_map[trade.ExitReason]++
+		}
+	}
+
+	result := make([]ExitReasonStat, 0, len(stat_map))
+	for reason, stat := range stat_map {
+		stat.WinRate = float64(win_map[reason]) / float64(stat.Count) * 100.0
+		stat.AvgReturnRate /= float64(stat.Count)
+		stat.AvgHoldingBars = float64(holding_map[reason]) / float64(stat.Count)
+		result = append(result, *stat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Reason < result[j].Reason
+	})
+
+	return result
+}
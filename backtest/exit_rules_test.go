@@ -0,0 +1,111 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 第一根k线下单 下一根k线开盘买入100股
+type buyOnceStrategy struct{}
+
+func (strategy *buyOnceStrategy) OnBar(ctx *BarContext) {
+	if ctx.Index == 0 {
+		ctx.Buy(100, OrderType_NextOpen)
+	}
+}
+
+// @func 前两根k线收盘10元 第二根开盘10元买入 之后是bars 涨跌幅按前一根收盘计算
+func newExitTestKlineItems(bars ...[4]int64) []data_center.KlineItem {
+	dates := []string{"20240102", "20240103", "20240104", "20240105", "20240108", "20240109"}
+	bars = append([][4]int64{{100000, 100500, 99500, 100000}, {100000, 100500, 99500, 100000}}, bars...)
+
+	kline_items := make([]data_center.KlineItem, len(bars))
+	var pre_close int64 = 100000
+	for i, bar := range bars {
+		kline_items[i] = data_center.KlineItem{Date: dates[i], Open: bar[0], High: bar[1], Low: bar[2], Close: bar[3], Volume: 1000, Percent: (float64(bar[3])/float64(pre_close) - 1.0) * 100.0}
+		pre_close = bar[3]
+	}
+	return kline_items
+}
+
+func runExitTest(kline_items []data_center.KlineItem, exit_rules []ExitRule) Result {
+	config := DefaultConfig()
+	config.ExitRules = exit_rules
+	config.KlineLoader = func(data_center.StockItem) []data_center.KlineItem { return kline_items }
+	return NewEngine(config).Run(&buyOnceStrategy{}, []data_center.StockItem{{Symbol: "SH600000", Name: "浦发银行"}})
+}
+
+// 同一根k线按 开盘->最低->最高->收盘->下一根开盘 的顺序取最先触发的平仓规则
+func TestExitPhaseOrder(t *testing.T) {
+	stop_loss := StopLossExit{Percent: 0.05}    // 9.5元
+	take_profit := TakeProfitExit{Percent: 0.1} // 11元
+	max_holding := MaxHoldingExit{Bars: 1}      // 买入后第一根k线收盘
+	pattern := PatternExit{Name: "any", Match: func([]data_center.KlineItem, int) bool { return true }}
+
+	cases := []struct {
+		name       string
+		bars       [][4]int64
+		exit_rules []ExitRule
+		reason     string
+		exit_date  string
+		exit_price int64
+	}{
+		{"跳空低开止损先于盘中止盈", [][4]int64{{94000, 112000, 93000, 108000}}, []ExitRule{take_profit, stop_loss}, "stop_loss", "20240104", 94000},
+		{"跳空高开止盈先于盘中止损", [][4]int64{{112000, 113000, 94000, 100000}}, []ExitRule{stop_loss, take_profit}, "take_profit", "20240104", 112000},
+		{"盘中同时触发时止损先于止盈", [][4]int64{{100000, 112000, 94000, 100000}}, []ExitRule{take_profit, stop_loss}, "stop_loss", "20240104", 95000},
+		{"盘中止盈先于收盘", [][4]int64{{100000, 112000, 99000, 105000}}, []ExitRule{max_holding, take_profit}, "take_profit", "20240104", 110000},
+		{"收盘先于下一根开盘", [][4]int64{{100000, 101000, 99000, 100500}, {102000, 103000, 101000, 102000}}, []ExitRule{pattern, max_holding}, "max_holding", "20240104", 100500},
+		{"形态下一根开盘卖出", [][4]int64{{100000, 101000, 99000, 100500}, {102000, 103000, 101000, 102000}}, []ExitRule{pattern}, "pattern_any", "20240105", 102000},
+		{"没有触发", [][4]int64{{100000, 101000, 99000, 100500}}, []ExitRule{stop_loss, take_profit}, "", "", 0},
+	}
+
+	for _, c := range cases {
+		result := runExitTest(newExitTestKlineItems(c.bars...), c.exit_rules)
+		if len(c.reason) <= 0 {
+			if len(result.Trades) != 0 {
+				t.Errorf("%s: got %d trades want 0", c.name, len(result.Trades))
+			}
+			continue
+		}
+		if len(result.Trades) != 1 {
+			t.Errorf("%s: got %d trades want 1", c.name, len(result.Trades))
+			continue
+		}
+		trade := result.Trades[0]
+		if trade.ExitReason != c.reason || trade.ExitDate != c.exit_date || trade.ExitPrice != c.exit_price {
+			t.Errorf("%s: got %s %s %d want %s %s %d", c.name, trade.ExitReason, trade.ExitDate, trade.ExitPrice, c.reason, c.exit_date, c.exit_price)
+		}
+	}
+}
+
+// 一字跌停卖不出的平仓顺延到下一根k线开盘 直到卖出
+func TestExitBlockedBySellRequeued(t *testing.T) {
+	stop_loss := StopLossExit{Percent: 0.05}
+	cases := []struct {
+		name       string
+		bars       [][4]int64
+		exit_date  string
+		exit_price int64
+	}{
+		{"跌停一天", [][4]int64{{90000, 90000, 90000, 90000}, {88000, 89000, 85000, 86000}}, "20240105", 88000},
+		{"连续跌停", [][4]int64{{90000, 90000, 90000, 90000}, {81000, 81000, 81000, 81000}, {80000, 82000, 78000, 79000}}, "20240108", 80000},
+		{"跌停后反弹仍然卖出", [][4]int64{{90000, 90000, 90000, 90000}, {96000, 98000, 95500, 97000}}, "20240105", 96000},
+		{"跌停打开按开盘价卖出", [][4]int64{{90000, 93000, 90000, 90000}}, "20240104", 90000},
+	}
+
+	for _, c := range cases {
+		result := runExitTest(newExitTestKlineItems(c.bars...), []ExitRule{stop_loss})
+		if len(result.Trades) != 1 {
+			t.Errorf("%s: got %d trades want 1", c.name, len(result.Trades))
+			continue
+		}
+		trade := result.Trades[0]
+		if trade.ExitReason != "stop_loss" || trade.ExitDate != c.exit_date || trade.ExitPrice != c.exit_price {
+			t.Errorf("%s: got %s %s %d want stop_loss %s %d", c.name, trade.ExitReason, trade.ExitDate, trade.ExitPrice, c.exit_date, c.exit_price)
+		}
+		if trade.Quantity != 100 {
+			t.Errorf("%s: got quantity %d want 100", c.name, trade.Quantity)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// @func 回测运行清单 记录参数和数据快照 便于复现
+type RunManifest struct {
+	Name           string            `json:"name"`
+	CreatedAt      string            `json:"created_at"`
+	DataSnapshotId string            `json:"data_snapshot_id"`
+	StockCount     int               `json:"stock_count"`
+	StartDate      string            `json:"start_date"` // 资金曲线的第一天
+	EndDate        string            `json:"end_date"`   // 资金曲线的最后一天
+	Parameters     map[string]string `json:"parameters"` // 策略参数和回测配置
+	Metrics        Metrics           `json:"metrics"`
+}
+
+// @func 导出的交易记录 价格单位元
+type TradeRecord struct {
+	Symbol      string  `json:"symbol"`
+	Name        string  `json:"name"`
+	SignalDate  string  `json:"signal_date"`
+	EntryDate   string  `json:"entry_date"`
+	EntryPrice  float64 `json:"entry_price"`
+	ExitDate    string  `json:"exit_date"`
+	ExitPrice   float64 `json:"exit_price"`
+	Quantity    int64   `json:"quantity"`
+	Fee         float64 `json:"fee"`
+	Profit      float64 `json:"profit"`
+	ReturnRate  float64 `json:"return_rate"`
+	HoldingBars int     `json:"holding_bars"`
+	ExitReason  string  `json:"exit_reason"`
+}
+
+// @func 导出的每日资金和持仓
+type EquityRecord struct {
+	Date          string  `json:"date"`
+	Cash          float64 `json:"cash"`
+	PositionValue float64 `json:"position_value"`
+	Equity        float64 `json:"equity"`
+	Positions     int     `json:"positions"`
+	Drawdown      float64 `json:"drawdown"` // 相对之前最高资产的回撤 已经乘了100
+}
+
+// @func 导出的每日每只股票持仓
+type HoldingRecord struct {
+	Date     string  `json:"date"`
+	Symbol   string  `json:"symbol"`
+	Name     string  `json:"name"`
+	Quantity int64   `json:"quantity"`
+	Value    float64 `json:"value"`  // 按当日收盘价计算的市值 单位元
+	Weight   float64 `json:"weight"` // 占当日总资产的比例 已经乘了100 不限制资金时加上占用本金
+}
+
+// @func 回测配置转换为清单参数
+func ConfigParameters(config Config) map[string]string {
+	return map[string]string{
+		"initial_cash":  strconv.FormatFloat(config.InitialCash, 'f', -1, 64),
+		"max_positions": strconv.Itoa(config.MaxPositions),
+		"start_date":    config.StartDate,
+		"end_date":      config.EndDate,
+		"kline_type":    strconv.FormatInt(config.KlineType, 10),
+		"kline_count":   strconv.FormatUint(config.KlineCount, 10),
+		"trading_rules": fmt.Sprintf("%+v", config.Rules),
+		"sizer":         formatTypedValue(config.Sizer),
+		"exit_rules":    fmt.Sprintf("%+v", config.ExitRules),
+		"fill_model":    formatTypedValue(config.FillModel),
+		"custom_loader": strconv.FormatBool(config.KlineLoader != nil),
+	}
+}
+
+// @func 网格配置转换为清单参数
+func GridConfigParameters(config GridConfig) map[string]string {
+	return map[string]string{
+		"grid_type":     strconv.FormatInt(config.Type, 10),
+		"lower_price":   formatFloat(PriceToYuan(config.LowerPrice)),
+		"upper_price":   formatFloat(PriceToYuan(config.UpperPrice)),
+		"grid_count":    strconv.Itoa(config.GridCount),
+		"budget":        formatFloat(config.Budget),
+		"base_position": formatFloat(config.BasePosition),
+		"reserve":       formatFloat(config.Reserve),
+		"trading_rules": fmt.Sprintf("%+v", config.Rules),
+		"fill_model":    formatTypedValue(config.FillModel),
+	}
+}
+
+// @func 定投配置转换为清单参数
+func DcaConfigParameters(config DcaConfig) map[string]string {
+	return map[string]string{
+		"schedule":      strconv.FormatInt(config.Schedule, 10),
+		"day":           strconv.Itoa(config.Day),
+		"amount":        formatFloat(config.Amount),
+		"mode":          strconv.FormatInt(config.Mode, 10),
+		"max_multiple":  formatFloat(config.MaxMultiple),
+		"allow_sell":    strconv.FormatBool(config.AllowSell),
+		"multiplier":    strconv.FormatBool(config.Multiplier != nil),
+		"trading_rules": fmt.Sprintf("%+v", config.Rules),
+	}
+}
+
+// @func 生成运行清单
+// @parameters 策略参数和回测配置 可以为空
+func NewRunManifest(name string, parameters map[string]string, stock_count int, result Result) RunManifest {
+	manifest := RunManifest{
+		Name:           name,
+		CreatedAt:      time.Now().Local().Format("2006-01-02 15:04:05"),
+		DataSnapshotId: result.DataSnapshotId,
+		StockCount:     stock_count,
+		Parameters:     parameters,
+		Metrics:        CalculateResultMetrics(result),
+	}
+	if manifest.Parameters == nil {
+		manifest.Parameters = make(map[string]string)
+	}
+	if len(result.Equity) > 0 {
+		manifest.StartDate = result.Equity[0].Date
+		manifest.EndDate = result.Equity[len(result.Equity)-1].Date
+	}
+	return manifest
+}
+
+// @func 交易转换为导出记录
+func NewTradeRecords(trades []Trade) []TradeRecord {
+	result := make([]TradeRecord, 0, len(trades))
+	for _, trade := range trades {
+		result = append(result, TradeRecord{
+			Symbol:      trade.Stock.Symbol,
+			Name:        trade.Stock.Name,
+			SignalDate:  trade.SignalDate,
+			EntryDate:   trade.EntryDate,
+			EntryPrice:  PriceToYuan(trade.EntryPrice),
+			ExitDate:    trade.ExitDate,
+			ExitPrice:   PriceToYuan(trade.ExitPrice),
+			Quantity:    trade.Quantity,
+			Fee:         trade.Fee,
+			Profit:      trade.Profit,
+			ReturnRate:  trade.ReturnRate,
+			HoldingBars: trade.HoldingBars,
+			ExitReason:  trade.ExitReason,
+		})
+	}
+	return result
+}
+
+// @func 资金曲线转换为导出记录 不限制资金时加上占用本金
+func NewEquityRecords(result Result) []EquityRecord {
+	equity, initial_equity := resultEquity(result)
+	records := make([]EquityRecord, 0, len(equity))
+	peak := initial_equity
+	for _, point := range equity {
+		peak = math.Max(peak, point.Equity)
+		drawdown := 0.0
+		if peak > 0 {
+			drawdown = (1.0 - point.Equity/peak) * 100.0
+		}
+		records = append(records, EquityRecord{
+			Date:          point.Date,
+			Cash:          point.Cash,
+			PositionValue: point.PositionValue,
+			Equity:        point.Equity,
+			Positions:     point.Positions,
+			Drawdown:      drawdown,
+		})
+	}
+	return records
+}
+
+// @func 资金曲线中的持仓转换为导出记录 按日期和代号排序
+func NewHoldingRecords(result Result) []HoldingRecord {
+	equity, _ := resultEquity(result)
+	records := make([]HoldingRecord, 0)
+	for _, point := range equity {
+		for _, holding := range point.Holdings {
+			weight := 0.0
+			if point.Equity > 0 {
+				weight = holding.Value / point.Equity * 100.0
+			}
+			records = append(records, HoldingRecord{
+				Date:     point.Date,
+				Symbol:   holding.Stock.Symbol,
+				Name:     holding.Stock.Name,
+				Quantity: holding.Quantity,
+				Value:    holding.Value,
+				Weight:   weight,
+			})
+		}
+	}
+	return records
+}
+
+// @func 导出回测结果 每次运行一个目录 包含交易 资金曲线 每日持仓 运行清单 各自的CSV和JSON
+// @dir 输出根目录
+// @return 本次运行的目录
+func ExportResult(dir string, manifest RunManifest, result Result) (string, error) {
+	run_dir := filepath.Join(dir, fmt.Sprintf("%s_%s_%s", manifest.Name, time.Now().Local().Format("20060102150405"), manifest.DataSnapshotId))
+	err := os.MkdirAll(run_dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	trades := NewTradeRecords(result.Trades)
+	equity := NewEquityRecords(result)
+	holdings := NewHoldingRecords(result)
+
+	trade_rows := [][]string{{"symbol", "name", "signal_date", "entry_date", "entry_price", "exit_date", "exit_price", "quantity", "fee", "profit", "return_rate", "holding_bars", "exit_reason"}}
+	for _, trade := range trades {
+		trade_rows = append(trade_rows, []string{
+			trade.Symbol, trade.Name, trade.SignalDate,
+			trade.EntryDate, formatFloat(trade.EntryPrice),
+			trade.ExitDate, formatFloat(trade.ExitPrice),
+			strconv.FormatInt(trade.Quantity, 10), formatFloat(trade.Fee), formatFloat(trade.Profit), formatFloat(trade.ReturnRate),
+			strconv.Itoa(trade.HoldingBars), trade.ExitReason,
+		})
+	}
+
+	equity_rows := [][]string{{"date", "cash", "position_value", "equity", "positions", "drawdown"}}
+	for _, point := range equity {
+		equity_rows = append(equity_rows, []string{
+			point.Date, formatFloat(point.Cash), formatFloat(point.PositionValue), formatFloat(point.Equity), strconv.Itoa(point.Positions), formatFloat(point.Drawdown),
+		})
+	}
+
+	holding_rows := [][]string{{"date", "symbol", "name", "quantity", "value", "weight"}}
+	for _, holding := range holdings {
+		holding_rows = append(holding_rows, []string{
+			holding.Date, holding.Symbol, holding.Name, strconv.FormatInt(holding.Quantity, 10), formatFloat(holding.Value), formatFloat(holding.Weight),
+		})
+	}
+
+	manifest_rows := [][]string{
+		{"key", "value"},
+		{"name", manifest.Name},
+		{"created_at", manifest.CreatedAt},
+		{"data_snapshot_id", manifest.DataSnapshotId},
+		{"stock_count", strconv.Itoa(manifest.StockCount)},
+		{"start_date", manifest.StartDate},
+		{"end_date", manifest.EndDate},
+	}
+	names := make([]string, 0, len(manifest.Parameters))
+	for name := range manifest.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		manifest_rows = append(manifest_rows, []string{"parameter." + name, manifest.Parameters[name]})
+	}
+
+	files := []struct {
+		name  string
+		rows  [][]string
+		value interface{}
+	}{
+		{"trades", trade_rows, trades},
+		{"equity", equity_rows, equity},
+		{"holdings", holding_rows, holdings},
+		{"manifest", manifest_rows, manifest},
+	}
+	for _, file := range files {
+		err = writeCsvFile(filepath.Join(run_dir, file.name+".csv"), file.rows)
+		if err != nil {
+			return run_dir, err
+		}
+		err = writeJsonFile(filepath.Join(run_dir, file.name+".json"), file.value)
+		if err != nil {
+			return run_dir, err
+		}
+	}
+
+	return run_dir, nil
+}
+
+// @func 接口值连同类型名输出 如 backtest.FixedBpsSlippage{Bps:5}
+func formatTypedValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T%+v", value, value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func writeCsvFile(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 带BOM方便Excel直接打开中文
+	file.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(file)
+	writer.WriteAll(rows)
+	return writer.Error()
+}
+
+func writeJsonFile(path string, value interface{}) error {
+	body, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0644)
+}
@@ -0,0 +1,433 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 截面因子研究配置
+type FactorConfig struct {
+	Name string
+	// 第index根k线收盘时的因子值 只能使用index及之前的数据 数据不足返回NaN
+	Value         func(stock_item data_center.StockItem, kline_items []data_center.KlineItem, index int) float64
+	StartDate     string            // 第一个调仓日不早于该日期 空表示不限制
+	EndDate       string            // 最后一个调仓日不晚于该日期 空表示不限制
+	RebalanceDays int               // 调仓间隔交易日 也是IC序列和分组收益的持有期
+	DecayHorizons []int             // IC衰减的持有期 交易日
+	Quantiles     int               // 按因子值从小到大分成几组
+	WinsorizeMad  float64           // 去极值 超出中位数几倍MAD的截断 0表示不处理
+	Standardize   bool              // 是否标准化为均值0标准差1
+	Industries    map[string]string // 股票代号 -> 行业 不为空时在行业内去均值做行业中性化 没有行业的股票单独一组
+	MinStocks     int               // 截面有效股票数下限 不足的调仓日跳过
+
+	Workers           int  // 并行加载k线的协程数 0表示CPU核数
+	ShowProgress      bool // 打印进度和预计剩余时间
+	ReleaseKlineItems bool // 每只股票计算完释放k线缓存 全市场研究节省内存
+}
+
+// @func 默认配置 每20个交易日调仓 分5组 3倍MAD去极值后标准化
+func DefaultFactorConfig() FactorConfig {
+	return FactorConfig{
+		RebalanceDays: 20,
+		DecayHorizons: []int{1, 5, 10, 20, 40, 60},
+		Quantiles:     5,
+		WinsorizeMad:  3.0,
+		Standardize:   true,
+		MinStocks:     50,
+	}
+}
+
+// @func 一个调仓日的截面结果 比例都已经乘了100
+type FactorPeriod struct {
+	Date            string
+	Stocks          int       // 有效股票数
+	Ic              float64   // 因子值与持有期收益率的相关系数
+	RankIc          float64   // 排名的相关系数
+	QuantileReturns []float64 // 各组持有期平均收益率 从因子值最小的组开始
+	LongShort       float64   // 因子值最大的组减最小的组
+}
+
+// @func 某个持有期的平均IC
+type FactorDecay struct {
+	Horizon int // 持有期 交易日
+	Periods int // 参与平均的调仓日数
+	Ic      float64
+	RankIc  float64
+}
+
+// @func 因子研究结果 比例都已经乘了100
+type FactorResult struct {
+	Name    string
+	Periods []FactorPeriod
+	Decay   []FactorDecay
+
+	IcMean             float64
+	IcStd              float64
+	Icir               float64 // IC均值除以IC标准差 未年化
+	RankIcMean         float64
+	RankIcStd          float64
+	RankIcir           float64
+	RankIcPositiveRate float64 // RankIC大于0的调仓日占比
+
+	QuantileTotalReturns  []float64 // 各组按调仓日复利的总收益率
+	QuantileAnnualReturns []float64 // 各组年化收益率
+	LongShortTotalReturn  float64   // 最大组减最小组按调仓日复利的总收益率
+}
+
+// @func 单只股票在每个调仓日的因子值和各持有期收益率 没有数据为NaN
+type factorStockData struct {
+	values  []float64
+	returns [][]float64 // [调仓日][持有期]
+}
+
+// @func 截面因子研究 计算每个调仓日的因子值 去极值 标准化 行业中性化后统计IC RankIC IC衰减和分组收益
+// 持有期收益率为调仓日收盘到持有期最后一个交易日收盘 该日停牌取之前最近的收盘价 数据不到该日的股票不参与
+// @stock_items 股票池
+// @calendar 交易日历 按日期排序 如指数的k线日期
+func RunFactorResearch(config FactorConfig, stock_items []data_center.StockItem, calendar []string) FactorResult {
+	result := FactorResult{Name: config.Name}
+	if config.Value == nil || config.RebalanceDays <= 0 || config.Quantiles <= 0 {
+		return result
+	}
+
+	// 调仓日在日历中的位置
+	rebalances := make([]int, 0)
+	for i := 0; i < len(calendar); i++ {
+		if calendar[i] < config.StartDate || (len(config.EndDate) > 0 && calendar[i] > config.EndDate) {
+			continue
+		}
+		if len(rebalances) <= 0 || i-rebalances[len(rebalances)-1] >= config.RebalanceDays {
+			rebalances = append(rebalances, i)
+		}
+	}
+
+	// 第一个持有期就是调仓间隔 其余为衰减的持有期
+	horizons := []int{config.RebalanceDays}
+	for _, horizon := range config.DecayHorizons {
+		if horizon > 0 && horizon != config.RebalanceDays {
+			horizons = append(horizons, horizon)
+		}
+	}
+
+	var progress *Progress = nil
+	if config.ShowProgress {
+		progress = NewProgress("因子"+config.Name, len(stock_items))
+	}
+	stock_data := make([]factorStockData, len(stock_items))
+	ParallelFor(len(stock_items), config.Workers, progress, func(index int) {
+		stock_data[index] = calculateFactorStockData(config, stock_items[index], calendar, rebalances, horizons)
+		if config.ReleaseKlineItems {
+			data_center.ReleaseKlineItems(stock_items[index])
+		}
+	})
+
+	industries := make([]string, len(stock_items))
+	for i, stock_item := range stock_items {
+		industries[i] = config.Industries[stock_item.Symbol]
+	}
+
+	decay_ic := make([][]float64, len(horizons))
+	decay_rank_ic := make([][]float64, len(horizons))
+	for r, position := range rebalances {
+		stock_indexes := make([]int, 0)
+		values := make([]float64, 0)
+		for i := range stock_items {
+			if !math.IsNaN(stock_data[i].values[r]) {
+				stock_indexes = append(stock_indexes, i)
+				values = append(values, stock_data[i].values[r])
+			}
+		}
+		if len(values) < config.MinStocks || len(values) < config.Quantiles {
+			continue
+		}
+		values = preprocessFactorValues(config, values, stock_indexes, industries)
+
+		for h := range horizons {
+			factor_values := make([]float64, 0, len(values))
+			returns := make([]float64, 0, len(values))
+			for j, i := range stock_indexes {
+				forward := stock_data[i].returns[r][h]
+				if !math.IsNaN(forward) {
+					factor_values = append(factor_values, values[j])
+					returns = append(returns, forward)
+				}
+			}
+			if len(returns) < config.MinStocks || len(returns) < config.Quantiles {
+				continue
+			}
+
+			ic := calculateCorrelation(factor_values, returns) * 100.0
+			rank_ic := calculateCorrelation(calculateRanks(factor_values), calculateRanks(returns)) * 100.0
+			decay_ic[h] = append(decay_ic[h], ic)
+			decay_rank_ic[h] = append(decay_rank_ic[h], rank_ic)
+			if h != 0 {
+				continue
+			}
+
+			period := FactorPeriod{Date: calendar[position], Stocks: len(returns), Ic: ic, RankIc: rank_ic}
+			period.QuantileReturns = calculateQuantileReturns(factor_values, returns, config.Quantiles)
+			period.LongShort = period.QuantileReturns[config.Quantiles-1] - period.QuantileReturns[0]
+			result.Periods = append(result.Periods, period)
+		}
+	}
+
+	for h, horizon := range horizons {
+		result.Decay = append(result.Decay, FactorDecay{Horizon: horizon, Periods: len(decay_ic[h]), Ic: calculateAverage(decay_ic[h]), RankIc: calculateAverage(decay_rank_ic[h])})
+	}
+	sort.SliceStable(result.Decay, func(i, j int) bool {
+		return result.Decay[i].Horizon < result.Decay[j].Horizon
+	})
+	summarizeFactorPeriods(&result, config)
+
+	return result
+}
+
+// @func 计算单只股票在每个调仓日的因子值和各持有期收益率
+func calculateFactorStockData(config FactorConfig, stock_item data_center.StockItem, calendar []string, rebalances []int, horizons []int) factorStockData {
+	data := factorStockData{values: make([]float64, len(rebalances)), returns: make([][]float64, len(rebalances))}
+	kline_items := data_center.GetKlineItems(data_center.KlineType_Day, stock_item, 250*14)
+	kline_items_len := len(kline_items)
+	for r, position := range rebalances {
+		data.values[r] = math.NaN()
+		data.returns[r] = make([]float64, len(horizons))
+		for h := range horizons {
+			data.returns[r][h] = math.NaN()
+		}
+
+		// 调仓日停牌的不参与
+		date := calendar[position]
+		index := sort.Search(kline_items_len, func(i int) bool {
+			return kline_items[i].Date >= date
+		})
+		if index >= kline_items_len || kline_items[index].Date != date || kline_items[index].Close <= 0 {
+			continue
+		}
+		data.values[r] = config.Value(stock_item, kline_items, index)
+
+		for h, horizon := range horizons {
+			if position+horizon >= len(calendar) {
+				continue
+			}
+			end_date := calendar[position+horizon]
+			if kline_items[kline_items_len-1].Date < end_date {
+				continue
+			}
+			// 持有期最后一个交易日或之前最近的收盘价
+			end := sort.Search(kline_items_len, func(i int) bool {
+				return kline_items[i].Date > end_date
+			}) - 1
+			data.returns[r][h] = float64(kline_items[end].Close)/float64(kline_items[index].Close) - 1.0
+		}
+	}
+	return data
+}
+
+// @func 截面预处理 去极值 标准化 行业中性化
+// @stock_indexes values中每个值对应的股票索引
+// @industries 每只股票的行业
+func preprocessFactorValues(config FactorConfig, values []float64, stock_indexes []int, industries []string) []float64 {
+	result := make([]float64, len(values))
+	copy(result, values)
+
+	if config.WinsorizeMad > 0 {
+		median := calculateMedian(result)
+		deviations := make([]float64, len(result))
+		for i, value := range result {
+			deviations[i] = math.Abs(value - median)
+		}
+		// 1.4826倍MAD与正态分布的标准差一致 超过一半的值相同时MAD为0 不处理
+		bound := config.WinsorizeMad * 1.4826 * calculateMedian(deviations)
+		for i, value := range result {
+			if bound > 0 {
+				result[i] = math.Max(median-bound, math.Min(median+bound, value))
+			}
+		}
+	}
+	if config.Standardize {
+		standardizeValues(result)
+	}
+	if len(config.Industries) > 0 {
+		industry_sum := make(map[string]float64)
+		industry_count := make(map[string]int)
+		for i, value := range result {
+			industry := industries[stock_indexes[i]]
+			industry_sum[industry] += value
+			industry_count[industry]++
+		}
+		for i := range result {
+			industry := industries[stock_indexes[i]]
+			result[i] -= industry_sum[industry] / float64(industry_count[industry])
+		}
+		if config.Standardize {
+			standardizeValues(result)
+		}
+	}
+	return result
+}
+
+// @func 按因子值从小到大等分成quantiles组 计算每组平均收益率
+// @return 已经乘了100
+func calculateQuantileReturns(values []float64, returns []float64, quantiles int) []float64 {
+	indexes := make([]int, len(values))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return values[indexes[i]] < values[indexes[j]]
+	})
+
+	sums := make([]float64, quantiles)
+	counts := make([]int, quantiles)
+	for rank, index := range indexes {
+		group := rank * quantiles / len(indexes)
+		sums[group] += returns[index]
+		counts[group]++
+	}
+	result := make([]float64, quantiles)
+	for group := range result {
+		if counts[group] > 0 {
+			result[group] = sums[group] / float64(counts[group]) * 100.0
+		}
+	}
+	return result
+}
+
+// @func 汇总IC和分组收益
+func summarizeFactorPeriods(result *FactorResult, config FactorConfig) {
+	periods_len := len(result.Periods)
+	if periods_len <= 0 {
+		return
+	}
+
+	ics := make([]float64, periods_len)
+	rank_ics := make([]float64, periods_len)
+	positive := 0
+	result.QuantileTotalReturns = make([]float64, config.Quantiles)
+	result.QuantileAnnualReturns = make([]float64, config.Quantiles)
+	totals := make([]float64, config.Quantiles)
+	for group := range totals {
+		totals[group] = 1.0
+	}
+	long_short_total := 1.0
+	for i, period := range result.Periods {
+		ics[i] = period.Ic
+		rank_ics[i] = period.RankIc
+		if period.RankIc > 0 {
+			positive++
+		}
+		for group, value := range period.QuantileReturns {
+			totals[group] *= 1.0 + value/100.0
+		}
+		long_short_total *= 1.0 + period.LongShort/100.0
+	}
+
+	result.IcMean = calculateAverage(ics)
+	result.IcStd = CalculateStd(ics)
+	result.RankIcMean = calculateAverage(rank_ics)
+	result.RankIcStd = CalculateStd(rank_ics)
+	if result.IcStd > 0 {
+		result.Icir = result.IcMean / result.IcStd
+	}
+	if result.RankIcStd > 0 {
+		result.RankIcir = result.RankIcMean / result.RankIcStd
+	}
+	result.RankIcPositiveRate = float64(positive) / float64(periods_len) * 100.0
+
+	years := float64(periods_len*config.RebalanceDays) / kTradeDaysPerYear
+	for group, total := range totals {
+		result.QuantileTotalReturns[group] = (total - 1.0) * 100.0
+		if total > 0 && years > 0 {
+			result.QuantileAnnualReturns[group] = (math.Pow(total, 1.0/years) - 1.0) * 100.0
+		}
+	}
+	result.LongShortTotalReturn = (long_short_total - 1.0) * 100.0
+}
+
+// @func 标准化为均值0标准差1 标准差为0时全部为0
+func standardizeValues(values []float64) {
+	avg := calculateAverage(values)
+	std := CalculateStd(values)
+	for i := range values {
+		if std > 0 {
+			values[i] = (values[i] - avg) / std
+		} else {
+			values[i] = 0.0
+		}
+	}
+}
+
+// @func 中位数
+func calculateMedian(array []float64) float64 {
+	array_len := len(array)
+	if array_len <= 0 {
+		return 0.0
+	}
+	sorted := make([]float64, array_len)
+	copy(sorted, array)
+	sort.Float64s(sorted)
+	if array_len%2 == 1 {
+		return sorted[array_len/2]
+	}
+	return (sorted[array_len/2-1] + sorted[array_len/2]) / 2.0
+}
+
+// @func 皮尔逊相关系数 任意一边方差为0时为0
+func calculateCorrelation(x []float64, y []float64) float64 {
+	x_avg := calculateAverage(x)
+	y_avg := calculateAverage(y)
+	covariance, x_variance, y_variance := 0.0, 0.0, 0.0
+	for i := range x {
+		covariance += (x[i] - x_avg) * (y[i] - y_avg)
+		x_variance += (x[i] - x_avg) * (x[i] - x_avg)
+		y_variance += (y[i] - y_avg) * (y[i] - y_avg)
+	}
+	if x_variance <= 0 || y_variance <= 0 {
+		return 0.0
+	}
+	return covariance / math.Sqrt(x_variance*y_variance)
+}
+
+// @func 排名 从1开始 相同的值取平均排名
+func calculateRanks(array []float64) []float64 {
+	indexes := make([]int, len(array))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return array[indexes[i]] < array[indexes[j]]
+	})
+
+	result := make([]float64, len(array))
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && array[indexes[j+1]] == array[indexes[i]] {
+			j++
+		}
+		rank := float64(i+j)/2.0 + 1.0
+		for k := i; k <= j; k++ {
+			result[indexes[k]] = rank
+		}
+		i = j + 1
+	}
+	return result
+}
+
+func (result FactorResult) String() string {
+	output := fmt.Sprintf("因子 %s 调仓日 %d 个\n", result.Name, len(result.Periods))
+	output += fmt.Sprintf("IC均值 %.2f%% IC标准差 %.2f%% ICIR %.2f RankIC均值 %.2f%% RankIC标准差 %.2f%% RankICIR %.2f RankIC为正 %.2f%%\n",
+		result.IcMean, result.IcStd, result.Icir, result.RankIcMean, result.RankIcStd, result.RankIcir, result.RankIcPositiveRate)
+
+	output += "IC衰减:"
+	for _, decay := range result.Decay {
+		output += fmt.Sprintf(" %d日 %.2f%%|%.2f%%", decay.Horizon, decay.Ic, decay.RankIc)
+	}
+	output += "\n分组收益 从因子值最小的组开始:"
+	for group := range result.QuantileTotalReturns {
+		output += fmt.Sprintf(" 第%d组 %.2f%%|年化%.2f%%", group+1, result.QuantileTotalReturns[group], result.QuantileAnnualReturns[group])
+	}
+	output += fmt.Sprintf("\n多空总收益 %.2f%%", result.LongShortTotalReturn)
+	return output
+}
@@ -0,0 +1,106 @@
+package backtest
+
+import (
+	"math"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 成交请求
+type FillRequest struct {
+	Side      OrderSide
+	Price     int64 // 参考价 开盘价 收盘价或者触发价 单位毫
+	Quantity  int64 // 委托股数
+	KlineItem data_center.KlineItem
+}
+
+// @func 成交模型
+type FillModel interface {
+	// @return 成交价 单位毫 成交股数 股数小于委托时为部分成交
+	Fill(request FillRequest) (int64, int64)
+}
+
+// @func 按参考价全部成交
+type PerfectFill struct{}
+
+func (model PerfectFill) Fill(request FillRequest) (int64, int64) {
+	return request.Price, request.Quantity
+}
+
+// @func 固定滑点 买入加价卖出减价 不超出当根k线的最高最低价
+type FixedBpsSlippage struct {
+	Bps float64 // 万分之几 5表示0.05%
+}
+
+func (model FixedBpsSlippage) Fill(request FillRequest) (int64, int64) {
+	slippage := float64(request.Price) * model.Bps / 10000.0
+	return applySlippage(request, slippage), request.Quantity
+}
+
+// @func 按k线振幅比例滑点 振幅越大滑点越大
+type RangeSlippage struct {
+	Fraction float64 // 振幅的比例 0.1表示最高最低价差的10%
+}
+
+func (model RangeSlippage) Fill(request FillRequest) (int64, int64) {
+	slippage := float64(request.KlineItem.High-request.KlineItem.Low) * model.Fraction
+	return applySlippage(request, slippage), request.Quantity
+}
+
+// @func 成交量限制 单根k线最多成交成交量的Rate 价格由Inner决定
+type VolumeParticipationFill struct {
+	Rate  float64   // 0.05表示最多成交当根k线成交量的5%
+	Inner FillModel // 价格模型 空表示参考价
+}
+
+func (model VolumeParticipationFill) Fill(request FillRequest) (int64, int64) {
+	price, quantity := request.Price, request.Quantity
+	if model.Inner != nil {
+		price, quantity = model.Inner.Fill(request)
+	}
+
+	max_quantity := int64(float64(request.KlineItem.Volume) * model.Rate)
+	if quantity > max_quantity {
+		quantity = max_quantity
+	}
+
+	return price, quantity
+}
+
+// @func 按当根k线成交均价成交 成交额除以成交量 适合全天分批执行的订单
+type VwapFill struct{}
+
+func (model VwapFill) Fill(request FillRequest) (int64, int64) {
+	if request.KlineItem.Volume <= 0 || request.KlineItem.Amount <= 0 {
+		return request.Price, request.Quantity
+	}
+
+	vwap := int64(math.Round(float64(request.KlineItem.Amount) / float64(request.KlineItem.Volume)))
+	return clampPrice(request.KlineItem, vwap), request.Quantity
+}
+
+func applySlippage(request FillRequest, slippage float64) int64 {
+	price := float64(request.Price) + slippage
+	if request.Side == OrderSide_Sell {
+		price = float64(request.Price) - slippage
+	}
+	return clampPrice(request.KlineItem, int64(math.Round(price)))
+}
+
+// @func 成交价限制在当根k线最高最低价之间
+func clampPrice(kline_item data_center.KlineItem, price int64) int64 {
+	if kline_item.High > 0 && price > kline_item.High {
+		price = kline_item.High
+	}
+	if kline_item.Low > 0 && price < kline_item.Low {
+		price = kline_item.Low
+	}
+	return price
+}
+
+func (engine *Engine) fillModel() FillModel {
+	if engine.config.FillModel == nil {
+		return PerfectFill{}
+	}
+	return engine.config.FillModel
+}
@@ -0,0 +1,371 @@
+package backtest
+
+import (
+	"math"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+type GridType = int64
+
+const (
+	GridType_Arithmetic GridType = 0 // 等差网格 相邻网格价差相同
+	GridType_Geometric  GridType = 1 // 等比网格 相邻网格涨跌幅相同
+)
+
+// @func 网格交易配置
+// 上下界之间分成GridCount格 每格在下沿买入 上沿卖出
+// 开始时价格之上的格子按开盘价买入 价格之下的格子等待下跌买入
+type GridConfig struct {
+	Type         GridType
+	LowerPrice   int64   // 网格下界 单位毫 跌破后不再买入
+	UpperPrice   int64   // 网格上界 单位毫 涨破后所有格子都已卖出
+	GridCount    int     // 上下界之间的格数
+	Budget       float64 // 总资金 单位元 也是期初资产
+	BasePosition float64 // 底仓占总资金的比例 开始时买入 一直持有
+	Reserve      float64 // 预留现金占总资金的比例 网格买入不使用
+	Rules        TradingRules
+	FillModel    FillModel // 成交模型 空表示按网格价成交 盘中多次成交时每次单独计算
+}
+
+// @func 默认配置 等比网格 10万资金 预留10%现金 没有底仓 价格区间需要另外设置
+func DefaultGridConfig() GridConfig {
+	return GridConfig{
+		Type:         GridType_Geometric,
+		GridCount:    10,
+		Budget:       100000,
+		BasePosition: 0.0,
+		Reserve:      0.1,
+		Rules:        DefaultEtfTradingRules(),
+	}
+}
+
+// @func 以center_price为中心 上下各band的网格
+// @step 每格的涨跌幅 0.01表示1% 等差网格按中心价折算价差
+// @band 上下界相对中心价的幅度 0.1表示上下各10%
+func NewGridConfig(grid_type GridType, center_price int64, step float64, band float64, budget float64) GridConfig {
+	config := DefaultGridConfig()
+	config.Type = grid_type
+	config.Budget = budget
+	config.LowerPrice = int64(math.Round(float64(center_price) * (1.0 - band)))
+	config.UpperPrice = int64(math.Round(float64(center_price) * (1.0 + band)))
+	if step > 0 {
+		if grid_type == GridType_Geometric {
+			config.GridCount = int(math.Round(math.Log(float64(config.UpperPrice)/float64(config.LowerPrice)) / math.Log(1.0+step)))
+		} else {
+			config.GridCount = int(math.Round(float64(config.UpperPrice-config.LowerPrice) / (float64(center_price) * step)))
+		}
+	}
+	if config.GridCount < 1 {
+		config.GridCount = 1
+	}
+	return config
+}
+
+// @func 网格价格 从低到高共GridCount+1个 四舍五入到厘
+func (config GridConfig) Levels() []int64 {
+	const kTick float64 = kPriceUnit / 1000.0
+
+	result := make([]int64, 0, config.GridCount+1)
+	if config.GridCount <= 0 || config.LowerPrice <= 0 || config.UpperPrice <= config.LowerPrice {
+		return result
+	}
+	for i := 0; i <= config.GridCount; i++ {
+		position := float64(i) / float64(config.GridCount)
+		price := float64(config.LowerPrice) + float64(config.UpperPrice-config.LowerPrice)*position
+		if config.Type == GridType_Geometric {
+			price = float64(config.LowerPrice) * math.Pow(float64(config.UpperPrice)/float64(config.LowerPrice), position)
+		}
+		result = append(result, int64(math.Round(price/kTick)*kTick))
+	}
+	return result
+}
+
+// @func 网格持仓批次
+type gridLot struct {
+	date     string
+	index    int
+	price    int64
+	quantity int64
+	fee      float64 // 剩余股数对应的买入费用
+}
+
+// @func 一格 下沿买入 上沿卖出
+type gridSlot struct {
+	buy_price  int64
+	sell_price int64
+	quantity   int64 // 每次买入股数
+	lot        gridLot
+}
+
+type gridSimulator struct {
+	config     GridConfig
+	stock_item data_center.StockItem
+	fill_model FillModel
+
+	cash          float64
+	reserve       float64 // 买入时保留的现金
+	slots         []gridSlot
+	base          gridLot
+	next_order_id int64
+
+	index int                   // 当前日k线索引
+	day   data_center.KlineItem // 当前日k线 用于交易规则判断
+	price int64                 // 最新价
+	ready bool                  // 是否已经建仓
+
+	result Result
+}
+
+// @func 模拟网格交易 结果可以用CalculateResultMetrics计算指标
+// 日k线按 开-低-高-收 或 开-高-低-收 近似盘中路径 有分钟k线的日期用分钟k线
+// 一天内可以多次穿越网格多次成交 跳空时按开盘价成交 期末持仓按收盘价计入资产 不强制卖出
+// @day_kline_items 日k线 按日期排序
+// @minute_kline_items 分钟k线 按时间排序 Date为 202405230935 的形式 可以为空或者只覆盖部分日期 没有分钟k线的日期用日k线
+// @start 开始索引 包含
+// @end 结束索引 不含
+func SimulateGrid(stock_item data_center.StockItem, day_kline_items []data_center.KlineItem, minute_kline_items []data_center.KlineItem, start int, end int, config GridConfig) Result {
+	simulator := &gridSimulator{
+		config:     config,
+		stock_item: stock_item,
+		fill_model: config.FillModel,
+		cash:       config.Budget,
+		reserve:    config.Budget * config.Reserve,
+		result:     Result{InitialCash: config.Budget},
+	}
+	if simulator.fill_model == nil {
+		simulator.fill_model = PerfectFill{}
+	}
+	if start < 0 || end > len(day_kline_items) || start >= end {
+		return simulator.result
+	}
+
+	levels := config.Levels()
+	grid_money := config.Budget * (1.0 - config.BasePosition - config.Reserve) / float64(config.GridCount)
+	for i := 0; i+1 < len(levels); i++ {
+		quantity := config.Rules.RoundLot(int64(grid_money / PriceToYuan(levels[i])))
+		simulator.slots = append(simulator.slots, gridSlot{buy_price: levels[i], sell_price: levels[i+1], quantity: quantity})
+	}
+
+	// 分钟k线按日期分组 Date前8位是日期 没有时分的不是分钟k线 忽略
+	minute_map := make(map[string][]data_center.KlineItem)
+	for _, kline_item := range minute_kline_items {
+		if len(kline_item.Date) > 8 {
+			minute_map[kline_item.Date[0:8]] = append(minute_map[kline_item.Date[0:8]], kline_item)
+		}
+	}
+
+	for i := start; i < end; i++ {
+		simulator.index = i
+		simulator.day = day_kline_items[i]
+		if !config.Rules.Suspension || !IsSuspended(simulator.day) {
+			bars, ok := minute_map[simulator.day.Date]
+			if !ok {
+				bars = []data_center.KlineItem{simulator.day}
+			}
+			for _, bar := range bars {
+				simulator.onBar(bar)
+			}
+		}
+		simulator.recordEquity()
+	}
+
+	return simulator.result
+}
+
+// @func 按近似路径处理一根k线 开盘价和上一价格之间视为跳空
+func (simulator *gridSimulator) onBar(bar data_center.KlineItem) {
+	if !simulator.ready {
+		simulator.price = bar.Open
+		simulator.ready = simulator.open(bar)
+		if !simulator.ready {
+			// 没有建仓前不按路径成交 否则第二天建仓会覆盖已经成交的格子
+			return
+		}
+	}
+
+	path := []int64{bar.Open, bar.Low, bar.High, bar.Close}
+	if bar.Close < bar.Open {
+		path = []int64{bar.Open, bar.High, bar.Low, bar.Close}
+	}
+	for i, price := range path {
+		simulator.move(bar, price, i == 0)
+	}
+}
+
+// @func 建仓 买入底仓和当前价之上的格子 按开盘价一次成交
+// @return 是否已经建仓 无法交易时第二天再试
+func (simulator *gridSimulator) open(bar data_center.KlineItem) bool {
+	price := bar.Open
+	if !simulator.config.Rules.CanBuy(simulator.stock_item, simulator.day, price) {
+		return false
+	}
+
+	base_quantity := simulator.config.Rules.RoundLot(int64(simulator.config.Budget * simulator.config.BasePosition / PriceToYuan(price)))
+	var slot_quantity int64 = 0
+	for i := range simulator.slots {
+		if simulator.slots[i].buy_price >= price && simulator.slots[i].lot.quantity <= 0 {
+			slot_quantity += simulator.slots[i].quantity
+		}
+	}
+	if base_quantity+slot_quantity <= 0 {
+		return true
+	}
+
+	fill_price, fill_quantity, fee, ok := simulator.execute(OrderSide_Buy, price, base_quantity+slot_quantity, bar, simulator.cash)
+	if !ok {
+		return true
+	}
+
+	// 先满足底仓 再从最低的格子开始分配
+	fee_per_share := fee / float64(fill_quantity)
+	remain := fill_quantity
+	quantity := int64(math.Min(float64(base_quantity), float64(remain)))
+	if quantity > 0 {
+		simulator.base = gridLot{date: simulator.day.Date, index: simulator.index, price: fill_price, quantity: quantity, fee: fee_per_share * float64(quantity)}
+		remain -= quantity
+	}
+	for i := range simulator.slots {
+		slot := &simulator.slots[i]
+		if slot.buy_price < price || slot.lot.quantity > 0 || remain <= 0 {
+			continue
+		}
+		quantity = int64(math.Min(float64(slot.quantity), float64(remain)))
+		slot.lot = gridLot{date: simulator.day.Date, index: simulator.index, price: fill_price, quantity: quantity, fee: fee_per_share * float64(quantity)}
+		remain -= quantity
+	}
+	return true
+}
+
+// @func 价格从上一价格移动到price 依次成交穿越的网格
+// @gap 是否跳空 跳空时按price成交 否则按网格价成交
+func (simulator *gridSimulator) move(bar data_center.KlineItem, price int64, gap bool) {
+	from := simulator.price
+	simulator.price = price
+	if price < from {
+		for i := len(simulator.slots) - 1; i >= 0; i-- {
+			slot := &simulator.slots[i]
+			if slot.lot.quantity > 0 || slot.quantity <= 0 || slot.buy_price < price || slot.buy_price >= from {
+				continue
+			}
+			fill_price := slot.buy_price
+			if gap {
+				fill_price = price
+			}
+			simulator.buySlot(slot, fill_price, bar)
+		}
+	} else if price > from {
+		for i := range simulator.slots {
+			slot := &simulator.slots[i]
+			if slot.lot.quantity <= 0 || slot.sell_price <= from || slot.sell_price > price {
+				continue
+			}
+			fill_price := slot.sell_price
+			if gap {
+				fill_price = price
+			}
+			simulator.sellLot(&slot.lot, fill_price, bar, "grid")
+		}
+	}
+}
+
+func (simulator *gridSimulator) buySlot(slot *gridSlot, price int64, bar data_center.KlineItem) {
+	if !simulator.config.Rules.CanBuy(simulator.stock_item, simulator.day, price) {
+		return
+	}
+	fill_price, fill_quantity, fee, ok := simulator.execute(OrderSide_Buy, price, slot.quantity, bar, simulator.cash-simulator.reserve)
+	if !ok {
+		return
+	}
+	slot.lot = gridLot{date: simulator.day.Date, index: simulator.index, price: fill_price, quantity: fill_quantity, fee: fee}
+}
+
+// @func 卖出批次 部分成交时剩余的等下次穿越再卖
+func (simulator *gridSimulator) sellLot(lot *gridLot, price int64, bar data_center.KlineItem, reason string) {
+	if simulator.config.Rules.TPlusOne && lot.date == simulator.day.Date {
+		return
+	}
+	if !simulator.config.Rules.CanSell(simulator.stock_item, simulator.day, price) {
+		return
+	}
+	fill_price, fill_quantity, fee, ok := simulator.execute(OrderSide_Sell, price, lot.quantity, bar, 0.0)
+	if !ok {
+		return
+	}
+
+	entry_fee := lot.fee * float64(fill_quantity) / float64(lot.quantity)
+	profit := float64(fill_quantity)*PriceToYuan(fill_price-lot.price) - fee - entry_fee
+	simulator.result.Trades = append(simulator.result.Trades, Trade{
+		Stock:       simulator.stock_item,
+		SignalDate:  lot.date,
+		EntryDate:   lot.date,
+		ExitDate:    simulator.day.Date,
+		EntryPrice:  lot.price,
+		ExitPrice:   fill_price,
+		Quantity:    fill_quantity,
+		Fee:         fee + entry_fee,
+		Profit:      profit,
+		ReturnRate:  profit / (float64(fill_quantity)*PriceToYuan(lot.price) + entry_fee) * 100.0,
+		HoldingBars: simulator.index - lot.index,
+		ExitReason:  reason,
+	})
+
+	lot.fee -= entry_fee
+	lot.quantity -= fill_quantity
+}
+
+// @func 按成交模型成交并记录 买入受可用资金限制
+// @cash 买入可用资金 单位元
+// @return 成交价 成交股数 费用 是否有成交
+func (simulator *gridSimulator) execute(side OrderSide, price int64, quantity int64, bar data_center.KlineItem, cash float64) (int64, int64, float64, bool) {
+	rules := simulator.config.Rules
+	fill_price, fill_quantity := simulator.fill_model.Fill(FillRequest{Side: side, Price: price, Quantity: quantity, KlineItem: bar})
+	if side == OrderSide_Buy {
+		fill_quantity = rules.RoundLot(fill_quantity)
+		affordable := rules.AffordableQuantity(cash, fill_price)
+		if fill_quantity > affordable {
+			fill_quantity = affordable
+		}
+	} else if fill_quantity < quantity && quantity < rules.LotSize {
+		fill_quantity = quantity // 零股只能一次卖出
+	} else {
+		fill_quantity = rules.RoundLot(fill_quantity)
+	}
+	if fill_quantity <= 0 {
+		return 0, 0, 0.0, false
+	}
+
+	value := float64(fill_quantity) * PriceToYuan(fill_price)
+	fee := rules.Fees.Calculate(side, value)
+	if side == OrderSide_Buy {
+		simulator.cash -= value + fee
+	} else {
+		simulator.cash += value - fee
+	}
+	simulator.next_order_id++
+	simulator.result.Fills = append(simulator.result.Fills, Fill{OrderId: simulator.next_order_id, Stock: simulator.stock_item, Side: side, Date: simulator.day.Date, Price: fill_price, Quantity: fill_quantity, Fee: fee})
+	return fill_price, fill_quantity, fee, true
+}
+
+// @func 按当日收盘价记录资金曲线
+func (simulator *gridSimulator) recordEquity() {
+	hold_shares := simulator.base.quantity
+	for _, slot := range simulator.slots {
+		hold_shares += slot.lot.quantity
+	}
+	position_value := float64(hold_shares) * PriceToYuan(simulator.day.Close)
+	positions := 0
+	var holdings []Holding
+	if hold_shares > 0 {
+		positions = 1
+		holdings = []Holding{{Stock: simulator.stock_item, Quantity: hold_shares, Value: position_value}}
+	}
+	simulator.result.Equity = append(simulator.result.Equity, EquityPoint{
+		Date:          simulator.day.Date,
+		Cash:          simulator.cash,
+		PositionValue: position_value,
+		Equity:        simulator.cash + position_value,
+		Positions:     positions,
+		Holdings:      holdings,
+	})
+}
@@ -0,0 +1,121 @@
+package backtest
+
+import (
+	"sort"
+)
+
+// @func 现金流账本 按交易日历中的位置记账 每个交易日一个槽位
+type Ledger struct {
+	calendar []string  // 交易日历 按日期排序 多个账本可以共用
+	flows    []float64 // 与calendar等长 每个交易日的净现金流 单位元 买入为负卖出为正
+}
+
+// @func 由日期生成交易日历 去重并排序
+// @dates 所有可能记账的日期 可以重复和无序
+func NewTradingCalendar(dates []string) []string {
+	sorted := make([]string, len(dates))
+	copy(sorted, dates)
+	sort.Strings(sorted)
+
+	result := make([]string, 0, len(sorted))
+	for i, date := range sorted {
+		if i == 0 || date != sorted[i-1] {
+			result = append(result, date)
+		}
+	}
+	return result
+}
+
+// @calendar 按日期排序的交易日历 记账的日期都要在日历中
+func NewLedger(calendar []string) *Ledger {
+	return &Ledger{calendar: calendar, flows: make([]float64, len(calendar))}
+}
+
+// @func 日期在交易日历中的位置 不是交易日时取之后最近的交易日
+// @return 晚于日历最后一天时为-1
+func (ledger *Ledger) Index(date string) int {
+	index := sort.SearchStrings(ledger.calendar, date)
+	if index >= len(ledger.calendar) {
+		return -1
+	}
+	return index
+}
+
+// @func 按交易日历中的位置记录现金流
+// @index 交易日历中的位置
+// @money 单位元 买入为负卖出为正
+func (ledger *Ledger) AddAt(index int, money float64) {
+	ledger.flows[index] += money
+}
+
+// @func 记录现金流 日期晚于交易日历时忽略
+// @date 交易日 20240102
+// @money 单位元 买入为负卖出为正
+func (ledger *Ledger) Add(date string, money float64) {
+	index := ledger.Index(date)
+	if index < 0 {
+		return
+	}
+	ledger.AddAt(index, money)
+}
+
+// @func 按日期排序的净现金流 只包含有现金流的交易日
+func (ledger *Ledger) Flows() ([]string, []float64) {
+	dates := make([]string, 0)
+	flows := make([]float64, 0)
+	for i, flow := range ledger.flows {
+		if flow != 0 {
+			dates = append(dates, ledger.calendar[i])
+			flows = append(flows, flow)
+		}
+	}
+	return dates, flows
+}
+
+// @func 净收益 单位元
+func (ledger *Ledger) Profit() float64 {
+	result := 0.0
+	for _, flow := range ledger.flows {
+		result += flow
+	}
+	return result
+}
+
+// @func 占用的本金 即累计现金流从高点回落的最大值
+// @return 单位元
+func (ledger *Ledger) InvestedCapital() float64 {
+	cash := 0.0
+	peak_cash := 0.0
+	invested := 0.0
+	for _, flow := range ledger.flows {
+		cash += flow
+		if cash > peak_cash {
+			peak_cash = cash
+		}
+		if peak_cash-cash > invested {
+			invested = peak_cash - cash
+		}
+	}
+	return invested
+}
+
+// @func 收益率 净收益除以占用本金
+// @return 已经乘了100 没有占用本金时为0
+func (ledger *Ledger) ProfitRate() float64 {
+	invested := ledger.InvestedCapital()
+	if invested <= 1.0e-3 {
+		return 0.0
+	}
+	return ledger.Profit() / invested * 100.0
+}
+
+// @func 有净现金流的交易日数
+func (ledger *Ledger) Len() int {
+	result := 0
+	for _, flow := range ledger.flows {
+		if flow != 0 {
+			result++
+		}
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package backtest
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// @func 原来的计算方式 按整数日期下标的数组记账 占用本金为最小连续子数组和的相反数
+func oldInvestedCapitalAndProfit(dates []string, flows []float64) (float64, float64) {
+	first, last := math.MaxInt, 0
+	for _, date := range dates {
+		value, _ := strconv.Atoi(date)
+		first = min(first, value)
+		last = max(last, value)
+	}
+	account_money := make([]float64, last-first+1)
+	for i, date := range dates {
+		value, _ := strconv.Atoi(date)
+		account_money[value-first] += flows[i]
+	}
+
+	min_sum := account_money[0]
+	last_min_sum := account_money[0]
+	for i := 1; i < len(account_money); i++ {
+		if last_min_sum <= 0 {
+			last_min_sum += account_money[i]
+		} else {
+			last_min_sum = account_money[i]
+		}
+		min_sum = math.Min(min_sum, last_min_sum)
+	}
+
+	profit := 0.0
+	for _, money := range account_money {
+		profit += money
+	}
+	return -min_sum, profit
+}
+
+func TestLedger(t *testing.T) {
+	cases := []struct {
+		name     string
+		dates    []string // 乱序 可以重复
+		flows    []float64
+		invested float64
+		profit   float64
+	}{
+		{
+			"同一天买卖",
+			[]string{"20240105", "20240102", "20240103", "20240103", "20240110", "20240108", "20240108"},
+			[]float64{1050, -1000, -2000, 500, 2100, -800, 800},
+			2500, 650,
+		},
+		{
+			"先盈利后回撤",
+			[]string{"20240103", "20240102", "20240104", "20240105"},
+			[]float64{1500, -1000, -2000, 1800},
+			2000, 300,
+		},
+		{
+			"跨年",
+			[]string{"20240102", "20231229", "20231228", "20240103"},
+			[]float64{-3000, 1000, -1000, 2900},
+			3000, -100,
+		},
+	}
+
+	for _, c := range cases {
+		// 日历中有没有现金流的交易日
+		calendar := NewTradingCalendar(append([]string{"20240104", "20231227"}, c.dates...))
+		ledger := NewLedger(calendar)
+		for i, date := range c.dates {
+			ledger.Add(date, c.flows[i])
+		}
+
+		old_invested, old_profit := oldInvestedCapitalAndProfit(c.dates, c.flows)
+		if math.Abs(ledger.InvestedCapital()-c.invested) > 1e-9 || math.Abs(old_invested-c.invested) > 1e-9 {
+			t.Errorf("%s: invested got %f old %f want %f", c.name, ledger.InvestedCapital(), old_invested, c.invested)
+		}
+		if math.Abs(ledger.Profit()-c.profit) > 1e-9 || math.Abs(old_profit-c.profit) > 1e-9 {
+			t.Errorf("%s: profit got %f old %f want %f", c.name, ledger.Profit(), old_profit, c.profit)
+		}
+		if math.Abs(ledger.ProfitRate()-c.profit/c.invested*100.0) > 1e-9 {
+			t.Errorf("%s: profit rate got %f want %f", c.name, ledger.ProfitRate(), c.profit/c.invested*100.0)
+		}
+	}
+}
+
+// 随机现金流和原来的计算方式一致 原来每天净现金流都为正时占用本金是负数 现在为0
+func TestLedgerMatchesMinSubArraySum(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		count := 1 + random.Intn(40)
+		dates := make([]string, count)
+		flows := make([]float64, count)
+		for i := range dates {
+			dates[i] = strconv.Itoa(20240101 + random.Intn(28))
+			flows[i] = float64(random.Intn(2000) - 1000)
+		}
+		ledger := NewLedger(NewTradingCalendar(dates))
+		for i, date := range dates {
+			ledger.Add(date, flows[i])
+		}
+		old_invested, old_profit := oldInvestedCapitalAndProfit(dates, flows)
+		old_invested = math.Max(old_invested, 0.0)
+		if math.Abs(ledger.InvestedCapital()-old_invested) > 1e-6 || math.Abs(ledger.Profit()-old_profit) > 1e-6 {
+			t.Fatalf("round %d: got %f %f want %f %f", round, ledger.InvestedCapital(), ledger.Profit(), old_invested, old_profit)
+		}
+	}
+}
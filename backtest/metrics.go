@@ -0,0 +1,235 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+const kTradeDaysPerYear float64 = 250.0 // 每年交易日 用于年化
+const kRiskFreeRate float64 = 0.02      // 无风险年化收益率 计算夏普和索提诺
+
+// @func 回测绩效和风险指标 比例都已经乘了100
+type Metrics struct {
+	StartDate string
+	EndDate   string
+	Bars      int // 资金曲线k线数
+
+	InitialEquity float64 // 期初资产 单位元 不限制资金时为占用本金
+	FinalEquity   float64 // 期末资产 单位元
+
+	TotalReturn         float64 // 总收益率
+	Cagr                float64 // 年化收益率
+	AnnualVolatility    float64 // 年化波动率
+	Sharpe              float64
+	Sortino             float64
+	MaxDrawdown         float64 // 最大回撤
+	MaxDrawdownStart    string  // 最大回撤开始的高点日期
+	MaxDrawdownEnd      string  // 最大回撤的低点日期
+	MaxDrawdownDuration int     // 最长回撤持续k线数 从高点到重新创新高 未恢复的算到最后
+	Calmar              float64 // 年化收益率除以最大回撤
+
+	TradeCount          int
+	WinRate             float64
+	ProfitFactor        float64 // 总盈利除以总亏损 有盈利没有亏损时为正无穷 没有盈利也没有亏损时为0
+	Expectancy          float64 // 平均每笔收益 单位元
+	ExpectancyRate      float64 // 平均每笔收益率
+	AvgWin              float64 // 平均盈利 单位元
+	AvgLoss             float64 // 平均亏损 单位元 负数
+	LongestLosingStreak int     // 最长连续亏损笔数
+
+	Exposure float64 // 有持仓的k线占比
+	Turnover float64 // 年化换手 成交额的一半除以平均资产 单位倍
+}
+
+// @func 计算回测结果的指标 不限制资金时以占用本金作为期初资产
+func CalculateResultMetrics(result Result) Metrics {
+	equity, initial_equity := resultEquity(result)
+	return CalculateMetrics(equity, initial_equity, result.Trades, result.Fills)
+}
+
+// @func 回测结果的资金曲线和期初资产
+// 不限制资金时资产从0开始 加上占用本金后才能计算收益率
+func resultEquity(result Result) ([]EquityPoint, float64) {
+	if result.InitialCash > 0 {
+		return result.Equity, result.InitialCash
+	}
+
+	invested := CalculateInvestedCapital(result.Equity)
+	equity := make([]EquityPoint, len(result.Equity))
+	for i, point := range result.Equity {
+		equity[i] = point
+		equity[i].Cash += invested
+		equity[i].Equity += invested
+	}
+	return equity, invested
+}
+
+// @func 根据资金曲线和交易计算指标 适用于任何策略
+// @equity 按日期排序的资金曲线 Equity为总资产
+// @initial_equity 第一根k线之前的资产 单位元
+// @trades 按平仓顺序排列的交易
+// @fills 成交记录 用于计算换手 可以为空
+func CalculateMetrics(equity []EquityPoint, initial_equity float64, trades []Trade, fills []Fill) Metrics {
+	metrics := Metrics{InitialEquity: initial_equity}
+	calculateTradeMetrics(&metrics, trades)
+
+	equity_len := len(equity)
+	if equity_len <= 0 || initial_equity <= 0 {
+		return metrics
+	}
+
+	metrics.StartDate = equity[0].Date
+	metrics.EndDate = equity[equity_len-1].Date
+	metrics.Bars = equity_len
+	metrics.FinalEquity = equity[equity_len-1].Equity
+	metrics.TotalReturn = (metrics.FinalEquity/initial_equity - 1.0) * 100.0
+
+	years := float64(equity_len) / kTradeDaysPerYear
+	if metrics.FinalEquity > 0 {
+		metrics.Cagr = (math.Pow(metrics.FinalEquity/initial_equity, 1.0/years) - 1.0) * 100.0
+	} else {
+		metrics.Cagr = -100.0
+	}
+
+	// 日收益率
+	returns := make([]float64, equity_len)
+	pre_equity := initial_equity
+	for i, point := range equity {
+		if pre_equity > 0 {
+			returns[i] = point.Equity/pre_equity - 1.0
+		}
+		pre_equity = point.Equity
+	}
+
+	return_sum := 0.0
+	downside_square_sum := 0.0
+	daily_risk_free := kRiskFreeRate / kTradeDaysPerYear
+	for _, value := range returns {
+		return_sum += value
+		if value < daily_risk_free {
+			downside_square_sum += (value - daily_risk_free) * (value - daily_risk_free)
+		}
+	}
+	annual_return := return_sum / float64(equity_len) * kTradeDaysPerYear
+	volatility := CalculateStd(returns) * math.Sqrt(kTradeDaysPerYear)
+	downside_volatility := math.Sqrt(downside_square_sum/float64(equity_len)) * math.Sqrt(kTradeDaysPerYear)
+	metrics.AnnualVolatility = volatility * 100.0
+	if volatility > 0 {
+		metrics.Sharpe = (annual_return - kRiskFreeRate) / volatility
+	}
+	if downside_volatility > 0 {
+		metrics.Sortino = (annual_return - kRiskFreeRate) / downside_volatility
+	}
+
+	// 最大回撤和最长回撤持续时间
+	peak := initial_equity
+	peak_date := ""
+	peak_index := -1
+	for i, point := range equity {
+		if point.Equity >= peak {
+			peak = point.Equity
+			peak_date = point.Date
+			peak_index = i
+		}
+		drawdown := (1.0 - point.Equity/peak) * 100.0
+		if drawdown > metrics.MaxDrawdown {
+			metrics.MaxDrawdown = drawdown
+			metrics.MaxDrawdownStart = peak_date
+			metrics.MaxDrawdownEnd = point.Date
+		}
+		if i-peak_index > metrics.MaxDrawdownDuration {
+			metrics.MaxDrawdownDuration = i - peak_index
+		}
+	}
+	if metrics.MaxDrawdown > 0 {
+		metrics.Calmar = metrics.Cagr / metrics.MaxDrawdown
+	}
+
+	// 持仓占比和换手
+	exposure_bars := 0
+	equity_sum := 0.0
+	for _, point := range equity {
+		if point.PositionValue > 0 || point.Positions > 0 {
+			exposure_bars++
+		}
+		equity_sum += point.Equity
+	}
+	metrics.Exposure = float64(exposure_bars) / float64(equity_len) * 100.0
+
+	traded_value := 0.0
+	for _, fill := range fills {
+		traded_value += float64(fill.Quantity) * PriceToYuan(fill.Price)
+	}
+	avg_equity := equity_sum / float64(equity_len)
+	if avg_equity > 0 {
+		metrics.Turnover = traded_value / 2.0 / avg_equity / years
+	}
+
+	return metrics
+}
+
+func calculateTradeMetrics(metrics *Metrics, trades []Trade) {
+	metrics.TradeCount = len(trades)
+	if metrics.TradeCount <= 0 {
+		return
+	}
+
+	win_count := 0
+	gross_profit := 0.0
+	gross_loss := 0.0
+	return_rate_sum := 0.0
+	losing_streak := 0
+	for _, trade := range trades {
+		return_rate_sum += trade.ReturnRate
+		if trade.Profit > 0 {
+			win_count++
+			gross_profit += trade.Profit
+			losing_streak = 0
+		} else {
+			gross_loss -= trade.Profit
+			losing_streak++
+			if losing_streak > metrics.LongestLosingStreak {
+				metrics.LongestLosingStreak = losing_streak
+			}
+		}
+	}
+
+	loss_count := metrics.TradeCount - win_count
+	metrics.WinRate = float64(win_count) / float64(metrics.TradeCount) * 100.0
+	metrics.Expectancy = (gross_profit - gross_loss) / float64(metrics.TradeCount)
+	metrics.ExpectancyRate = return_rate_sum / float64(metrics.TradeCount)
+	if win_count > 0 {
+		metrics.AvgWin = gross_profit / float64(win_count)
+	}
+	if loss_count > 0 {
+		metrics.AvgLoss = -gross_loss / float64(loss_count)
+	}
+	if gross_loss > 0 {
+		metrics.ProfitFactor = gross_profit / gross_loss
+	} else if gross_profit > 0 {
+		metrics.ProfitFactor = math.Inf(1)
+	}
+}
+
+// @func JSON不支持无穷大 利润因子为正无穷时输出null
+func (metrics Metrics) MarshalJSON() ([]byte, error) {
+	type plainMetrics Metrics
+	value := struct {
+		plainMetrics
+		ProfitFactor *float64
+	}{plainMetrics: plainMetrics(metrics)}
+	if !math.IsInf(metrics.ProfitFactor, 0) {
+		value.ProfitFactor = &metrics.ProfitFactor
+	}
+	return json.Marshal(value)
+}
+
+func (metrics Metrics) String() string {
+	result := fmt.Sprintf("区间 %s-%s 期初资产 %.2f 期末资产 %.2f\n", metrics.StartDate, metrics.EndDate, metrics.InitialEquity, metrics.FinalEquity)
+	result += fmt.Sprintf("总收益率 %.2f%% 年化收益率 %.2f%% 年化波动率 %.2f%% 夏普 %.2f 索提诺 %.2f\n", metrics.TotalReturn, metrics.Cagr, metrics.AnnualVolatility, metrics.Sharpe, metrics.Sortino)
+	result += fmt.Sprintf("最大回撤 %.2f%% (%s-%s) 最长回撤 %d根k线 卡玛 %.2f\n", metrics.MaxDrawdown, metrics.MaxDrawdownStart, metrics.MaxDrawdownEnd, metrics.MaxDrawdownDuration, metrics.Calmar)
+	result += fmt.Sprintf("交易次数 %d 胜率 %.2f%% 利润因子 %.2f 期望 %.2f(%.2f%%) 平均盈利 %.2f 平均亏损 %.2f 最长连亏 %d\n", metrics.TradeCount, metrics.WinRate, metrics.ProfitFactor, metrics.Expectancy, metrics.ExpectancyRate, metrics.AvgWin, metrics.AvgLoss, metrics.LongestLosingStreak)
+	result += fmt.Sprintf("持仓时间占比 %.2f%% 年化换手 %.2f倍", metrics.Exposure, metrics.Turnover)
+	return result
+}
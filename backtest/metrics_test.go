@@ -0,0 +1,127 @@
+package backtest
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func newMetricsEquity(values ...float64) []EquityPoint {
+	dates := []string{"20240102", "20240103", "20240104", "20240105", "20240108", "20240109"}
+	equity := make([]EquityPoint, len(values))
+	for i, value := range values {
+		equity[i] = EquityPoint{Date: dates[i], Cash: value, Equity: value}
+	}
+	return equity
+}
+
+// 期初100 资金曲线110 99 99 121 110 日收益率为 10% -10% 0 22.2% -9.1%
+func TestCalculateMetricsKnownCurve(t *testing.T) {
+	metrics := CalculateMetrics(newMetricsEquity(110, 99, 99, 121, 110), 100, nil, nil)
+
+	cases := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"总收益率", metrics.TotalReturn, 10.0},
+		{"年化收益率", metrics.Cagr, (math.Pow(1.1, 250.0/5.0) - 1.0) * 100.0}, // 5根k线按1/50年
+		{"年化波动率", metrics.AnnualVolatility, 215.34645221167477},           // 样本标准差乘根号250
+		{"夏普", metrics.Sharpe, 3.039593407939},
+		{"索提诺", metrics.Sortino, 6.8438688760384}, // 只统计低于日无风险收益率的部分
+		{"最大回撤", metrics.MaxDrawdown, 10.0},       // 110到99
+		{"卡玛", metrics.Calmar, (math.Pow(1.1, 250.0/5.0) - 1.0) * 100.0 / 10.0},
+	}
+	for _, c := range cases {
+		if math.Abs(c.got-c.want) > 1e-6*math.Max(1.0, math.Abs(c.want)) {
+			t.Errorf("%s: got %.10f want %.10f", c.name, c.got, c.want)
+		}
+	}
+
+	if metrics.MaxDrawdownStart != "20240102" || metrics.MaxDrawdownEnd != "20240103" {
+		t.Errorf("最大回撤区间: got %s-%s want 20240102-20240103", metrics.MaxDrawdownStart, metrics.MaxDrawdownEnd)
+	}
+	// 20240102的高点到20240105创新高之前持续2根k线 之后的回撤只有1根
+	if metrics.MaxDrawdownDuration != 2 {
+		t.Errorf("最长回撤: got %d want 2", metrics.MaxDrawdownDuration)
+	}
+}
+
+// 一直没有回到高点时回撤持续到最后
+func TestCalculateMetricsUnrecoveredDrawdown(t *testing.T) {
+	metrics := CalculateMetrics(newMetricsEquity(120, 90, 100, 110), 100, nil, nil)
+	if math.Abs(metrics.MaxDrawdown-25.0) > 1e-9 || metrics.MaxDrawdownDuration != 3 {
+		t.Errorf("got %f %d want 25 3", metrics.MaxDrawdown, metrics.MaxDrawdownDuration)
+	}
+}
+
+func newProfitTrades(profits ...float64) []Trade {
+	trades := make([]Trade, len(profits))
+	for i, profit := range profits {
+		trades[i] = Trade{Profit: profit, ReturnRate: profit / 10.0}
+	}
+	return trades
+}
+
+func TestCalculateTradeMetrics(t *testing.T) {
+	metrics := CalculateMetrics(nil, 0, newProfitTrades(100, -50, 30, -20, -10, 60), nil)
+	cases := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"交易次数", float64(metrics.TradeCount), 6},
+		{"胜率", metrics.WinRate, 50.0},
+		{"利润因子", metrics.ProfitFactor, 190.0 / 80.0},
+		{"期望", metrics.Expectancy, 110.0 / 6.0},
+		{"期望收益率", metrics.ExpectancyRate, 11.0 / 6.0},
+		{"平均盈利", metrics.AvgWin, 190.0 / 3.0},
+		{"平均亏损", metrics.AvgLoss, -80.0 / 3.0},
+		{"最长连亏", float64(metrics.LongestLosingStreak), 2},
+	}
+	for _, c := range cases {
+		if math.Abs(c.got-c.want) > 1e-9 {
+			t.Errorf("%s: got %f want %f", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestProfitFactor(t *testing.T) {
+	cases := []struct {
+		name    string
+		profits []float64
+		want    float64
+	}{
+		{"没有亏损", []float64{10, 20}, math.Inf(1)},
+		{"持平算亏损但不计金额", []float64{10, 0}, math.Inf(1)},
+		{"只有持平", []float64{0}, 0.0},
+		{"只有亏损", []float64{-10}, 0.0},
+		{"没有交易", nil, 0.0},
+	}
+	for _, c := range cases {
+		metrics := CalculateMetrics(nil, 0, newProfitTrades(c.profits...), nil)
+		if metrics.ProfitFactor != c.want {
+			t.Errorf("%s: got %f want %f", c.name, metrics.ProfitFactor, c.want)
+		}
+	}
+}
+
+// JSON不支持无穷大 导出清单时利润因子为null
+func TestMetricsMarshalJSON(t *testing.T) {
+	body, err := json.Marshal(RunManifest{Metrics: CalculateMetrics(nil, 0, newProfitTrades(10, 20), nil)})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(body), `"ProfitFactor":null`) || !strings.Contains(string(body), `"WinRate":100`) {
+		t.Errorf("got %s", body)
+	}
+
+	body, err = json.Marshal(CalculateMetrics(nil, 0, newProfitTrades(30, -10), nil))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(body), `"ProfitFactor":3`) {
+		t.Errorf("got %s", body)
+	}
+}
@@ -0,0 +1,162 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+type MonteCarloMethod = int64
+
+const (
+	MonteCarloMethod_Bootstrap MonteCarloMethod = 0 // 有放回抽样 交易数不变
+	MonteCarloMethod_Shuffle   MonteCarloMethod = 1 // 打乱顺序 不丢弃交易和不加滑点时只改变回撤
+)
+
+// @func 蒙特卡洛稳健性检验配置
+type MonteCarloConfig struct {
+	Iterations     int              // 模拟次数
+	Method         MonteCarloMethod // 交易序列的重采样方式
+	DropRate       float64          // 随机丢弃交易的比例 0.1表示丢弃10% 模拟错过信号
+	SlippageBps    float64          // 买卖各自额外滑点的均值 万分之几
+	SlippageStdBps float64          // 滑点的标准差 万分之几 滑点小于0时按0处理
+	TradeFraction  float64          // 每笔交易占用资产的比例 收益按比例复利
+	RuinDrawdown   float64          // 回撤达到该比例视为破产 0.5表示50%
+	Seed           int64            // 随机种子 相同种子结果相同
+}
+
+// @func 默认配置 1000次有放回抽样 每笔交易10%资产 回撤50%视为破产
+func DefaultMonteCarloConfig() MonteCarloConfig {
+	return MonteCarloConfig{
+		Iterations:     1000,
+		Method:         MonteCarloMethod_Bootstrap,
+		DropRate:       0.1,
+		SlippageBps:    5,
+		SlippageStdBps: 5,
+		TradeFraction:  0.1,
+		RuinDrawdown:   0.5,
+		Seed:           1,
+	}
+}
+
+// @func 模拟结果的分布 比例都已经乘了100
+type MonteCarloResult struct {
+	Iterations       int
+	FinalReturns     []float64 // 每次模拟的最终收益率 从小到大
+	MaxDrawdowns     []float64 // 每次模拟的最大回撤 从小到大
+	LossProbability  float64   // 最终亏损的概率
+	RuinProbability  float64   // 回撤达到RuinDrawdown的概率
+	OriginalReturn   float64   // 原始交易顺序的最终收益率
+	OriginalDrawdown float64   // 原始交易顺序的最大回撤
+}
+
+// @func 对交易序列做蒙特卡洛模拟
+// @trades 按平仓顺序排列的交易 使用ReturnRate
+func RunMonteCarlo(trades []Trade, config MonteCarloConfig) MonteCarloResult {
+	result := MonteCarloResult{Iterations: config.Iterations}
+	trades_len := len(trades)
+	if trades_len <= 0 || config.Iterations <= 0 {
+		return result
+	}
+
+	returns := make([]float64, trades_len)
+	for i, trade := range trades {
+		returns[i] = trade.ReturnRate / 100.0
+	}
+	result.OriginalReturn, result.OriginalDrawdown, _ = simulateTradeReturns(returns, config)
+
+	random := rand.New(rand.NewSource(config.Seed))
+	sample := make([]float64, 0, trades_len)
+	loss_count := 0
+	ruin_count := 0
+	for iteration := 0; iteration < config.Iterations; iteration++ {
+		sample = sample[:0]
+		if config.Method == MonteCarloMethod_Shuffle {
+			for _, index := range random.Perm(trades_len) {
+				sample = append(sample, returns[index])
+			}
+		} else {
+			for i := 0; i < trades_len; i++ {
+				sample = append(sample, returns[random.Intn(trades_len)])
+			}
+		}
+
+		// 随机丢弃交易 其余交易叠加随机滑点
+		perturbed := make([]float64, 0, len(sample))
+		for _, value := range sample {
+			if random.Float64() < config.DropRate {
+				continue
+			}
+			entry_slippage := math.Max(0.0, config.SlippageBps+random.NormFloat64()*config.SlippageStdBps) / 10000.0
+			exit_slippage := math.Max(0.0, config.SlippageBps+random.NormFloat64()*config.SlippageStdBps) / 10000.0
+			perturbed = append(perturbed, (1.0+value)*(1.0-exit_slippage)/(1.0+entry_slippage)-1.0)
+		}
+
+		final_return, max_drawdown, ruined := simulateTradeReturns(perturbed, config)
+		result.FinalReturns = append(result.FinalReturns, final_return)
+		result.MaxDrawdowns = append(result.MaxDrawdowns, max_drawdown)
+		if final_return < 0 {
+			loss_count++
+		}
+		if ruined {
+			ruin_count++
+		}
+	}
+
+	sort.Float64s(result.FinalReturns)
+	sort.Float64s(result.MaxDrawdowns)
+	result.LossProbability = float64(loss_count) / float64(config.Iterations) * 100.0
+	result.RuinProbability = float64(ruin_count) / float64(config.Iterations) * 100.0
+
+	return result
+}
+
+// @func 按每笔交易占用固定比例资产复利
+// @return 最终收益率 最大回撤 是否破产 比例已经乘了100
+func simulateTradeReturns(returns []float64, config MonteCarloConfig) (float64, float64, bool) {
+	equity := 1.0
+	peak := 1.0
+	max_drawdown := 0.0
+	ruined := false
+	for _, value := range returns {
+		equity *= 1.0 + config.TradeFraction*value
+		if equity <= 0 {
+			equity = 0
+		}
+		peak = math.Max(peak, equity)
+		drawdown := 1.0 - equity/peak
+		max_drawdown = math.Max(max_drawdown, drawdown)
+		if config.RuinDrawdown > 0 && drawdown >= config.RuinDrawdown {
+			ruined = true
+		}
+	}
+	return (equity - 1.0) * 100.0, max_drawdown * 100.0, ruined
+}
+
+// @func 已排序数组的分位数 线性插值
+// @percent 0-100
+func CalculateSortedPercentile(sorted []float64, percent float64) float64 {
+	sorted_len := len(sorted)
+	if sorted_len <= 0 {
+		return 0.0
+	}
+	position := percent / 100.0 * float64(sorted_len-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	if lower < 0 {
+		return sorted[0]
+	}
+	if upper >= sorted_len {
+		return sorted[sorted_len-1]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
+
+func (result MonteCarloResult) String() string {
+	output := fmt.Sprintf("模拟 %d 次 原始收益率 %.2f%% 原始最大回撤 %.2f%%\n", result.Iterations, result.OriginalReturn, result.OriginalDrawdown)
+	output += fmt.Sprintf("收益率 5%%分位 %.2f%% 中位数 %.2f%% 95%%分位 %.2f%%\n", CalculateSortedPercentile(result.FinalReturns, 5), CalculateSortedPercentile(result.FinalReturns, 50), CalculateSortedPercentile(result.FinalReturns, 95))
+	output += fmt.Sprintf("最大回撤 5%%分位 %.2f%% 中位数 %.2f%% 95%%分位 %.2f%%\n", CalculateSortedPercentile(result.MaxDrawdowns, 5), CalculateSortedPercentile(result.MaxDrawdowns, 50), CalculateSortedPercentile(result.MaxDrawdowns, 95))
+	output += fmt.Sprintf("亏损概率 %.2f%% 破产概率 %.2f%%", result.LossProbability, result.RuinProbability)
+	return output
+}
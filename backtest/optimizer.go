@@ -0,0 +1,225 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// @func 参数取值范围 Values不为空时只取Values 否则从Min到Max按Step取值
+type ParameterRange struct {
+	Name   string
+	Min    float64
+	Max    float64
+	Step   float64
+	Values []float64
+}
+
+// @func 范围内的全部取值
+func (parameter_range ParameterRange) AllValues() []float64 {
+	if len(parameter_range.Values) > 0 {
+		return parameter_range.Values
+	}
+	if parameter_range.Step <= 0 || parameter_range.Max < parameter_range.Min {
+		return []float64{parameter_range.Min}
+	}
+
+	result := make([]float64, 0)
+	count := int(math.Floor((parameter_range.Max-parameter_range.Min)/parameter_range.Step + 1e-9))
+	for i := 0; i <= count; i++ {
+		result = append(result, parameter_range.Min+float64(i)*parameter_range.Step)
+	}
+	return result
+}
+
+// @func 一组参数 名称 -> 取值
+type Parameters map[string]float64
+
+func (parameters Parameters) String() string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, fmt.Sprintf("%s=%g", name, parameters[name]))
+	}
+	return strings.Join(fields, " ")
+}
+
+// @func 网格搜索 全部参数组合 按ranges顺序展开 最后一个参数变化最快
+func GridSearchParameters(ranges []ParameterRange) []Parameters {
+	result := []Parameters{{}}
+	for _, parameter_range := range ranges {
+		next := make([]Parameters, 0)
+		for _, parameters := range result {
+			for _, value := range parameter_range.AllValues() {
+				item := make(Parameters, len(parameters)+1)
+				for name, old_value := range parameters {
+					item[name] = old_value
+				}
+				item[parameter_range.Name] = value
+				next = append(next, item)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// @func 随机搜索 每个参数从取值中随机选择 相同seed结果相同
+func RandomSearchParameters(ranges []ParameterRange, count int, seed int64) []Parameters {
+	random := rand.New(rand.NewSource(seed))
+	result := make([]Parameters, 0, count)
+	for i := 0; i < count; i++ {
+		item := make(Parameters, len(ranges))
+		for _, parameter_range := range ranges {
+			values := parameter_range.AllValues()
+			item[parameter_range.Name] = values[random.Intn(len(values))]
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// @func 按名称选择排序指标 越大越好
+// @name sharpe sortino cagr calmar total_return profit_factor expectancy_rate win_rate max_drawdown
+func MetricObjective(name string) (func(Metrics) float64, bool) {
+	objective_map := map[string]func(Metrics) float64{
+		"sharpe":          func(metrics Metrics) float64 { return metrics.Sharpe },
+		"sortino":         func(metrics Metrics) float64 { return metrics.Sortino },
+		"cagr":            func(metrics Metrics) float64 { return metrics.Cagr },
+		"calmar":          func(metrics Metrics) float64 { return metrics.Calmar },
+		"total_return":    func(metrics Metrics) float64 { return metrics.TotalReturn },
+		"profit_factor":   func(metrics Metrics) float64 { return metrics.ProfitFactor },
+		"expectancy_rate": func(metrics Metrics) float64 { return metrics.ExpectancyRate },
+		"win_rate":        func(metrics Metrics) float64 { return metrics.WinRate },
+		"max_drawdown":    func(metrics Metrics) float64 { return -metrics.MaxDrawdown },
+	}
+	objective, ok := objective_map[name]
+	return objective, ok
+}
+
+// @func 按参数在日期区间内运行一次回测
+// @start_date 开始日期 包含
+// @end_date 结束日期 不含
+type OptimizeRunner func(parameters Parameters, start_date string, end_date string) Result
+
+// @func 一次参数回测的结果
+type OptimizationRun struct {
+	Parameters Parameters
+	Metrics    Metrics
+	Score      float64
+}
+
+// @func 对每组参数运行回测 按指标从高到低排序 指标相同时保持参数顺序
+// @workers 并行协程数 0表示CPU核数
+func Optimize(parameter_sets []Parameters, runner OptimizeRunner, objective func(Metrics) float64, start_date string, end_date string, workers int, progress *Progress) []OptimizationRun {
+	result := make([]OptimizationRun, len(parameter_sets))
+	ParallelFor(len(parameter_sets), workers, progress, func(index int) {
+		metrics := CalculateResultMetrics(runner(parameter_sets[index], start_date, end_date))
+		score := objective(metrics)
+		if math.IsNaN(score) {
+			score = math.Inf(-1)
+		}
+		result[index] = OptimizationRun{Parameters: parameter_sets[index], Metrics: metrics, Score: score}
+	})
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	return result
+}
+
+// @func 滚动窗口 样本内优化 紧接着的样本外检验
+type WalkForwardWindow struct {
+	InSampleStart   string
+	InSampleEnd     string // 不含 也是样本外开始
+	OutSampleEnd    string // 不含
+	Best            OptimizationRun
+	OutSampleResult Result
+	OutSample       Metrics
+}
+
+type WalkForwardResult struct {
+	Windows    []WalkForwardWindow
+	Equity     []EquityPoint // 样本外资金曲线首尾相接
+	Trades     []Trade       // 样本外交易
+	Metrics    Metrics       // 拼接后的样本外指标
+	Efficiency float64       // 样本外平均得分除以样本内平均得分 远小于1说明过拟合
+}
+
+const kWalkForwardInitialEquity float64 = 1000000.0 // 拼接样本外资金曲线的初始资产
+
+// @func 拼接的样本外结果 用于导出
+func (result WalkForwardResult) Result() Result {
+	return Result{InitialCash: kWalkForwardInitialEquity, Equity: result.Equity, Trades: result.Trades}
+}
+
+// @func 滚动优化 在in_sample_days个交易日上选出最优参数 在之后out_sample_days个交易日上检验 窗口每次前进out_sample_days
+// @dates 按顺序排列的交易日 如基准指数的k线日期
+func WalkForward(parameter_sets []Parameters, runner OptimizeRunner, objective func(Metrics) float64, dates []string, in_sample_days int, out_sample_days int, workers int) WalkForwardResult {
+	result := WalkForwardResult{}
+	if in_sample_days <= 0 || out_sample_days <= 0 {
+		return result
+	}
+
+	dates_len := len(dates)
+	in_sample_score := 0.0
+	out_sample_score := 0.0
+	for start := 0; start+in_sample_days < dates_len; start += out_sample_days {
+		window := WalkForwardWindow{InSampleStart: dates[start], InSampleEnd: dates[start+in_sample_days]}
+		if start+in_sample_days+out_sample_days < dates_len {
+			window.OutSampleEnd = dates[start+in_sample_days+out_sample_days]
+		}
+
+		runs := Optimize(parameter_sets, runner, objective, window.InSampleStart, window.InSampleEnd, workers, nil)
+		if len(runs) <= 0 {
+			break
+		}
+		window.Best = runs[0]
+		window.OutSampleResult = runner(window.Best.Parameters, window.InSampleEnd, window.OutSampleEnd)
+		window.OutSample = CalculateResultMetrics(window.OutSampleResult)
+		in_sample_score += window.Best.Score
+		out_sample_score += objective(window.OutSample)
+
+		result.Windows = append(result.Windows, window)
+		if len(window.OutSampleEnd) <= 0 {
+			break
+		}
+	}
+
+	// 每个样本外窗口按收益率首尾相接
+	equity_value := kWalkForwardInitialEquity
+	for _, window := range result.Windows {
+		equity, initial_equity := resultEquity(window.OutSampleResult)
+		window_start_value := equity_value
+		scale := 0.0
+		if initial_equity > 0 {
+			scale = window_start_value / initial_equity
+		}
+		for _, point := range equity {
+			if scale > 0 {
+				equity_value = point.Equity * scale
+			}
+			position_value := point.PositionValue * scale
+			// 持仓按同样比例缩放 股数不再是整手
+			holdings := make([]Holding, len(point.Holdings))
+			for i, holding := range point.Holdings {
+				holdings[i] = Holding{Stock: holding.Stock, Quantity: int64(math.Round(float64(holding.Quantity) * scale)), Value: holding.Value * scale}
+			}
+			result.Equity = append(result.Equity, EquityPoint{Date: point.Date, Cash: equity_value - position_value, PositionValue: position_value, Equity: equity_value, Positions: point.Positions, Holdings: holdings})
+		}
+		result.Trades = append(result.Trades, window.OutSampleResult.Trades...)
+	}
+	result.Metrics = CalculateMetrics(result.Equity, kWalkForwardInitialEquity, result.Trades, nil)
+	if math.Abs(in_sample_score) > 1e-9 {
+		result.Efficiency = out_sample_score / in_sample_score
+	}
+
+	return result
+}
@@ -0,0 +1,266 @@
+package backtest
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 进度和预计剩余时间 多个协程可以同时调用Add
+type Progress struct {
+	mutex      sync.Mutex
+	name       string
+	total      int
+	done       int
+	start      time.Time
+	last_print time.Time
+}
+
+func NewProgress(name string, total int) *Progress {
+	now := time.Now()
+	return &Progress{name: name, total: total, start: now, last_print: now}
+}
+
+// @func 完成n个 每秒最多打印一次 全部完成时一定打印
+func (progress *Progress) Add(n int) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.done += n
+	now := time.Now()
+	if progress.done < progress.total && now.Sub(progress.last_print) < time.Second {
+		return
+	}
+	progress.last_print = now
+
+	elapsed := now.Sub(progress.start)
+	var remain time.Duration = 0
+	if progress.done > 0 {
+		remain = time.Duration(float64(elapsed) / float64(progress.done) * float64(progress.total-progress.done))
+	}
+	fmt.Printf("%s 进度 %d/%d %.1f%% 已用 %s 预计剩余 %s\n", progress.name, progress.done, progress.total, float64(progress.done)/float64(progress.total)*100.0, elapsed.Round(time.Second), remain.Round(time.Second))
+}
+
+// @func 协程数 0表示CPU核数
+func calculateWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// @func 用workers个协程处理0到count-1的每个索引
+// 调用方按索引保存结果 再按索引顺序合并 结果与协程数无关
+// @workers 协程数 0表示CPU核数
+// @progress 进度 可以为空
+func ParallelFor(count int, workers int, progress *Progress, handle func(index int)) {
+	workers = calculateWorkers(workers)
+	if workers > count {
+		workers = count
+	}
+
+	indexes := make(chan int, count)
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	var wait_group sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			for index := range indexes {
+				handle(index)
+				progress.Add(1)
+			}
+		}()
+	}
+	wait_group.Wait()
+}
+
+// @func 股票之间是否互不影响 不限制资金 不限制持仓数 没有仓位模型
+// @strategy 按日期调仓的策略需要看到整个股票池 不能拆开
+func (engine *Engine) isIndependent(strategy Strategy) bool {
+	if _, ok := strategy.(DateStrategy); ok {
+		return false
+	}
+	return engine.config.InitialCash <= 0 && engine.config.MaxPositions <= 0 && engine.config.Sizer == nil
+}
+
+// @func 并行运行回测 每只股票单独回测后按stock_items顺序合并
+// 股票之间会互相影响时 即限制资金 持仓数 使用仓位模型或者按日期调仓 退化为并行加载k线后串行运行
+// @new_strategy 为每只股票创建策略 策略不能在股票之间共享状态
+// @return 与协程数无关 与串行回测相同的回测结果
+func (engine *Engine) RunParallel(new_strategy func() Strategy, stock_items []data_center.StockItem) Result {
+	if strategy := new_strategy(); !engine.isIndependent(strategy) {
+		return engine.Run(strategy, stock_items)
+	}
+
+	var progress *Progress = nil
+	if engine.config.ShowProgress {
+		progress = NewProgress("回测", len(stock_items))
+	}
+
+	results := make([]Result, len(stock_items))
+	ParallelFor(len(stock_items), engine.config.Workers, progress, func(index int) {
+		config := engine.config
+		config.Workers = 1
+		config.ShowProgress = false
+		results[index] = NewEngine(config).Run(new_strategy(), stock_items[index:index+1])
+		if config.ReleaseKlineItems {
+			data_center.ReleaseKlineItems(stock_items[index])
+		}
+	})
+
+	engine.result = mergeResults(engine.config.InitialCash, results)
+	return engine.result
+}
+
+// @func 按股票顺序合并各只股票的回测结果 与串行回测的顺序一致
+func mergeResults(initial_cash float64, results []Result) Result {
+	merged := Result{InitialCash: initial_cash}
+
+	// 订单id按串行回测的分配顺序重新编号 即下单日期 股票顺序 同一股票的下单顺序
+	type mergedOrder struct {
+		index int
+		id    int64
+		date  string
+	}
+	orders := make([]mergedOrder, 0)
+	for index, result := range results {
+		for i, date := range result.order_dates {
+			orders = append(orders, mergedOrder{index: index, id: int64(i + 1), date: date})
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].date != orders[j].date {
+			return orders[i].date < orders[j].date
+		}
+		return orders[i].index < orders[j].index
+	})
+	order_ids := make([][]int64, len(results))
+	for index, result := range results {
+		order_ids[index] = make([]int64, len(result.order_dates)+1)
+	}
+	for i, order := range orders {
+		order_ids[order.index][order.id] = int64(i + 1)
+		merged.order_dates = append(merged.order_dates, order.date)
+	}
+
+	// 交易和成交按日期排序 同一日期按股票顺序
+	type mergedTrade struct {
+		index int
+		trade Trade
+	}
+	type mergedFill struct {
+		index int
+		fill  Fill
+	}
+	trades := make([]mergedTrade, 0)
+	fills := make([]mergedFill, 0)
+	for index, result := range results {
+		for _, trade := range result.Trades {
+			trades = append(trades, mergedTrade{index: index, trade: trade})
+		}
+		for _, fill := range result.Fills {
+			fill.OrderId = order_ids[index][fill.OrderId]
+			fills = append(fills, mergedFill{index: index, fill: fill})
+		}
+		merged.SkippedSignals += result.SkippedSignals
+		merged.data_fingerprints = append(merged.data_fingerprints, result.data_fingerprints...)
+	}
+	merged.DataSnapshotId = calculateDataSnapshotId(merged.data_fingerprints)
+
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].trade.ExitDate != trades[j].trade.ExitDate {
+			return trades[i].trade.ExitDate < trades[j].trade.ExitDate
+		}
+		return trades[i].index < trades[j].index
+	})
+	sort.SliceStable(fills, func(i, j int) bool {
+		if fills[i].fill.Date != fills[j].fill.Date {
+			return fills[i].fill.Date < fills[j].fill.Date
+		}
+		return fills[i].index < fills[j].index
+	})
+	merged.Trades = make([]Trade, len(trades))
+	for i, item := range trades {
+		merged.Trades[i] = item.trade
+	}
+	merged.Fills = make([]Fill, len(fills))
+	for i, item := range fills {
+		merged.Fills[i] = item.fill
+	}
+
+	merged.Equity = mergeEquity(initial_cash, results, merged.Fills)
+	return merged
+}
+
+// @func 合并资金曲线 某只股票没有k线的日期沿用它之前最近的持仓
+// 现金按成交顺序重新累加 持仓市值按股票代号顺序累加 与串行回测的浮点结果完全一致
+// @fills 按日期排序的全部成交
+func mergeEquity(initial_cash float64, results []Result, fills []Fill) []EquityPoint {
+	date_set := make(map[string]bool)
+	for _, result := range results {
+		for _, point := range result.Equity {
+			date_set[point.Date] = true
+		}
+	}
+	dates := make([]string, 0, len(date_set))
+	for date := range date_set {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	equity := make([]EquityPoint, len(dates))
+	for i, date := range dates {
+		equity[i].Date = date
+		equity[i].Holdings = make([]Holding, 0)
+	}
+	for _, result := range results {
+		cursor := -1
+		for i, date := range dates {
+			for cursor+1 < len(result.Equity) && result.Equity[cursor+1].Date <= date {
+				cursor++
+			}
+			if cursor < 0 {
+				continue
+			}
+			point := result.Equity[cursor]
+			equity[i].Positions += point.Positions
+			equity[i].Holdings = append(equity[i].Holdings, point.Holdings...)
+		}
+	}
+
+	cash := initial_cash
+	cursor := 0
+	for i := range equity {
+		for ; cursor < len(fills) && fills[cursor].Date <= equity[i].Date; cursor++ {
+			fill := fills[cursor]
+			value := float64(fill.Quantity) * PriceToYuan(fill.Price)
+			if fill.Side == OrderSide_Buy {
+				cash -= value + fill.Fee
+			} else {
+				cash += value - fill.Fee
+			}
+		}
+
+		sort.SliceStable(equity[i].Holdings, func(a, b int) bool {
+			return equity[i].Holdings[a].Stock.Symbol < equity[i].Holdings[b].Stock.Symbol
+		})
+		for _, holding := range equity[i].Holdings {
+			equity[i].PositionValue += holding.Value
+		}
+		equity[i].Cash = cash
+		equity[i].Equity = cash + equity[i].PositionValue
+	}
+	return equity
+}
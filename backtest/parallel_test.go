@@ -0,0 +1,112 @@
+package backtest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 随机游走的日k线 工作日为交易日 偶尔停牌
+// @seed 随机种子 相同种子生成相同的k线
+// @skip 跳过开头多少个交易日 模拟不同的上市日期
+func newRandomKlineItems(seed int64, count int, skip int) []data_center.KlineItem {
+	random := rand.New(rand.NewSource(seed))
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	pre_close := int64(100000 + random.Intn(100000))
+
+	kline_items := make([]data_center.KlineItem, 0, count)
+	for i := 0; len(kline_items) < count; i++ {
+		for date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+			date = date.AddDate(0, 0, 1)
+		}
+		day := date.Format("20060102")
+		date = date.AddDate(0, 0, 1)
+		if i < skip {
+			continue
+		}
+
+		open := pre_close * int64(980+random.Intn(40)) / 1000
+		close := pre_close * int64(950+random.Intn(100)) / 1000
+		high := max(open, close) * int64(1000+random.Intn(20)) / 1000
+		low := min(open, close) * int64(980+random.Intn(20)) / 1000
+		var volume uint64 = uint64(1000 + random.Intn(1000))
+		if random.Intn(30) == 0 {
+			open, high, low, close, volume = pre_close, pre_close, pre_close, pre_close, 0
+		}
+		kline_items = append(kline_items, data_center.KlineItem{Date: day, Open: open, High: high, Low: low, Close: close, Volume: volume, Percent: (float64(close)/float64(pre_close) - 1.0) * 100.0})
+		pre_close = close
+	}
+	return kline_items
+}
+
+// @func 每隔几根k线买入 持有几根k线后卖出
+type periodicStrategy struct {
+	hold_bars int
+}
+
+func (strategy *periodicStrategy) OnBar(ctx *BarContext) {
+	position := ctx.Position()
+	if position == nil || position.Quantity() <= 0 {
+		if ctx.Index%3 == 0 && len(ctx.PendingOrders()) == 0 {
+			ctx.BuyValue(10000, OrderType_NextOpen)
+		}
+		return
+	}
+	if ctx.Index-position.Lots[0].EntryIndex >= strategy.hold_bars {
+		ctx.SellAll(OrderType_NextClose, "hold")
+	}
+}
+
+func newTestStockItems(count int) ([]data_center.StockItem, func(data_center.StockItem) []data_center.KlineItem) {
+	stock_items := make([]data_center.StockItem, count)
+	kline_map := make(map[string][]data_center.KlineItem)
+	for i := range stock_items {
+		stock_items[i] = data_center.StockItem{Symbol: fmt.Sprintf("SH6%05d", i), Name: fmt.Sprintf("股票%d", i)}
+		kline_map[stock_items[i].Symbol] = newRandomKlineItems(int64(i+1), 120, i*7)
+	}
+	return stock_items, func(stock_item data_center.StockItem) []data_center.KlineItem {
+		return kline_map[stock_item.Symbol]
+	}
+}
+
+// 不限制资金时股票之间互不影响 串行和并行 不同协程数的结果都应该相同
+func TestRunParallelDeterministic(t *testing.T) {
+	stock_items, loader := newTestStockItems(20)
+	run := func(parallel bool, workers int) Result {
+		config := DefaultConfig()
+		config.KlineLoader = loader
+		config.Workers = workers
+		engine := NewEngine(config)
+		if parallel {
+			return engine.RunParallel(func() Strategy { return &periodicStrategy{hold_bars: 4} }, stock_items)
+		}
+		return engine.Run(&periodicStrategy{hold_bars: 4}, stock_items)
+	}
+
+	want := run(false, 1)
+	if len(want.Trades) == 0 || len(want.Fills) == 0 {
+		t.Fatalf("no trades")
+	}
+	for _, parallel := range []bool{false, true} {
+		for _, workers := range []int{1, 8} {
+			got := run(parallel, workers)
+			name := fmt.Sprintf("parallel %v workers %d", parallel, workers)
+			if got.DataSnapshotId != want.DataSnapshotId {
+				t.Errorf("%s: DataSnapshotId got %s want %s", name, got.DataSnapshotId, want.DataSnapshotId)
+			}
+			if !reflect.DeepEqual(got.Trades, want.Trades) {
+				t.Errorf("%s: trades differ", name)
+			}
+			if !reflect.DeepEqual(got.Fills, want.Fills) {
+				t.Errorf("%s: fills differ", name)
+			}
+			if !reflect.DeepEqual(got.Equity, want.Equity) {
+				t.Errorf("%s: equity differ", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package backtest
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 仓位计算上下文
+type SizingContext struct {
+	Stock        data_center.StockItem
+	Equity       float64                 // 总资产 单位元
+	Cash         float64                 // 可用现金 单位元 已扣除当日其他信号占用
+	MaxPositions int                     // 最大持仓数 0表示不限制
+	KlineItems   []data_center.KlineItem // 截止信号日的k线
+	Trades       []Trade                 // 已经完成的交易
+}
+
+// @func 仓位模型
+type PositionSizer interface {
+	// @return 买入金额 单位元
+	Size(ctx SizingContext) float64
+}
+
+// @func 等权 总资产除以最大持仓数
+type EqualWeightSizer struct{}
+
+func (sizer EqualWeightSizer) Size(ctx SizingContext) float64 {
+	if ctx.MaxPositions <= 0 {
+		return ctx.Equity
+	}
+	return ctx.Equity / float64(ctx.MaxPositions)
+}
+
+// @func 固定比例 总资产乘以Fraction
+type FixedFractionSizer struct {
+	Fraction float64 // 0.1表示10%
+}
+
+func (sizer FixedFractionSizer) Size(ctx SizingContext) float64 {
+	return ctx.Equity * sizer.Fraction
+}
+
+// @func 目标波动率 波动越大仓位越小
+type VolatilityTargetSizer struct {
+	TargetVolatility float64 // 单只股票目标年化波动率 0.2表示20%
+	Lookback         int     // 计算波动率的k线数
+	MaxWeight        float64 // 单只股票最大仓位比例
+}
+
+func (sizer VolatilityTargetSizer) Size(ctx SizingContext) float64 {
+	volatility := CalculateAnnualizedVolatility(ctx.KlineItems, sizer.Lookback)
+	if volatility <= 0 {
+		return 0.0
+	}
+	return ctx.Equity * math.Min(sizer.TargetVolatility/volatility, sizer.MaxWeight)
+}
+
+// @func 凯利公式 按已完成交易估计胜率和盈亏比 样本不足时使用默认值
+type KellySizer struct {
+	Fraction           float64 // 凯利比例的系数 0.5表示半凯利
+	Cap                float64 // 单只股票最大仓位比例
+	MinTrades          int     // 估计胜率需要的最少交易数
+	DefaultWinRate     float64 // 默认胜率 0.5表示50%
+	DefaultPayoffRatio float64 // 默认盈亏比
+}
+
+func (sizer KellySizer) Size(ctx SizingContext) float64 {
+	win_rate, payoff_ratio := sizer.DefaultWinRate, sizer.DefaultPayoffRatio
+	if len(ctx.Trades) >= sizer.MinTrades && len(ctx.Trades) > 0 {
+		win_count, win_sum, loss_sum := 0, 0.0, 0.0
+		for _, trade := range ctx.Trades {
+			if trade.Profit > 0 {
+				win_count++
+				win_sum += trade.ReturnRate
+			} else {
+				loss_sum -= trade.ReturnRate
+			}
+		}
+		loss_count := len(ctx.Trades) - win_count
+		win_rate = float64(win_count) / float64(len(ctx.Trades))
+		if win_count > 0 && loss_count > 0 && loss_sum > 0 {
+			payoff_ratio = (win_sum / float64(win_count)) / (loss_sum / float64(loss_count))
+		}
+	}
+	if payoff_ratio <= 0 {
+		return 0.0
+	}
+
+	kelly := win_rate - (1.0-win_rate)/payoff_ratio
+	weight := math.Max(0.0, math.Min(kelly*sizer.Fraction, sizer.Cap))
+	return ctx.Equity * weight
+}
+
+// @func 计算年化波动率 按250个交易日
+// @lookback 使用最近多少根k线
+func CalculateAnnualizedVolatility(kline_items []data_center.KlineItem, lookback int) float64 {
+	kline_items_len := len(kline_items)
+	start := kline_items_len - lookback
+	if start < 1 {
+		start = 1
+	}
+	n := kline_items_len - start
+	if n < 2 {
+		return 0.0
+	}
+
+	returns := make([]float64, 0, n)
+	for i := start; i < kline_items_len; i++ {
+		if kline_items[i-1].Close <= 0 {
+			continue
+		}
+		returns = append(returns, float64(kline_items[i].Close)/float64(kline_items[i-1].Close)-1.0)
+	}
+
+	return CalculateStd(returns) * math.Sqrt(kTradeDaysPerYear)
+}
+
+// @func 样本标准差
+func CalculateStd(array []float64) float64 {
+	n := float64(len(array))
+	if n < 2 {
+		return 0.0
+	}
+
+	sum := 0.0
+	for _, value := range array {
+		sum += value
+	}
+	avg := sum / n
+
+	square_sum := 0.0
+	for _, value := range array {
+		square_sum += (value - avg) * (value - avg)
+	}
+
+	return math.Sqrt(square_sum / (n - 1.0))
+}
+
+type entryRequest struct {
+	state *symbolState
+	order Order
+	index int // 信号k线索引
+}
+
+// @func 处理当日的开仓信号 按Score从高到低占用空余仓位 并计算买入金额
+func (engine *Engine) admitEntries() {
+	entries := engine.day_entries
+	engine.day_entries = nil
+	if len(entries) <= 0 {
+		return
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].order.Score > entries[j].order.Score
+	})
+
+	// 已持仓和已下单未成交的股票都占用仓位
+	occupied := make(map[string]bool)
+	for symbol := range engine.positions {
+		occupied[symbol] = true
+	}
+	for _, state := range engine.states {
+		for _, order := range state.pending {
+			if order.Side == OrderSide_Buy {
+				occupied[state.stock.Symbol] = true
+			}
+		}
+	}
+
+	equity := engine.equity()
+	reserved := 0.0
+	for _, entry := range entries {
+		symbol := entry.state.stock.Symbol
+		// 已经持有或者已下单的股票不加仓 也算放弃的信号
+		if occupied[symbol] {
+			engine.result.SkippedSignals++
+			continue
+		}
+		if engine.config.MaxPositions > 0 && len(occupied) >= engine.config.MaxPositions {
+			engine.result.SkippedSignals++
+			continue
+		}
+
+		value := entry.order.Value
+		if engine.config.Sizer != nil {
+			value = engine.config.Sizer.Size(SizingContext{
+				Stock:        entry.state.stock,
+				Equity:       equity,
+				Cash:         engine.cash - reserved,
+				MaxPositions: engine.config.MaxPositions,
+				KlineItems:   entry.state.kline_items[0 : entry.index+1 : entry.index+1],
+				Trades:       engine.result.Trades,
+			})
+		}
+		if engine.config.InitialCash > 0 {
+			value = math.Min(value, engine.cash-reserved)
+		}
+		if value <= 0 {
+			engine.result.SkippedSignals++
+			continue
+		}
+
+		entry.order.Value = value
+		reserved += value
+		occupied[symbol] = true
+		entry.state.pending = append(entry.state.pending, entry.order)
+	}
+}
@@ -0,0 +1,146 @@
+package backtest
+
+import (
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 按日期调仓的策略 每个交易日全部股票的OnBar之后回调一次OnDate
+// 适合轮动这类需要横向比较股票池的策略 OnBar可以为空实现
+type DateStrategy interface {
+	Strategy
+	OnDate(ctx *DateContext)
+}
+
+// @func 调仓订单 下一个交易日先于各股票的OnBar成交 全部卖单先于买单
+type rebalanceOrder struct {
+	state *symbolState
+	order Order
+}
+
+// @func 交易日的回调上下文 可以看到股票池每只股票截止当日的k线
+type DateContext struct {
+	engine *Engine
+
+	Date   string
+	Stocks []data_center.StockItem // 股票池 与Run的stock_items顺序一致
+	Rules  TradingRules            // 回测配置的交易规则 用于按整手计算股数
+}
+
+// @func 截止当日的k线 没有k线时为空
+// @index 股票在Stocks中的索引
+func (ctx *DateContext) KlineItems(index int) []data_center.KlineItem {
+	state := ctx.engine.states[index]
+	cursor := state.cursor
+	for cursor < len(state.kline_items) && state.kline_items[cursor].Date <= ctx.Date {
+		cursor++
+	}
+	return state.kline_items[0:cursor:cursor]
+}
+
+// @func 当日是否有k线 停牌或者还没上市时没有
+func (ctx *DateContext) HasBar(index int) bool {
+	kline_items := ctx.KlineItems(index)
+	return len(kline_items) > 0 && kline_items[len(kline_items)-1].Date == ctx.Date
+}
+
+// @func 股票的持仓 没有持仓返回nil
+func (ctx *DateContext) Position(index int) *Position {
+	position, ok := ctx.engine.positions[ctx.Stocks[index].Symbol]
+	if !ok {
+		return nil
+	}
+	return position
+}
+
+// @func 可用现金 单位元
+func (ctx *DateContext) Cash() float64 {
+	return ctx.engine.cash
+}
+
+// @func 总资产 单位元 持仓按最近收盘价计算
+func (ctx *DateContext) Equity() float64 {
+	return ctx.engine.equity()
+}
+
+func (ctx *DateContext) placeOrder(index int, order Order) int64 {
+	order.Id = ctx.engine.newOrderId(ctx.Date)
+	order.Stock = ctx.Stocks[index]
+	order.SignalDate = ctx.Date
+	ctx.engine.rebalances = append(ctx.engine.rebalances, rebalanceOrder{state: ctx.engine.states[index], order: order})
+	return order.Id
+}
+
+// @func 按金额买入 下一个交易日成交 资金不足时减少股数
+// @value 单位元
+func (ctx *DateContext) BuyValue(index int, value float64, order_type OrderType) int64 {
+	return ctx.placeOrder(index, Order{Side: OrderSide_Buy, Type: order_type, Value: value})
+}
+
+// @func 按股数卖出 先进先出
+func (ctx *DateContext) Sell(index int, quantity int64, order_type OrderType, reason string) int64 {
+	return ctx.placeOrder(index, Order{Side: OrderSide_Sell, Type: order_type, Quantity: quantity, Reason: reason})
+}
+
+// @func 卖出全部持仓
+func (ctx *DateContext) SellAll(index int, order_type OrderType, reason string) int64 {
+	return ctx.placeOrder(index, Order{Side: OrderSide_Sell, Type: order_type, Reason: reason})
+}
+
+func (engine *Engine) onDate(date string) {
+	strategy, ok := engine.strategy.(DateStrategy)
+	if !ok {
+		return
+	}
+
+	stocks := make([]data_center.StockItem, len(engine.states))
+	for i, state := range engine.states {
+		stocks[i] = state.stock
+	}
+	strategy.OnDate(&DateContext{engine: engine, Date: date, Stocks: stocks, Rules: engine.config.Rules})
+}
+
+// @func 成交上一个交易日的调仓订单 卖出先于买入释放资金
+// 当日没有k线的卖单顺延 买单取消
+func (engine *Engine) fillRebalanceOrders(date string) {
+	if len(engine.rebalances) <= 0 {
+		return
+	}
+
+	orders := engine.rebalances
+	engine.rebalances = nil
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].order.Type != orders[j].order.Type {
+			return orders[i].order.Type < orders[j].order.Type
+		}
+		return orders[i].order.Side > orders[j].order.Side
+	})
+	for _, item := range orders {
+		state := item.state
+		for state.cursor < len(state.kline_items) && state.kline_items[state.cursor].Date < date {
+			state.cursor++
+		}
+		has_bar := state.cursor < len(state.kline_items) && state.kline_items[state.cursor].Date == date
+		if !has_bar {
+			if item.order.Side == OrderSide_Sell {
+				engine.rebalances = append(engine.rebalances, item)
+			}
+			continue
+		}
+
+		order := item.order
+		if !engine.fillOrder(state, &order, state.cursor) && order.Side == OrderSide_Sell {
+			engine.rebalances = append(engine.rebalances, rebalanceOrder{state: state, order: order})
+		}
+	}
+}
+
+// @func 回测结束时的持仓 按股票代号排序
+func (engine *Engine) Positions() []Position {
+	result := make([]Position, 0, len(engine.positions))
+	for _, position := range engine.sortedPositions() {
+		result = append(result, *position)
+	}
+	return result
+}
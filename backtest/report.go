@@ -0,0 +1,526 @@
+package backtest
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+const kReportChartWidth float64 = 960
+const kReportChartHeight float64 = 320
+const kReportHistogramBins int = 30 // 收益分布的分组数
+const kReportMaxTrades int = 10000  // 交易表最多显示的交易数 超过时只显示最近的
+
+// @func 图表上的文字或网格线
+type reportLabel struct {
+	X    float64
+	Y    float64
+	Text string
+}
+
+// @func 折线或填充区域
+type reportLine struct {
+	Name    string
+	Color   string
+	Points  string  // svg的points属性
+	LegendY float64 // 图例的位置
+}
+
+// @func 柱状图的柱子
+type reportBar struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+	Color  string
+	Title  string // 鼠标悬停时显示
+}
+
+// @func 内嵌svg图表 坐标都是svg像素
+type reportChart struct {
+	Title      string
+	Width      float64
+	Height     float64
+	PlotLeft   float64
+	PlotRight  float64
+	PlotTop    float64
+	PlotBottom float64
+	GridLines  []reportLabel // 水平网格线 Y是位置 Text是刻度
+	XLabels    []reportLabel
+	Lines      []reportLine
+	Areas      []reportLine
+	Bars       []reportBar
+
+	y_min float64
+	y_max float64
+}
+
+// @func 指标名称和取值
+type reportItem struct {
+	Name  string
+	Value string
+}
+
+// @func 月度收益热力图的单元格
+type reportCell struct {
+	Text  string
+	Color string
+}
+
+// @func 月度收益热力图的一行 一年12个月加全年
+type reportMonthRow struct {
+	Year   string
+	Months [12]reportCell
+	Total  reportCell
+}
+
+type reportData struct {
+	Manifest       RunManifest
+	Parameters     []reportItem
+	MetricItems    []reportItem
+	BenchmarkName  string
+	BenchmarkItems []reportItem
+	EquityChart    reportChart
+	DrawdownChart  reportChart
+	HistogramChart reportChart
+	MonthRows      []reportMonthRow
+	Trades         []TradeRecord
+	TradeCount     int
+}
+
+// @func 创建图表 纵轴范围上下各留5% 画5条水平网格线
+// @y_format 纵轴刻度的格式 如 %.2f
+func newReportChart(title string, y_min float64, y_max float64, y_format string) reportChart {
+	if y_max-y_min < 1e-9 {
+		y_min -= 1.0
+		y_max += 1.0
+	}
+	padding := (y_max - y_min) * 0.05
+	chart := reportChart{
+		Title:      title,
+		Width:      kReportChartWidth,
+		Height:     kReportChartHeight,
+		PlotLeft:   70,
+		PlotRight:  kReportChartWidth - 20,
+		PlotTop:    30,
+		PlotBottom: kReportChartHeight - 30,
+		y_min:      y_min - padding,
+		y_max:      y_max + padding,
+	}
+	const kGridCount int = 5
+	for i := 0; i <= kGridCount; i++ {
+		value := chart.y_min + (chart.y_max-chart.y_min)*float64(i)/float64(kGridCount)
+		chart.GridLines = append(chart.GridLines, reportLabel{X: chart.PlotLeft - 6, Y: chart.pointY(value), Text: fmt.Sprintf(y_format, value)})
+	}
+	return chart
+}
+
+// @position 0-1 对应绘图区的左右边界
+func (chart *reportChart) pointX(position float64) float64 {
+	return chart.PlotLeft + (chart.PlotRight-chart.PlotLeft)*position
+}
+
+func (chart *reportChart) pointY(value float64) float64 {
+	return chart.PlotBottom - (chart.PlotBottom-chart.PlotTop)*(value-chart.y_min)/(chart.y_max-chart.y_min)
+}
+
+// @func 序列的第i个点在横轴上的位置 只有一个点时放在中间
+func seriesPosition(i int, count int) float64 {
+	if count <= 1 {
+		return 0.5
+	}
+	return float64(i) / float64(count-1)
+}
+
+// @func 折线的points属性
+func (chart *reportChart) seriesPoints(values []float64) string {
+	points := make([]byte, 0, len(values)*16)
+	for i, value := range values {
+		points = fmt.Appendf(points, "%.1f,%.1f ", chart.pointX(seriesPosition(i, len(values))), chart.pointY(value))
+	}
+	return string(points)
+}
+
+// @func 横轴均匀标注几个日期
+func (chart *reportChart) addDateLabels(dates []string) {
+	const kLabelCount int = 6
+	dates_len := len(dates)
+	if dates_len <= 0 {
+		return
+	}
+	for i := 0; i < kLabelCount; i++ {
+		index := int(math.Round(float64(dates_len-1) * float64(i) / float64(kLabelCount-1)))
+		if i > 0 && index == int(math.Round(float64(dates_len-1)*float64(i-1)/float64(kLabelCount-1))) {
+			continue
+		}
+		chart.XLabels = append(chart.XLabels, reportLabel{X: chart.pointX(seriesPosition(index, dates_len)), Y: chart.PlotBottom + 18, Text: dates[index]})
+	}
+}
+
+// @func 热力图颜色 上涨红色 下跌绿色 10%及以上颜色最深
+func heatColor(value float64) string {
+	intensity := math.Min(math.Abs(value)/10.0, 1.0)
+	red, green, blue := 231.0, 76.0, 60.0
+	if value < 0 {
+		red, green, blue = 39.0, 174.0, 96.0
+	}
+	mix := func(color float64) int {
+		return int(math.Round(255.0 + (color-255.0)*intensity))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", mix(red), mix(green), mix(blue))
+}
+
+// @func 资金曲线和基准的净值 基准为空时只有策略
+func newEquityChart(equity []EquityPoint, initial_equity float64, benchmark data_center.StockItem, benchmark_kline_items []data_center.KlineItem) reportChart {
+	strategy_values := make([]float64, len(equity))
+	dates := make([]string, len(equity))
+	for i, point := range equity {
+		strategy_values[i] = point.Equity / initial_equity
+		dates[i] = point.Date
+	}
+
+	benchmark_values := make([]float64, 0)
+	pre_benchmark_close, benchmark_close := alignBenchmarkClose(equity, benchmark_kline_items)
+	if pre_benchmark_close > 0 {
+		for _, close := range benchmark_close {
+			if close <= 0 {
+				close = pre_benchmark_close
+			}
+			benchmark_values = append(benchmark_values, close/pre_benchmark_close)
+		}
+	}
+
+	y_min, y_max := math.Inf(1), math.Inf(-1)
+	for _, values := range [][]float64{strategy_values, benchmark_values} {
+		for _, value := range values {
+			y_min = math.Min(y_min, value)
+			y_max = math.Max(y_max, value)
+		}
+	}
+	if y_min > y_max {
+		y_min, y_max = 1.0, 1.0
+	}
+	chart := newReportChart("净值曲线", y_min, y_max, "%.2f")
+	chart.addDateLabels(dates)
+	chart.Lines = append(chart.Lines, reportLine{Name: "策略", Color: "#c0392b", Points: chart.seriesPoints(strategy_values), LegendY: chart.PlotTop - 16})
+	if len(benchmark_values) > 0 {
+		chart.Lines = append(chart.Lines, reportLine{Name: fmt.Sprintf("%s(%s)", benchmark.Name, benchmark.Symbol), Color: "#2980b9", Points: chart.seriesPoints(benchmark_values), LegendY: chart.PlotTop - 4})
+	}
+	return chart
+}
+
+// @func 水下回撤图 相对之前最高资产的回撤
+func newDrawdownChart(records []EquityRecord) reportChart {
+	values := make([]float64, len(records))
+	dates := make([]string, len(records))
+	y_min := 0.0
+	for i, record := range records {
+		values[i] = -record.Drawdown
+		dates[i] = record.Date
+		y_min = math.Min(y_min, values[i])
+	}
+
+	chart := newReportChart("水下回撤", y_min, 0.0, "%.1f%%")
+	chart.addDateLabels(dates)
+	if len(values) > 0 {
+		baseline := chart.pointY(0.0)
+		points := fmt.Sprintf("%.1f,%.1f %s%.1f,%.1f", chart.pointX(seriesPosition(0, len(values))), baseline, chart.seriesPoints(values), chart.pointX(seriesPosition(len(values)-1, len(values))), baseline)
+		chart.Areas = append(chart.Areas, reportLine{Name: "回撤", Color: "#27ae60", Points: points})
+	}
+	return chart
+}
+
+// @func 交易收益率分布直方图
+func newHistogramChart(trades []Trade) reportChart {
+	if len(trades) <= 0 {
+		return newReportChart("交易收益率分布", 0.0, 1.0, "%.0f")
+	}
+
+	min_rate, max_rate := math.Inf(1), math.Inf(-1)
+	for _, trade := range trades {
+		min_rate = math.Min(min_rate, trade.ReturnRate)
+		max_rate = math.Max(max_rate, trade.ReturnRate)
+	}
+	min_rate = math.Floor(min_rate)
+	max_rate = math.Ceil(max_rate)
+	if max_rate <= min_rate {
+		max_rate = min_rate + 1.0
+	}
+	bin_width := (max_rate - min_rate) / float64(kReportHistogramBins)
+
+	counts := make([]int, kReportHistogramBins)
+	max_count := 0
+	for _, trade := range trades {
+		bin := int((trade.ReturnRate - min_rate) / bin_width)
+		if bin >= kReportHistogramBins {
+			bin = kReportHistogramBins - 1
+		}
+		counts[bin]++
+		if counts[bin] > max_count {
+			max_count = counts[bin]
+		}
+	}
+
+	chart := newReportChart("交易收益率分布", 0.0, float64(max_count), "%.0f")
+	bar_width := (chart.PlotRight - chart.PlotLeft) / float64(kReportHistogramBins)
+	baseline := chart.pointY(0.0)
+	for i, count := range counts {
+		low := min_rate + bin_width*float64(i)
+		high := low + bin_width
+		color := "#e74c3c"
+		if (low+high)/2.0 < 0 {
+			color = "#27ae60"
+		}
+		top := chart.pointY(float64(count))
+		chart.Bars = append(chart.Bars, reportBar{
+			X:      chart.PlotLeft + bar_width*float64(i) + 1,
+			Y:      top,
+			Width:  math.Max(bar_width-2, 1),
+			Height: baseline - top,
+			Color:  color,
+			Title:  fmt.Sprintf("%.2f%% ~ %.2f%% %d笔", low, high, count),
+		})
+		if i%5 == 0 {
+			chart.XLabels = append(chart.XLabels, reportLabel{X: chart.PlotLeft + bar_width*float64(i), Y: chart.PlotBottom + 18, Text: fmt.Sprintf("%.1f%%", low)})
+		}
+	}
+	chart.XLabels = append(chart.XLabels, reportLabel{X: chart.PlotRight, Y: chart.PlotBottom + 18, Text: fmt.Sprintf("%.1f%%", max_rate)})
+	return chart
+}
+
+// @func 按月复利的收益率 每年一行
+func newMonthRows(equity []EquityPoint, initial_equity float64) []reportMonthRow {
+	rows := make([]reportMonthRow, 0)
+	pre_month_equity := initial_equity
+	pre_year_equity := initial_equity
+	for i, point := range equity {
+		year := point.Date[0:4]
+		if len(rows) <= 0 || rows[len(rows)-1].Year != year {
+			rows = append(rows, reportMonthRow{Year: year})
+		}
+
+		// 当月或当年最后一天
+		is_last := i+1 >= len(equity)
+		month_end := is_last || equity[i+1].Date[0:6] != point.Date[0:6]
+		year_end := is_last || equity[i+1].Date[0:4] != year
+		row := &rows[len(rows)-1]
+		if month_end {
+			month, _ := strconv.Atoi(point.Date[4:6])
+			if month >= 1 && month <= 12 && pre_month_equity > 0 {
+				value := (point.Equity/pre_month_equity - 1.0) * 100.0
+				row.Months[month-1] = reportCell{Text: fmt.Sprintf("%.2f", value), Color: heatColor(value)}
+			}
+			pre_month_equity = point.Equity
+		}
+		if year_end {
+			if pre_year_equity > 0 {
+				value := (point.Equity/pre_year_equity - 1.0) * 100.0
+				row.Total = reportCell{Text: fmt.Sprintf("%.2f", value), Color: heatColor(value)}
+			}
+			pre_year_equity = point.Equity
+		}
+	}
+	return rows
+}
+
+// @func 回测指标的名称和取值 名称与Metrics.String一致
+func newMetricItems(metrics Metrics) []reportItem {
+	return []reportItem{
+		{"区间", fmt.Sprintf("%s-%s", metrics.StartDate, metrics.EndDate)},
+		{"期初资产", fmt.Sprintf("%.2f", metrics.InitialEquity)},
+		{"期末资产", fmt.Sprintf("%.2f", metrics.FinalEquity)},
+		{"总收益率", fmt.Sprintf("%.2f%%", metrics.TotalReturn)},
+		{"年化收益率", fmt.Sprintf("%.2f%%", metrics.Cagr)},
+		{"年化波动率", fmt.Sprintf("%.2f%%", metrics.AnnualVolatility)},
+		{"夏普", fmt.Sprintf("%.2f", metrics.Sharpe)},
+		{"索提诺", fmt.Sprintf("%.2f", metrics.Sortino)},
+		{"最大回撤", fmt.Sprintf("%.2f%% (%s-%s)", metrics.MaxDrawdown, metrics.MaxDrawdownStart, metrics.MaxDrawdownEnd)},
+		{"最长回撤", fmt.Sprintf("%d根k线", metrics.MaxDrawdownDuration)},
+		{"卡玛", fmt.Sprintf("%.2f", metrics.Calmar)},
+		{"交易次数", fmt.Sprintf("%d", metrics.TradeCount)},
+		{"胜率", fmt.Sprintf("%.2f%%", metrics.WinRate)},
+		{"利润因子", fmt.Sprintf("%.2f", metrics.ProfitFactor)},
+		{"期望", fmt.Sprintf("%.2f(%.2f%%)", metrics.Expectancy, metrics.ExpectancyRate)},
+		{"平均盈利", fmt.Sprintf("%.2f", metrics.AvgWin)},
+		{"平均亏损", fmt.Sprintf("%.2f", metrics.AvgLoss)},
+		{"最长连亏", fmt.Sprintf("%d", metrics.LongestLosingStreak)},
+		{"持仓时间占比", fmt.Sprintf("%.2f%%", metrics.Exposure)},
+		{"年化换手", fmt.Sprintf("%.2f倍", metrics.Turnover)},
+	}
+}
+
+// @func 相对基准指标的名称和取值 名称与BenchmarkMetrics.String一致
+func newBenchmarkItems(metrics BenchmarkMetrics) []reportItem {
+	return []reportItem{
+		{"基准收益率", fmt.Sprintf("%.2f%%", metrics.BenchmarkReturn)},
+		{"超额收益率", fmt.Sprintf("%.2f%%", metrics.ExcessReturn)},
+		{"年化超额", fmt.Sprintf("%.2f%%", metrics.AnnualExcessReturn)},
+		{"alpha", fmt.Sprintf("%.2f%%", metrics.Alpha)},
+		{"beta", fmt.Sprintf("%.2f", metrics.Beta)},
+		{"相关系数", fmt.Sprintf("%.2f", metrics.Correlation)},
+		{"跟踪误差", fmt.Sprintf("%.2f%%", metrics.TrackingError)},
+		{"信息比率", fmt.Sprintf("%.2f", metrics.InformationRatio)},
+		{"上涨捕获", fmt.Sprintf("%.2f%%", metrics.UpCapture)},
+		{"下跌捕获", fmt.Sprintf("%.2f%%", metrics.DownCapture)},
+	}
+}
+
+// @func 生成单个离线html回测报告 图表为内嵌svg 不依赖外部js和css
+// @manifest 运行清单 提供名称 参数和数据快照
+// @benchmark_kline_items 基准的日k线 为空时不画基准
+func WriteHtmlReport(path string, manifest RunManifest, result Result, benchmark data_center.StockItem, benchmark_kline_items []data_center.KlineItem) error {
+	equity, initial_equity := resultEquity(result)
+	if initial_equity <= 0 {
+		initial_equity = 1.0
+	}
+	records := NewEquityRecords(result)
+
+	data := reportData{
+		Manifest:       manifest,
+		MetricItems:    newMetricItems(manifest.Metrics),
+		EquityChart:    newEquityChart(equity, initial_equity, benchmark, benchmark_kline_items),
+		DrawdownChart:  newDrawdownChart(records),
+		HistogramChart: newHistogramChart(result.Trades),
+		MonthRows:      newMonthRows(equity, initial_equity),
+		TradeCount:     len(result.Trades),
+	}
+
+	names := make([]string, 0, len(manifest.Parameters))
+	for name := range manifest.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data.Parameters = append(data.Parameters, reportItem{Name: name, Value: manifest.Parameters[name]})
+	}
+
+	if len(benchmark_kline_items) > 0 {
+		data.BenchmarkName = fmt.Sprintf("%s(%s)", benchmark.Name, benchmark.Symbol)
+		data.BenchmarkItems = newBenchmarkItems(CalculateBenchmarkMetrics(equity, initial_equity, benchmark, benchmark_kline_items))
+	}
+
+	trades := result.Trades
+	if len(trades) > kReportMaxTrades {
+		trades = trades[len(trades)-kReportMaxTrades:]
+	}
+	data.Trades = NewTradeRecords(trades)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return report_template.Execute(file, data)
+}
+
+var report_template = template.Must(template.New("report").Parse(kReportTemplate))
+
+const kReportTemplate string = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Manifest.Name}} 回测报告</title>
+<style>
+body { font-family: "Microsoft YaHei", "PingFang SC", sans-serif; margin: 24px; color: #333; }
+h1 { font-size: 22px; }
+h2 { font-size: 17px; margin-top: 28px; border-left: 4px solid #c0392b; padding-left: 8px; }
+table { border-collapse: collapse; font-size: 13px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: right; }
+th { background: #f5f5f5; }
+td.text, th.text { text-align: left; }
+.items { display: flex; flex-wrap: wrap; gap: 8px; }
+.item { border: 1px solid #ddd; border-radius: 4px; padding: 6px 10px; min-width: 120px; }
+.item .name { color: #888; font-size: 12px; }
+.item .value { font-size: 15px; margin-top: 2px; }
+#trades th { cursor: pointer; user-select: none; }
+svg text { font-size: 11px; fill: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Manifest.Name}} 回测报告</h1>
+<p>生成时间 {{.Manifest.CreatedAt}} 数据快照 {{.Manifest.DataSnapshotId}} 股票数 {{.Manifest.StockCount}} 区间 {{.Manifest.StartDate}}-{{.Manifest.EndDate}}</p>
+
+<h2>指标</h2>
+<div class="items">
+{{range .MetricItems}}<div class="item"><div class="name">{{.Name}}</div><div class="value">{{.Value}}</div></div>
+{{end}}</div>
+{{if .BenchmarkItems}}
+<h2>相对基准 {{.BenchmarkName}}</h2>
+<div class="items">
+{{range .BenchmarkItems}}<div class="item"><div class="name">{{.Name}}</div><div class="value">{{.Value}}</div></div>
+{{end}}</div>
+{{end}}
+
+{{template "chart" .EquityChart}}
+{{template "chart" .DrawdownChart}}
+
+<h2>月度收益率(%)</h2>
+<table>
+<tr><th class="text">年份</th><th>1月</th><th>2月</th><th>3月</th><th>4月</th><th>5月</th><th>6月</th><th>7月</th><th>8月</th><th>9月</th><th>10月</th><th>11月</th><th>12月</th><th>全年</th></tr>
+{{range .MonthRows}}<tr><td class="text">{{.Year}}</td>{{range .Months}}<td{{if .Color}} style="background-color: {{.Color}}"{{end}}>{{.Text}}</td>{{end}}<td{{if .Total.Color}} style="background-color: {{.Total.Color}}"{{end}}><b>{{.Total.Text}}</b></td></tr>
+{{end}}</table>
+
+{{template "chart" .HistogramChart}}
+
+<h2>交易明细</h2>
+<p>共 {{.TradeCount}} 笔{{if lt (len .Trades) .TradeCount}} 只显示最近 {{len .Trades}} 笔{{end}} 点击表头排序</p>
+<table id="trades">
+<thead><tr>
+<th class="text" data-type="text">代号</th><th class="text" data-type="text">名称</th><th class="text" data-type="text">信号日期</th>
+<th class="text" data-type="text">买入日期</th><th data-type="number">买入价</th><th class="text" data-type="text">卖出日期</th><th data-type="number">卖出价</th>
+<th data-type="number">数量</th><th data-type="number">费用</th><th data-type="number">收益</th><th data-type="number">收益率(%)</th><th data-type="number">持有k线</th><th class="text" data-type="text">平仓原因</th>
+</tr></thead>
+<tbody>
+{{range .Trades}}<tr><td class="text">{{.Symbol}}</td><td class="text">{{.Name}}</td><td class="text">{{.SignalDate}}</td><td class="text">{{.EntryDate}}</td><td>{{printf "%.3f" .EntryPrice}}</td><td class="text">{{.ExitDate}}</td><td>{{printf "%.3f" .ExitPrice}}</td><td>{{.Quantity}}</td><td>{{printf "%.2f" .Fee}}</td><td>{{printf "%.2f" .Profit}}</td><td>{{printf "%.2f" .ReturnRate}}</td><td>{{.HoldingBars}}</td><td class="text">{{.ExitReason}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2>参数</h2>
+<table>
+{{range .Parameters}}<tr><td class="text">{{.Name}}</td><td class="text">{{.Value}}</td></tr>
+{{end}}</table>
+
+<script>
+(function () {
+	var table = document.getElementById("trades");
+	var headers = table.tHead.rows[0].cells;
+	var ascending = {};
+	for (var i = 0; i < headers.length; i++) {
+		headers[i].addEventListener("click", (function (column, type) {
+			return function () {
+				ascending[column] = !ascending[column];
+				var body = table.tBodies[0];
+				var rows = Array.prototype.slice.call(body.rows);
+				rows.sort(function (a, b) {
+					var x = a.cells[column].textContent;
+					var y = b.cells[column].textContent;
+					var order = type === "number" ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+					return ascending[column] ? order : -order;
+				});
+				for (var j = 0; j < rows.length; j++) {
+					body.appendChild(rows[j]);
+				}
+			};
+		})(i, headers[i].getAttribute("data-type")));
+	}
+})();
+</script>
+</body>
+</html>
+{{define "chart"}}<h2>{{.Title}}</h2>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{range .GridLines}}<line x1="{{$.PlotLeft}}" y1="{{printf "%.1f" .Y}}" x2="{{$.PlotRight}}" y2="{{printf "%.1f" .Y}}" stroke="#eee"/>
+<text x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" text-anchor="end" dominant-baseline="middle">{{.Text}}</text>
+{{end}}<line x1="{{.PlotLeft}}" y1="{{.PlotBottom}}" x2="{{.PlotRight}}" y2="{{.PlotBottom}}" stroke="#999"/>
+{{range .XLabels}}<text x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" text-anchor="middle">{{.Text}}</text>
+{{end}}{{range .Areas}}<polygon points="{{.Points}}" fill="{{.Color}}" fill-opacity="0.4" stroke="{{.Color}}"/>
+{{end}}{{range .Bars}}<rect x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" width="{{printf "%.1f" .Width}}" height="{{printf "%.1f" .Height}}" fill="{{.Color}}"><title>{{.Title}}</title></rect>
+{{end}}{{range .Lines}}<polyline points="{{.Points}}" fill="none" stroke="{{.Color}}" stroke-width="1.5"/>
+<text x="{{$.PlotRight}}" y="{{printf "%.1f" .LegendY}}" text-anchor="end" style="fill: {{.Color}}">{{.Name}}</text>
+{{end}}</svg>
+{{end}}`
@@ -0,0 +1,93 @@
+package backtest
+
+import (
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 策略 每只股票每个交易日回调一次OnBar
+type Strategy interface {
+	OnBar(ctx *BarContext)
+}
+
+// @func 单根k线的回调上下文 只能看到当前k线及之前的数据
+// 订单在该股票的下一根k线成交
+type BarContext struct {
+	engine *Engine
+	state  *symbolState
+
+	Stock      data_center.StockItem
+	KlineItems []data_center.KlineItem // 截止到当前k线
+	Index      int                     // 当前k线索引 即len(KlineItems)-1
+	Date       string                  // 当前k线日期
+}
+
+func (ctx *BarContext) placeOrder(order Order) int64 {
+	order.Id = ctx.engine.newOrderId(ctx.Date)
+	order.Stock = ctx.Stock
+	order.SignalDate = ctx.Date
+	ctx.state.pending = append(ctx.state.pending, order)
+	return order.Id
+}
+
+// @func 按股数买入
+// @return 订单id 同时也是成交后的批次id
+func (ctx *BarContext) Buy(quantity int64, order_type OrderType) int64 {
+	return ctx.placeOrder(Order{Side: OrderSide_Buy, Type: order_type, Quantity: quantity})
+}
+
+// @func 按金额买入 成交时按成交价折算股数
+// @value 单位元
+// @return 订单id 同时也是成交后的批次id
+func (ctx *BarContext) BuyValue(value float64, order_type OrderType) int64 {
+	return ctx.placeOrder(Order{Side: OrderSide_Buy, Type: order_type, Value: value})
+}
+
+// @func 开仓信号 当日全部信号按score从高到低占用空余仓位 金额由Config.Sizer决定
+// @score 信号强度
+// @value 没有配置仓位模型时的买入金额 单位元
+// @return 订单id 仓位已满时订单不会成交
+func (ctx *BarContext) Enter(score float64, value float64, order_type OrderType) int64 {
+	order := Order{Id: ctx.engine.newOrderId(ctx.Date), Stock: ctx.Stock, Side: OrderSide_Buy, Type: order_type, Value: value, Score: score, SignalDate: ctx.Date}
+	ctx.engine.day_entries = append(ctx.engine.day_entries, entryRequest{state: ctx.state, order: order, index: ctx.Index})
+	return order.Id
+}
+
+// @func 按股数卖出 先进先出
+func (ctx *BarContext) Sell(quantity int64, order_type OrderType, reason string) int64 {
+	return ctx.placeOrder(Order{Side: OrderSide_Sell, Type: order_type, Quantity: quantity, Reason: reason})
+}
+
+// @func 卖出指定批次的全部剩余股数
+// @lot_id 买入时返回的订单id
+func (ctx *BarContext) SellLot(lot_id int64, order_type OrderType, reason string) int64 {
+	return ctx.placeOrder(Order{Side: OrderSide_Sell, Type: order_type, LotId: lot_id, Reason: reason})
+}
+
+// @func 卖出全部持仓
+func (ctx *BarContext) SellAll(order_type OrderType, reason string) int64 {
+	return ctx.placeOrder(Order{Side: OrderSide_Sell, Type: order_type, Reason: reason})
+}
+
+// @func 当前股票持仓 没有持仓返回nil
+func (ctx *BarContext) Position() *Position {
+	position, ok := ctx.engine.positions[ctx.Stock.Symbol]
+	if !ok {
+		return nil
+	}
+	return position
+}
+
+// @func 当前股票还未成交的订单
+func (ctx *BarContext) PendingOrders() []Order {
+	return ctx.state.pending
+}
+
+// @func 可用现金 单位元
+func (ctx *BarContext) Cash() float64 {
+	return ctx.engine.cash
+}
+
+// @func 总资产 单位元 其他股票按最近收盘价计算
+func (ctx *BarContext) Equity() float64 {
+	return ctx.engine.equity()
+}
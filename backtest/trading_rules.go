@@ -0,0 +1,197 @@
+package backtest
+
+import (
+	"math"
+	"strings"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 交易费用
+type FeeSchedule struct {
+	CommissionRate  float64 // 佣金费率 买卖双向
+	MinCommission   float64 // 单笔最低佣金 单位元
+	StampDutyRate   float64 // 印花税 只在卖出时收取
+	TransferFeeRate float64 // 过户费 买卖双向
+}
+
+// @func 计算单笔交易费用
+// @side 买卖方向
+// @value 成交金额 单位元
+// @return 费用 单位元
+func (fee FeeSchedule) Calculate(side OrderSide, value float64) float64 {
+	if value <= 0 {
+		return 0.0
+	}
+
+	result := math.Max(value*fee.CommissionRate, fee.MinCommission)
+	result += value * fee.TransferFeeRate
+	if side == OrderSide_Sell {
+		result += value * fee.StampDutyRate
+	}
+
+	return result
+}
+
+// @func 交易规则
+type TradingRules struct {
+	Fees       FeeSchedule
+	LotSize    int64 // 买入股数必须是整数倍 卖出不限制
+	TPlusOne   bool  // 当日买入的股票次日才能卖出
+	PriceLimit bool  // 一字涨停无法买入 一字跌停无法卖出
+	Suspension bool  // 停牌(成交量为0)无法交易
+	PriceTick  int64 // 最小报价单位 单位毫 股票为分 场内基金为厘 0按分计算
+}
+
+// @func A股股票默认规则 佣金万2.5最低5元 印花税卖出万5 过户费万0.1
+func DefaultStockTradingRules() TradingRules {
+	return TradingRules{
+		Fees: FeeSchedule{
+			CommissionRate:  2.5 / 10000.0,
+			MinCommission:   5.0,
+			StampDutyRate:   5.0 / 10000.0,
+			TransferFeeRate: 0.1 / 10000.0,
+		},
+		LotSize:    100,
+		TPlusOne:   true,
+		PriceLimit: true,
+		Suspension: true,
+		PriceTick:  int64(kPriceUnit / 100),
+	}
+}
+
+// @func 场内基金默认规则 没有印花税和过户费 报价单位为厘
+func DefaultEtfTradingRules() TradingRules {
+	rules := DefaultStockTradingRules()
+	rules.Fees.StampDutyRate = 0.0
+	rules.Fees.TransferFeeRate = 0.0
+	rules.PriceTick = int64(kPriceUnit / 1000)
+	return rules
+}
+
+// @func 按整手向下取整
+func (rules TradingRules) RoundLot(quantity int64) int64 {
+	if rules.LotSize <= 1 {
+		return quantity
+	}
+	return quantity / rules.LotSize * rules.LotSize
+}
+
+// @func 资金能买入的最大股数 已扣除费用 按整手取整
+// @cash 可用资金 单位元
+// @price 成交价 单位毫
+func (rules TradingRules) AffordableQuantity(cash float64, price int64) int64 {
+	if cash <= 0 || price <= 0 {
+		return 0
+	}
+
+	price_yuan := PriceToYuan(price)
+	quantity := rules.RoundLot(int64(cash / (price_yuan * (1.0 + rules.Fees.CommissionRate + rules.Fees.TransferFeeRate))))
+	lot_size := int64(math.Max(float64(rules.LotSize), 1))
+	for quantity > 0 {
+		value := float64(quantity) * price_yuan
+		if value+rules.Fees.Calculate(OrderSide_Buy, value) <= cash {
+			break
+		}
+		quantity -= lot_size
+	}
+
+	return int64(math.Max(float64(quantity), 0))
+}
+
+// @func 是否可以买入
+// @kline_item 成交k线
+// @price 成交价 单位毫
+func (rules TradingRules) CanBuy(stock_item data_center.StockItem, kline_item data_center.KlineItem, price int64) bool {
+	if rules.Suspension && IsSuspended(kline_item) {
+		return false
+	}
+
+	if rules.PriceLimit {
+		limit_up, _ := CalculateLimitPrice(CalculatePreClose(kline_item), CalculatePriceLimitRate(stock_item), rules.PriceTick)
+		if price >= limit_up && kline_item.Low >= limit_up {
+			return false
+		}
+	}
+
+	return true
+}
+
+// @func 是否可以卖出 不含T+1判断
+// @kline_item 成交k线
+// @price 成交价 单位毫
+func (rules TradingRules) CanSell(stock_item data_center.StockItem, kline_item data_center.KlineItem, price int64) bool {
+	if rules.Suspension && IsSuspended(kline_item) {
+		return false
+	}
+
+	if rules.PriceLimit {
+		_, limit_down := CalculateLimitPrice(CalculatePreClose(kline_item), CalculatePriceLimitRate(stock_item), rules.PriceTick)
+		if price <= limit_down && kline_item.High <= limit_down {
+			return false
+		}
+	}
+
+	return true
+}
+
+// @func 是否停牌
+func IsSuspended(kline_item data_center.KlineItem) bool {
+	return kline_item.Volume <= 0
+}
+
+// @func 是否场内基金 沪市5开头 深市15 16 18开头
+func IsFund(stock_item data_center.StockItem) bool {
+	symbol := strings.ToUpper(stock_item.Symbol)
+	return strings.HasPrefix(symbol, "SH5") || strings.HasPrefix(symbol, "SZ15") || strings.HasPrefix(symbol, "SZ16") || strings.HasPrefix(symbol, "SZ18")
+}
+
+// @func 涨跌幅限制 场内基金跟随标的板块 跟踪创业板 科创板指数的为20% 其余10%
+// @return 0.1表示10%
+func CalculatePriceLimitRate(stock_item data_center.StockItem) float64 {
+	if IsFund(stock_item) {
+		// 科创板ETF都是588开头 创业板ETF没有单独的号段 按名称判断 双创同时跟踪两个板块
+		symbol := strings.ToUpper(stock_item.Symbol)
+		if strings.HasPrefix(symbol, "SH588") || strings.Contains(stock_item.Name, "科创") || strings.Contains(stock_item.Name, "创业") || strings.Contains(stock_item.Name, "双创") {
+			return 0.2
+		}
+		return 0.1
+	}
+
+	if strings.Contains(strings.ToUpper(stock_item.Name), "ST") {
+		return 0.05
+	}
+
+	symbol := strings.ToUpper(stock_item.Symbol)
+	if strings.HasPrefix(symbol, "SZ30") || strings.HasPrefix(symbol, "SH688") || strings.HasPrefix(symbol, "SH689") {
+		return 0.2
+	}
+	if strings.HasPrefix(symbol, "BJ") {
+		return 0.3
+	}
+
+	return 0.1
+}
+
+// @func 根据涨跌幅反推前收盘价 除权日也能得到正确的前收盘价
+// @return 单位毫
+func CalculatePreClose(kline_item data_center.KlineItem) int64 {
+	return int64(math.Round(float64(kline_item.Close) / (1.0 + kline_item.Percent/100.0)))
+}
+
+// @func 计算涨跌停价 四舍五入到最小报价单位
+// @pre_close 前收盘价 单位毫
+// @rate 涨跌幅限制 0.1表示10%
+// @tick 最小报价单位 单位毫 0按分计算
+// @return 涨停价 跌停价 单位毫
+func CalculateLimitPrice(pre_close int64, rate float64, tick int64) (int64, int64) {
+	if tick <= 0 {
+		tick = int64(kPriceUnit / 100)
+	}
+	tick_float := float64(tick)
+
+	limit_up := int64(math.Round(float64(pre_close)*(1.0+rate)/tick_float) * tick_float)
+	limit_down := int64(math.Round(float64(pre_close)*(1.0-rate)/tick_float) * tick_float)
+
+	return limit_up, limit_down
+}
@@ -0,0 +1,126 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+func TestCalculatePriceLimitRate(t *testing.T) {
+	cases := []struct {
+		symbol string
+		name   string
+		want   float64
+	}{
+		{"SH600000", "浦发银行", 0.1},
+		{"SZ000001", "平安银行", 0.1},
+		{"SH600001", "ST股票", 0.05},
+		{"SZ000002", "*st股票", 0.05},
+		{"SZ300750", "宁德时代", 0.2},
+		{"SH688981", "中芯国际", 0.2},
+		{"SH689009", "九号公司", 0.2},
+		{"BJ430047", "诺思兰德", 0.3},
+		{"SH510300", "沪深300ETF", 0.1},
+		{"SH588000", "科创50ETF", 0.2},
+		{"SH588080", "ETF", 0.2},
+		{"SZ159915", "创业板ETF", 0.2},
+		{"SZ159781", "双创50ETF", 0.2},
+		{"SZ159919", "沪深300ETF", 0.1},
+		{"SZ161725", "白酒LOF", 0.1},
+	}
+
+	for _, c := range cases {
+		got := CalculatePriceLimitRate(data_center.StockItem{Symbol: c.symbol, Name: c.name})
+		if got != c.want {
+			t.Errorf("%s(%s): got %v want %v", c.name, c.symbol, got, c.want)
+		}
+	}
+}
+
+func TestCalculateLimitPrice(t *testing.T) {
+	cases := []struct {
+		name      string
+		pre_close int64
+		rate      float64
+		tick      int64
+		up        int64
+		down      int64
+	}{
+		{"股票四舍五入到分", 21230, 0.1, 100, 23400, 19100},
+		{"基金四舍五入到厘", 21230, 0.1, 10, 23350, 19110},
+		{"报价单位为0按分", 21230, 0.1, 0, 23400, 19100},
+		{"整数价格", 100000, 0.1, 100, 110000, 90000},
+		{"20%", 100000, 0.2, 100, 120000, 80000},
+		{"ST", 33300, 0.05, 100, 35000, 31600},
+	}
+
+	for _, c := range cases {
+		up, down := CalculateLimitPrice(c.pre_close, c.rate, c.tick)
+		if up != c.up || down != c.down {
+			t.Errorf("%s: got %d %d want %d %d", c.name, up, down, c.up, c.down)
+		}
+	}
+}
+
+// 前收盘价10元的日k线
+func newLimitKlineItem(open int64, high int64, low int64, close int64, volume uint64) data_center.KlineItem {
+	return data_center.KlineItem{Date: "20240102", Open: open, High: high, Low: low, Close: close, Volume: volume, Percent: (float64(close)/100000.0 - 1.0) * 100.0}
+}
+
+func TestCanBuyCanSell(t *testing.T) {
+	stock_item := data_center.StockItem{Symbol: "SH600000", Name: "浦发银行"}
+	cases := []struct {
+		name     string
+		kline    data_center.KlineItem
+		price    int64
+		rules    TradingRules
+		can_buy  bool
+		can_sell bool
+	}{
+		{"正常", newLimitKlineItem(100000, 105000, 98000, 102000, 100), 100000, DefaultStockTradingRules(), true, true},
+		{"一字涨停", newLimitKlineItem(110000, 110000, 110000, 110000, 100), 110000, DefaultStockTradingRules(), false, true},
+		{"涨停打开", newLimitKlineItem(110000, 110000, 105000, 110000, 100), 110000, DefaultStockTradingRules(), true, true},
+		{"一字跌停", newLimitKlineItem(90000, 90000, 90000, 90000, 100), 90000, DefaultStockTradingRules(), true, false},
+		{"跌停打开", newLimitKlineItem(90000, 95000, 90000, 90000, 100), 90000, DefaultStockTradingRules(), true, true},
+		{"停牌", newLimitKlineItem(100000, 100000, 100000, 100000, 0), 100000, DefaultStockTradingRules(), false, false},
+		{"不限制涨跌停", newLimitKlineItem(110000, 110000, 110000, 110000, 100), 110000, TradingRules{Suspension: true}, true, true},
+		{"不限制停牌", newLimitKlineItem(100000, 100000, 100000, 100000, 0), 100000, TradingRules{PriceLimit: true}, true, true},
+	}
+
+	for _, c := range cases {
+		if got := c.rules.CanBuy(stock_item, c.kline, c.price); got != c.can_buy {
+			t.Errorf("%s: CanBuy got %v want %v", c.name, got, c.can_buy)
+		}
+		if got := c.rules.CanSell(stock_item, c.kline, c.price); got != c.can_sell {
+			t.Errorf("%s: CanSell got %v want %v", c.name, got, c.can_sell)
+		}
+	}
+}
+
+func TestFeeScheduleCalculate(t *testing.T) {
+	stock_fees := DefaultStockTradingRules().Fees
+	etf_fees := DefaultEtfTradingRules().Fees
+	cases := []struct {
+		name  string
+		fees  FeeSchedule
+		side  OrderSide
+		value float64
+		want  float64
+	}{
+		{"股票买入最低佣金", stock_fees, OrderSide_Buy, 10000, 5.0 + 0.1},
+		{"股票卖出最低佣金加印花税", stock_fees, OrderSide_Sell, 10000, 5.0 + 0.1 + 5.0},
+		{"股票买入", stock_fees, OrderSide_Buy, 100000, 25.0 + 1.0},
+		{"股票卖出", stock_fees, OrderSide_Sell, 100000, 25.0 + 1.0 + 50.0},
+		{"基金买入最低佣金", etf_fees, OrderSide_Buy, 10000, 5.0},
+		{"基金卖出没有印花税", etf_fees, OrderSide_Sell, 100000, 25.0},
+		{"成交金额为0", stock_fees, OrderSide_Sell, 0, 0.0},
+	}
+
+	for _, c := range cases {
+		got := c.fees.Calculate(c.side, c.value)
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: got %f want %f", c.name, got, c.want)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -123,11 +124,14 @@ func getKlineItemsGetOrSet(key string, cache *string, read bool) {
 // @symbol 股票代号 sz399001 sh000001
 // @count 数据总量
 // @return 按照时间顺序返回每个k线图
+var kline_items_mutex sync.Mutex // 并行回测时保护缓存
 var kline_items_map = make(map[string][]KlineItem)
 
 func GetKlineItems(kline_type KlineType, stock_item StockItem, count uint64) []KlineItem {
 	key := fmt.Sprintf("%s_%s_%d_%d", time.Now().Local().Format("20060102"), stock_item.Sid, kline_type, count)
+	kline_items_mutex.Lock()
 	kline_items, ok := kline_items_map[key]
+	kline_items_mutex.Unlock()
 	if ok {
 		return kline_items
 	}
@@ -182,8 +186,15 @@ func GetKlineItems(kline_type KlineType, stock_item StockItem, count uint64) []K
 		}
 
 		var item KlineItem
+		// 日k线为 20240523 分钟k线带上时分 如 202405230935 前8位都是日期
 		item.Date = fmt.Sprintf("%d", iter.NTime)
-		date, _ := time.ParseInLocation("20060102", item.Date, time.Local)
+		layout := "20060102"
+		if len(item.Date) == 12 {
+			layout = "200601021504"
+		} else if len(item.Date) == 14 {
+			layout = "20060102150405"
+		}
+		date, _ := time.ParseInLocation(layout, item.Date, time.Local)
 		item.Timestamp = date.Unix()
 
 		if len(item.Date) < 8 {
@@ -211,11 +222,26 @@ func GetKlineItems(kline_type KlineType, stock_item StockItem, count uint64) []K
 
 	RSI(result)
 
+	kline_items_mutex.Lock()
 	kline_items_map[key] = result
+	kline_items_mutex.Unlock()
 
 	return result
 }
 
+// @func 释放股票的全部k线缓存 全市场回测处理完一只股票后调用 避免内存一直增长
+func ReleaseKlineItems(stock_item StockItem) {
+	kline_items_mutex.Lock()
+	defer kline_items_mutex.Unlock()
+
+	for key := range kline_items_map {
+		fields := strings.Split(key, "_")
+		if len(fields) >= 2 && fields[1] == stock_item.Sid {
+			delete(kline_items_map, key)
+		}
+	}
+}
+
 func RSI(kline_items []KlineItem) {
 	rsi6_red, rsi6_all := 1e-6, 1e-6
 	for index := 0; index < len(kline_items); index++ {
@@ -267,12 +293,15 @@ func getWholeStockItemsCacheGetOrSet(key string, cache *string, read bool) {
 	}
 }
 
+var stock_items_mutex sync.Mutex // 并行回测时保护缓存
 var stock_items_map = make(map[string][]StockItem)
 
 func getStockItems(cat uint64) []StockItem {
 	key := fmt.Sprintf("%s_%d", time.Now().Local().Format("20060102"), cat)
 
+	stock_items_mutex.Lock()
 	stock_items, ok := stock_items_map[key]
+	stock_items_mutex.Unlock()
 	if ok {
 		return stock_items
 	}
@@ -330,7 +359,9 @@ func getStockItems(cat uint64) []StockItem {
 		}
 	}
 
+	stock_items_mutex.Lock()
 	stock_items_map[key] = result
+	stock_items_mutex.Unlock()
 
 	fmt.Printf("GetWholeStockItems, Total %d\n", len(result))
 
@@ -357,7 +388,9 @@ func getIndexStockItemsCacheGetOrSet(key string, cache *string, read bool) {
 func getIndexStockItems(cat uint64) []StockItem {
 	key := fmt.Sprintf("%s_%d", time.Now().Local().Format("20060102"), cat)
 
+	stock_items_mutex.Lock()
 	stock_items, ok := stock_items_map[key]
+	stock_items_mutex.Unlock()
 	if ok {
 		return stock_items
 	}
@@ -415,7 +448,9 @@ func getIndexStockItems(cat uint64) []StockItem {
 		}
 	}
 
+	stock_items_mutex.Lock()
 	stock_items_map[key] = result
+	stock_items_mutex.Unlock()
 
 	fmt.Printf("GetIndexStockItems, Total %d\n", len(result))
 
@@ -518,11 +553,14 @@ func getLhbStockItemsGetOrSet(key string, cache *string, read bool) {
 
 // @func 获取某一天的龙虎榜 20240531
 // @return LhbStockItem 返回当天的龙虎榜数据
+var lhb_stock_items_mutex sync.Mutex // 并行回测时保护缓存
 var lhb_stock_items_map = make(map[string][]LhbStockItem)
 
 func GetLhbStockItems(date string) []LhbStockItem {
 	key := date
+	lhb_stock_items_mutex.Lock()
 	lhb_stock_items, ok := lhb_stock_items_map[key]
+	lhb_stock_items_mutex.Unlock()
 	if ok {
 		return lhb_stock_items
 	}
@@ -622,7 +660,9 @@ func GetLhbStockItems(date string) []LhbStockItem {
 		result = append(result, item)
 	}
 
+	lhb_stock_items_mutex.Lock()
 	lhb_stock_items_map[key] = result
+	lhb_stock_items_mutex.Unlock()
 
 	return result
 }
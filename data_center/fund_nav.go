@@ -0,0 +1,146 @@
+package data_center
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FundNavItem struct {
+	Date           string  // 净值日期 20240523
+	Nav            int64   // 单位净值 单位毫
+	AccumulatedNav int64   // 累计净值 单位毫
+	Percent        float64 // 日增长率 已经乘了100
+}
+
+type EastmoneyLsjz struct {
+	Data       EastmoneyLsjzData `json:"Data"`
+	ErrCode    int64             `json:"ErrCode"`
+	ErrMsg     string            `json:"ErrMsg"`
+	TotalCount uint64            `json:"TotalCount"`
+	PageSize   uint64            `json:"PageSize"`
+	PageIndex  uint64            `json:"PageIndex"`
+}
+
+type EastmoneyLsjzData struct {
+	LSJZList []EastmoneyLsjzItem `json:"LSJZList"`
+}
+
+type EastmoneyLsjzItem struct {
+	FSRQ  string `json:"FSRQ"`  // 净值日期 2024-05-23
+	DWJZ  string `json:"DWJZ"`  // 单位净值
+	LJJZ  string `json:"LJJZ"`  // 累计净值
+	JZZZL string `json:"JZZZL"` // 日增长率
+}
+
+// @func 读写基金净值数据缓存
+// @index 缓存文件索引
+// @cache 待缓存的数据或者读取的缓存
+// @read true=读 false=写
+func getFundNavItemsGetOrSet(key string, cache *string, read bool) {
+	work_dir, _ := os.Getwd()
+	cache_path := fmt.Sprintf("%s\\%s\\%s.cache", work_dir, "data_center\\cache\\GetFundNavItems", key)
+
+	if read {
+		file, err := os.ReadFile(cache_path)
+		if err != nil {
+			return
+		}
+		*cache = string(file)
+	} else {
+		cache_byte := []byte(*cache)
+		os.WriteFile(cache_path, cache_byte, 0)
+	}
+}
+
+// @func 获取场内基金的历史净值 ETF LOF
+// @stock_item 基金 代号去掉市场前缀就是基金代码
+// @count 最多获取的净值数
+// @return 按照时间顺序返回每天的净值
+var fund_nav_items_mutex sync.Mutex // 并行分析时保护缓存
+var fund_nav_items_map = make(map[string][]FundNavItem)
+
+func GetFundNavItems(stock_item StockItem, count uint64) []FundNavItem {
+	const kPageSize uint64 = 20 // 接口每页最多20条
+
+	key := fmt.Sprintf("%s_%s_%d", time.Now().Local().Format("20060102"), stock_item.Symbol, count)
+	fund_nav_items_mutex.Lock()
+	fund_nav_items, ok := fund_nav_items_map[key]
+	fund_nav_items_mutex.Unlock()
+	if ok {
+		return fund_nav_items
+	}
+
+	result := make([]FundNavItem, 0)
+	if len(stock_item.Symbol) <= 2 {
+		return result
+	}
+	fund_code := stock_item.Symbol[2:]
+
+	for page := uint64(1); (page-1)*kPageSize < count; page++ {
+		file_key := fmt.Sprintf("%s_%d", key, page)
+
+		var body_string string
+		getFundNavItemsGetOrSet(file_key, &body_string, true)
+		if len(body_string) <= 0 {
+			format_string := "https://api.fund.eastmoney.com/f10/lsjz?fundCode=%s&pageIndex=%d&pageSize=%d&startDate=&endDate="
+			url := fmt.Sprintf(format_string, fund_code, page, kPageSize)
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			// 接口校验来源
+			req.Header.Add("Referer", "https://fundf10.eastmoney.com/")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			body_string = string(body)
+			getFundNavItemsGetOrSet(file_key, &body_string, false)
+		}
+
+		var lsjz EastmoneyLsjz
+		json.Unmarshal([]byte(body_string), &lsjz)
+		for _, iter := range lsjz.Data.LSJZList {
+			nav, err := strconv.ParseFloat(iter.DWJZ, 64)
+			if err != nil || nav <= 0 {
+				continue
+			}
+
+			var item FundNavItem
+			item.Date = strings.ReplaceAll(iter.FSRQ, "-", "")
+			item.Nav = int64(math.Round(nav * 10000))
+			accumulated_nav, _ := strconv.ParseFloat(iter.LJJZ, 64)
+			item.AccumulatedNav = int64(math.Round(accumulated_nav * 10000))
+			item.Percent, _ = strconv.ParseFloat(iter.JZZZL, 64)
+			result = append(result, item)
+		}
+
+		if uint64(len(lsjz.Data.LSJZList)) < kPageSize {
+			break
+		}
+	}
+
+	// 接口从新到旧 转换为时间顺序
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date < result[j].Date
+	})
+	if uint64(len(result)) > count {
+		result = result[uint64(len(result))-count:]
+	}
+
+	fund_nav_items_mutex.Lock()
+	fund_nav_items_map[key] = result
+	fund_nav_items_mutex.Unlock()
+
+	return result
+}
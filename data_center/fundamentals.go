@@ -0,0 +1,215 @@
+package data_center
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FundamentalItem struct {
+	ReportDate string // 报告期 20240331
+	NoticeDate string // 公告日期 三张表中最晚的一个 之后才能使用
+
+	Revenue           int64 // 营业总收入 单位毫
+	NetProfit         int64 // 归母净利润 单位毫
+	OperatingCashFlow int64 // 经营活动现金流量净额 单位毫
+	TotalAssets       int64 // 总资产 单位毫
+	TotalLiabilities  int64 // 总负债 单位毫
+
+	Roe           float64 // 加权净资产收益率 已经乘了100
+	GrossMargin   float64 // 销售毛利率 已经乘了100
+	RevenueGrowth float64 // 营业总收入同比增长 已经乘了100
+	ProfitGrowth  float64 // 归母净利润同比增长 已经乘了100
+	DebtRatio     float64 // 资产负债率 已经乘了100
+}
+
+type EastmoneyFinanceReport struct {
+	Result  EastmoneyFinanceReportResult `json:"result"`
+	Success bool                         `json:"success"`
+	Message string                       `json:"message"`
+}
+
+type EastmoneyFinanceReportResult struct {
+	Pages int64                  `json:"pages"`
+	Data  []EastmoneyFinanceItem `json:"data"`
+}
+
+// 业绩报表 资产负债表 现金流量表共用 没有的字段为0
+type EastmoneyFinanceItem struct {
+	NOTICE_DATE          string  `json:"NOTICE_DATE"`          // 公告日期 2024-04-03 00:00:00
+	REPORTDATE           string  `json:"REPORTDATE"`           // 业绩报表的报告期
+	REPORT_DATE          string  `json:"REPORT_DATE"`          // 资产负债表和现金流量表的报告期
+	TOTAL_OPERATE_INCOME float64 `json:"TOTAL_OPERATE_INCOME"` // 营业总收入 单位元
+	PARENT_NETPROFIT     float64 `json:"PARENT_NETPROFIT"`     // 归母净利润 单位元
+	WEIGHTAVG_ROE        float64 `json:"WEIGHTAVG_ROE"`        // 加权净资产收益率
+	XSMLL                float64 `json:"XSMLL"`                // 销售毛利率
+	YSTZ                 float64 `json:"YSTZ"`                 // 营业总收入同比增长
+	SJLTZ                float64 `json:"SJLTZ"`                // 归母净利润同比增长
+	TOTAL_ASSETS         float64 `json:"TOTAL_ASSETS"`         // 总资产 单位元
+	TOTAL_LIABILITIES    float64 `json:"TOTAL_LIABILITIES"`    // 总负债 单位元
+	NETCASH_OPERATE      float64 `json:"NETCASH_OPERATE"`      // 经营活动现金流量净额 单位元
+}
+
+// @func 读写财务数据缓存
+// @index 缓存文件索引
+// @cache 待缓存的数据或者读取的缓存
+// @read true=读 false=写
+func getFundamentalItemsGetOrSet(key string, cache *string, read bool) {
+	work_dir, _ := os.Getwd()
+	cache_path := fmt.Sprintf("%s\\%s\\%s.cache", work_dir, "data_center\\cache\\GetFundamentalItems", key)
+
+	if read {
+		file, err := os.ReadFile(cache_path)
+		if err != nil {
+			return
+		}
+		*cache = string(file)
+	} else {
+		cache_byte := []byte(*cache)
+		os.WriteFile(cache_path, cache_byte, 0)
+	}
+}
+
+// @func 从东方财富数据中心获取一只股票的一张报表 最多200个报告期
+// @report_name RPT_LICO_FN_CPD业绩报表 RPT_DMSK_FN_BALANCE资产负债表 RPT_DMSK_FN_CASHFLOW现金流量表
+func getEastmoneyFinanceItems(code string, report_name string) []EastmoneyFinanceItem {
+	key := fmt.Sprintf("%s_%s_%s", time.Now().Local().Format("20060102"), code, report_name)
+
+	var body_string string
+	getFundamentalItemsGetOrSet(key, &body_string, true)
+	if len(body_string) <= 0 {
+		format_string := "https://datacenter-web.eastmoney.com/api/data/v1/get?sortColumns=NOTICE_DATE&sortTypes=-1&pageSize=200&pageNumber=1&reportName=%s&columns=ALL&filter=%s"
+		filter := url.QueryEscape(fmt.Sprintf("(SECURITY_CODE=\"%s\")", code))
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf(format_string, report_name, filter), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		body_string = string(body)
+		getFundamentalItemsGetOrSet(key, &body_string, false)
+	}
+
+	var report EastmoneyFinanceReport
+	json.Unmarshal([]byte(body_string), &report)
+	return report.Result.Data
+}
+
+// @func 东方财富的日期 2024-04-03 00:00:00 转换为 20240403
+func eastmoneyDate(text string) string {
+	if len(text) < 10 {
+		return ""
+	}
+	return strings.ReplaceAll(text[0:10], "-", "")
+}
+
+// @func 获取股票每个报告期的财务数据 按公告日期对齐 避免回测使用未来数据
+// @stock_item 股票 代号去掉市场前缀就是证券代码
+// @return 按照公告日期顺序返回 公告日期相同时按报告期
+var fundamental_items_mutex sync.Mutex // 并行分析时保护缓存
+var fundamental_items_map = make(map[string][]FundamentalItem)
+
+func GetFundamentalItems(stock_item StockItem) []FundamentalItem {
+	key := fmt.Sprintf("%s_%s", time.Now().Local().Format("20060102"), stock_item.Symbol)
+	fundamental_items_mutex.Lock()
+	fundamental_items, ok := fundamental_items_map[key]
+	fundamental_items_mutex.Unlock()
+	if ok {
+		return fundamental_items
+	}
+
+	result := make([]FundamentalItem, 0)
+	if len(stock_item.Symbol) <= 2 {
+		return result
+	}
+	code := stock_item.Symbol[2:]
+
+	// 按报告期合并三张表 公告日期取最晚的
+	report_map := make(map[string]*FundamentalItem)
+	getItem := func(report_date string, notice_date string) *FundamentalItem {
+		item, ok := report_map[report_date]
+		if !ok {
+			item = &FundamentalItem{ReportDate: report_date}
+			report_map[report_date] = item
+		}
+		if notice_date > item.NoticeDate {
+			item.NoticeDate = notice_date
+		}
+		return item
+	}
+
+	for _, iter := range getEastmoneyFinanceItems(code, "RPT_LICO_FN_CPD") {
+		report_date, notice_date := eastmoneyDate(iter.REPORTDATE), eastmoneyDate(iter.NOTICE_DATE)
+		if len(report_date) <= 0 || len(notice_date) <= 0 {
+			continue
+		}
+		item := getItem(report_date, notice_date)
+		item.Revenue = int64(iter.TOTAL_OPERATE_INCOME * 10000)
+		item.NetProfit = int64(iter.PARENT_NETPROFIT * 10000)
+		item.Roe = iter.WEIGHTAVG_ROE
+		item.GrossMargin = iter.XSMLL
+		item.RevenueGrowth = iter.YSTZ
+		item.ProfitGrowth = iter.SJLTZ
+	}
+	for _, iter := range getEastmoneyFinanceItems(code, "RPT_DMSK_FN_BALANCE") {
+		report_date, notice_date := eastmoneyDate(iter.REPORT_DATE), eastmoneyDate(iter.NOTICE_DATE)
+		if len(report_date) <= 0 || len(notice_date) <= 0 {
+			continue
+		}
+		item := getItem(report_date, notice_date)
+		item.TotalAssets = int64(iter.TOTAL_ASSETS * 10000)
+		item.TotalLiabilities = int64(iter.TOTAL_LIABILITIES * 10000)
+		if iter.TOTAL_ASSETS > 0 {
+			item.DebtRatio = iter.TOTAL_LIABILITIES / iter.TOTAL_ASSETS * 100.0
+		}
+	}
+	for _, iter := range getEastmoneyFinanceItems(code, "RPT_DMSK_FN_CASHFLOW") {
+		report_date, notice_date := eastmoneyDate(iter.REPORT_DATE), eastmoneyDate(iter.NOTICE_DATE)
+		if len(report_date) <= 0 || len(notice_date) <= 0 {
+			continue
+		}
+		getItem(report_date, notice_date).OperatingCashFlow = int64(iter.NETCASH_OPERATE * 10000)
+	}
+
+	for _, item := range report_map {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].NoticeDate != result[j].NoticeDate {
+			return result[i].NoticeDate < result[j].NoticeDate
+		}
+		return result[i].ReportDate < result[j].ReportDate
+	})
+
+	fundamental_items_mutex.Lock()
+	fundamental_items_map[key] = result
+	fundamental_items_mutex.Unlock()
+
+	return result
+}
+
+// @func 获取某天可以使用的最新财务数据 公告日当天盘后才公布 只使用date之前公布的
+// @fundamental_items GetFundamentalItems的结果
+// @date 20240523
+// @return 已公布的报告期中最新的一期 没有时返回false
+func FindFundamentalItem(fundamental_items []FundamentalItem, date string) (FundamentalItem, bool) {
+	found := -1
+	for i := 0; i < len(fundamental_items) && fundamental_items[i].NoticeDate < date; i++ {
+		if found < 0 || fundamental_items[i].ReportDate >= fundamental_items[found].ReportDate {
+			found = i
+		}
+	}
+	if found < 0 {
+		return FundamentalItem{}, false
+	}
+	return fundamental_items[found], true
+}
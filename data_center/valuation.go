@@ -0,0 +1,196 @@
+package data_center
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ValuationItem struct {
+	Date          string  // 日期 20240523
+	PeTtm         float64 // 滚动市盈率 0表示没有数据
+	Pb            float64 // 市净率 0表示没有数据
+	DividendYield float64 // 股息率 已经乘了100 0表示没有数据
+}
+
+type DanjuanIndexEva struct {
+	Data       DanjuanIndexEvaData `json:"data"`
+	ResultCode int64               `json:"result_code"`
+}
+
+type DanjuanIndexEvaData struct {
+	IndexCode string                    `json:"index_code"`
+	PeGrowths []DanjuanIndexEvaPeGrowth `json:"index_eva_pe_growths"`
+	PbGrowths []DanjuanIndexEvaPbGrowth `json:"index_eva_pb_growths"`
+}
+
+type DanjuanIndexEvaPeGrowth struct {
+	Pe float64 `json:"pe"`
+	Ts int64   `json:"ts"` // 毫秒时间戳
+}
+
+type DanjuanIndexEvaPbGrowth struct {
+	Pb float64 `json:"pb"`
+	Ts int64   `json:"ts"` // 毫秒时间戳
+}
+
+// @func 读写指数估值数据缓存
+// @index 缓存文件索引
+// @cache 待缓存的数据或者读取的缓存
+// @read true=读 false=写
+func getValuationItemsGetOrSet(key string, cache *string, read bool) {
+	work_dir, _ := os.Getwd()
+	cache_path := fmt.Sprintf("%s\\%s\\%s.cache", work_dir, "data_center\\cache\\GetValuationItems", key)
+
+	if read {
+		file, err := os.ReadFile(cache_path)
+		if err != nil {
+			return
+		}
+		*cache = string(file)
+	} else {
+		cache_byte := []byte(*cache)
+		os.WriteFile(cache_path, cache_byte, 0)
+	}
+}
+
+// @func 从蛋卷基金获取指数的估值历史
+// @eva_type pe_history pb_history
+func getDanjuanIndexEva(symbol string, eva_type string) DanjuanIndexEva {
+	key := fmt.Sprintf("%s_%s_%s", time.Now().Local().Format("20060102"), symbol, eva_type)
+
+	var body_string string
+	getValuationItemsGetOrSet(key, &body_string, true)
+	if len(body_string) <= 0 {
+		url := fmt.Sprintf("https://danjuanfunds.com/djapi/index_eva/%s/%s?day=all", eva_type, symbol)
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		// 默认的User-Agent会被拒绝
+		req.Header.Add("User-Agent", "Mozilla/5.0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			return DanjuanIndexEva{}
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		body_string = string(body)
+		getValuationItemsGetOrSet(key, &body_string, false)
+	}
+
+	var index_eva DanjuanIndexEva
+	json.Unmarshal([]byte(body_string), &index_eva)
+	return index_eva
+}
+
+// @func 读取本地的估值文件 用于补充或者覆盖接口数据 如行业板块和接口没有的股息率
+// 文件为 data_center\valuation\代号.csv 第一行为表头 每行 日期,滚动市盈率,市净率,股息率 空表示不覆盖
+func loadLocalValuationItems(symbol string) []ValuationItem {
+	work_dir, _ := os.Getwd()
+	file_path := fmt.Sprintf("%s\\%s\\%s.csv", work_dir, "data_center\\valuation", strings.ToUpper(symbol))
+
+	file, err := os.Open(file_path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	result := make([]ValuationItem, 0, len(records))
+	for index, record := range records {
+		if index == 0 || len(record) < 1 {
+			continue
+		}
+		var item ValuationItem
+		item.Date = strings.ReplaceAll(strings.TrimSpace(record[0]), "-", "")
+		values := []*float64{&item.PeTtm, &item.Pb, &item.DividendYield}
+		for i, value := range values {
+			if i+1 < len(record) {
+				*value, _ = strconv.ParseFloat(strings.TrimSpace(record[i+1]), 64)
+			}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// @func 获取指数或板块的估值历史 滚动市盈率和市净率来自蛋卷基金 只覆盖主要指数 本地估值文件优先
+// 接口没有股息率和行业板块 这两项只来自本地估值文件 没有文件时股息率为0 行业板块返回空
+// @stock_item 指数或板块
+// @return 按照时间顺序返回每天的估值
+var valuation_items_mutex sync.Mutex // 并行分析时保护缓存
+var valuation_items_map = make(map[string][]ValuationItem)
+
+func GetValuationItems(stock_item StockItem) []ValuationItem {
+	symbol := strings.ToUpper(stock_item.Symbol)
+	key := fmt.Sprintf("%s_%s", time.Now().Local().Format("20060102"), symbol)
+	valuation_items_mutex.Lock()
+	valuation_items, ok := valuation_items_map[key]
+	valuation_items_mutex.Unlock()
+	if ok {
+		return valuation_items
+	}
+
+	date_map := make(map[string]*ValuationItem)
+	getItem := func(date string) *ValuationItem {
+		item, ok := date_map[date]
+		if !ok {
+			item = &ValuationItem{Date: date}
+			date_map[date] = item
+		}
+		return item
+	}
+	timestampToDate := func(ts int64) string {
+		return time.UnixMilli(ts).Local().Format("20060102")
+	}
+
+	for _, iter := range getDanjuanIndexEva(symbol, "pe_history").Data.PeGrowths {
+		getItem(timestampToDate(iter.Ts)).PeTtm = iter.Pe
+	}
+	for _, iter := range getDanjuanIndexEva(symbol, "pb_history").Data.PbGrowths {
+		getItem(timestampToDate(iter.Ts)).Pb = iter.Pb
+	}
+	for _, iter := range loadLocalValuationItems(symbol) {
+		if len(iter.Date) != 8 {
+			continue
+		}
+		item := getItem(iter.Date)
+		if iter.PeTtm != 0 {
+			item.PeTtm = iter.PeTtm
+		}
+		if iter.Pb != 0 {
+			item.Pb = iter.Pb
+		}
+		if iter.DividendYield != 0 {
+			item.DividendYield = iter.DividendYield
+		}
+	}
+
+	result := make([]ValuationItem, 0, len(date_map))
+	for _, item := range date_map {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date < result[j].Date
+	})
+
+	valuation_items_mutex.Lock()
+	valuation_items_map[key] = result
+	valuation_items_mutex.Unlock()
+
+	return result
+}
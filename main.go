@@ -10,6 +10,13 @@ import (
 )
 
 var func_name = flag.String("f", "StartBacktesting", "运行的函数")
+var expression_text = flag.String("e", "", "条件表达式 用于StartSelectStock和StartBacktesting 如 close > ma(20) and rsi(6) < 30")
+var expression_file = flag.String("ef", "", "条件表达式文件 内容同-e")
+var benchmark_text = flag.String("b", "SH000300", "回测对比的基准或定投标的 指数 板块 ETF LOF的代号或名称 如 SH000300 中证500")
+var workers = flag.Int("w", 0, "并行回测的协程数 0表示CPU核数 结果与协程数无关")
+var output_dir = flag.String("o", "", "回测结果导出目录 每次运行一个子目录 包含交易 资金曲线 运行清单的CSV和JSON 默认不导出")
+var etf_index_mapping_file = flag.String("im", "etf_index_mapping.csv", "ETF跟踪指数的映射文件 每行 ETF代号,指数代号或名称 用于StartEtfTrackingAnalysis")
+var optimize_metric = flag.String("m", "sharpe", "参数优化的排序指标 sharpe sortino cagr calmar total_return profit_factor expectancy_rate win_rate max_drawdown")
 
 func main() {
 	if len(os.Args) <= 1 {
@@ -18,21 +25,62 @@ func main() {
 	}
 	flag.Parse()
 
+	if len(*expression_file) > 0 {
+		file, err := os.ReadFile(*expression_file)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		*expression_text = string(file)
+	}
+
+	technical_analysis.SetBenchmark(*benchmark_text)
+	technical_analysis.SetBacktestWorkers(*workers)
+	technical_analysis.SetOptimizeMetric(*optimize_metric)
+	technical_analysis.SetOutputDir(*output_dir)
+	technical_analysis.SetEtfIndexMappingFile(*etf_index_mapping_file)
+
 	start := time.Now().Local().Unix()
-	if *func_name == "StartBacktesting" {
+	if *func_name == "StartBacktesting" && len(*expression_text) > 0 {
+		technical_analysis.StartBacktestingWithExpression(*expression_text)
+	} else if *func_name == "StartBacktesting" {
 		technical_analysis.StartBacktesting()
+	} else if *func_name == "StartEngineBacktesting" {
+		technical_analysis.StartEngineBacktesting()
+	} else if *func_name == "StartPortfolioBacktesting" {
+		technical_analysis.StartPortfolioBacktesting()
+	} else if *func_name == "StartExitRulesBacktesting" {
+		technical_analysis.StartExitRulesBacktesting()
+	} else if *func_name == "StartMonteCarloBacktesting" {
+		technical_analysis.StartMonteCarloBacktesting()
+	} else if *func_name == "StartOptimizeBacktesting" {
+		technical_analysis.StartOptimizeBacktesting()
+	} else if *func_name == "StartSelectStock" && len(*expression_text) > 0 {
+		technical_analysis.StartSelectStockWithExpression(*expression_text)
 	} else if *func_name == "StartSelectStock" {
 		technical_analysis.StartSelectStock()
-	} else if *func_name == "StartSelectEtf" {
-		technical_analysis.StartSelectEtf()
-	} else if *func_name == "StartLhbAnalysis" {
-		technical_analysis.StartLhbAnalysis()
 	} else if *func_name == "StartEtfLofLowAnalysis" {
 		technical_analysis.StartEtfLofLowAnalysis()
 	} else if *func_name == "StartEtfLofVolatilityAnalysis" {
 		technical_analysis.StartEtfLofVolatilityAnalysis()
 	} else if *func_name == "StartEtfLofGridTradingAnalysis" {
 		technical_analysis.StartEtfLofGridTradingAnalysis()
+	} else if *func_name == "StartEtfLofGridOptimize" {
+		technical_analysis.StartEtfLofGridOptimize()
+	} else if *func_name == "StartDcaBacktesting" {
+		technical_analysis.StartDcaBacktesting()
+	} else if *func_name == "StartEtfRotationBacktesting" {
+		technical_analysis.StartEtfRotationBacktesting()
+	} else if *func_name == "StartEtfLofPremiumAnalysis" {
+		technical_analysis.StartEtfLofPremiumAnalysis()
+	} else if *func_name == "StartEtfTrackingAnalysis" {
+		technical_analysis.StartEtfTrackingAnalysis()
+	} else if *func_name == "StartValuationAnalysis" {
+		technical_analysis.StartValuationAnalysis()
+	} else if *func_name == "StartFactorResearch" {
+		technical_analysis.StartFactorResearch()
+	} else if *func_name == "StartMultiTimeframeSelectStock" {
+		technical_analysis.StartMultiTimeframeSelectStock()
 	} else if *func_name == "StartHotIndustryAnalysis" {
 		technical_analysis.StartHotIndustryAnalysis()
 	} else if *func_name == "StartHotIndustryHotStockAnalysis" {
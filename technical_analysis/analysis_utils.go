@@ -4,31 +4,115 @@ import (
 	"math"
 	"sort"
 
+	"github.com/hsuloong/stock_speculation/backtest"
 	"github.com/hsuloong/stock_speculation/data_center"
 )
 
-// @func 计算历史价格分位
-// @return 0-100之间 也就是原始值乘了100
+// @func 计算历史价格分位 相同价格取平均排名 最低价为0 最高价为100
+// @target 目标k线 不在区间内时也参与排名
+// @return 0-100之间 也就是原始值乘了100 区间只有一个价格时为50
 func CalculatePeriodRelativelyPercent(kline_items []data_center.KlineItem, start int, end int, target int) float64 {
-	array_len := end - start
-	close_price := make([]int64, array_len)
+	target_close := kline_items[target].Close
+	less, equal, count := 0, 0, end-start
 	for i := start; i < end; i++ {
-		close_price[i-start] = kline_items[i].Close
+		if kline_items[i].Close < target_close {
+			less++
+		} else if kline_items[i].Close == target_close {
+			equal++
+		}
+	}
+	if target < start || target >= end {
+		equal++
+		count++
 	}
 
-	sort.Slice(close_price, func(i, j int) bool {
-		return close_price[i] < close_price[j]
-	})
+	return calculatePercentRank(less, equal, count)
+}
+
+// @func 按小于和等于目标的个数计算分位 相同的值取平均排名
+// @equal 等于目标的个数 包含目标自己
+// @return 0-100之间
+func calculatePercentRank(less int, equal int, count int) float64 {
+	if count <= 1 {
+		return 50.0
+	}
+	return (float64(less) + float64(equal-1)/2.0) / float64(count-1) * 100.0
+}
 
-	price_percent := 0.0
-	for i := 0; i < array_len; i++ {
-		price_percent = float64(i) / float64(array_len)
-		if kline_items[target].Close < close_price[i] {
-			break
+// @func 计算收盘价在最近period根k线内的分位序列 分位定义同CalculatePeriodRelativelyPercent
+// 收盘价离散化后用树状数组维护窗口内每个价格的个数 复杂度O(n log n)
+// @return 与kline_items等长 数据不足的位置为NaN 0-100之间
+func CalculateRelativelyPercentSeries(kline_items []data_center.KlineItem, period int) []float64 {
+	kline_items_len := len(kline_items)
+	result := make([]float64, kline_items_len)
+	for i := range result {
+		result[i] = math.NaN()
+	}
+	if period <= 0 {
+		return result
+	}
+
+	// 离散化 ranks[i]为第i根k线收盘价在去重排序后的位置
+	prices := make([]int64, kline_items_len)
+	for i, kline_item := range kline_items {
+		prices[i] = kline_item.Close
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i] < prices[j]
+	})
+	unique_len := 0
+	for i, price := range prices {
+		if i == 0 || price != prices[unique_len-1] {
+			prices[unique_len] = price
+			unique_len++
+		}
+	}
+	prices = prices[:unique_len]
+
+	tree := newFenwickTree(unique_len)
+	ranks := make([]int, kline_items_len)
+	for i, kline_item := range kline_items {
+		ranks[i] = sort.Search(unique_len, func(j int) bool {
+			return prices[j] >= kline_item.Close
+		})
+
+		tree.add(ranks[i], 1)
+		if i >= period {
+			tree.add(ranks[i-period], -1)
+		}
+		if i+1 >= period {
+			less := tree.prefixSum(ranks[i])
+			equal := tree.prefixSum(ranks[i]+1) - less
+			result[i] = calculatePercentRank(less, equal, period)
 		}
 	}
 
-	return price_percent * 100.0
+	return result
+}
+
+// @func 树状数组 支持单点增减和前缀和
+type fenwickTree struct {
+	tree []int
+}
+
+func newFenwickTree(size int) *fenwickTree {
+	return &fenwickTree{tree: make([]int, size+1)}
+}
+
+// @func 第index个位置加上delta index从0开始
+func (fenwick *fenwickTree) add(index int, delta int) {
+	for i := index + 1; i < len(fenwick.tree); i += i & (-i) {
+		fenwick.tree[i] += delta
+	}
+}
+
+// @func 前index个位置的和 即[0, index)
+func (fenwick *fenwickTree) prefixSum(index int) int {
+	sum := 0
+	for i := index; i > 0; i -= i & (-i) {
+		sum += fenwick.tree[i]
+	}
+	return sum
 }
 
 // @func 计算历史最低价需要下跌的百分比
@@ -74,54 +158,30 @@ func CalculatePeriodAvgAmount(kline_items []data_center.KlineItem, start int, en
 	return amount_sum / float64(end-start)
 }
 
-// @func 计算周期内网格交易收益
+// @func 计算周期内网格交易收益 使用场内基金默认交易规则 按网格价全部成交
+// @grid_percent 每格的涨跌幅 以区间开始的开盘价为中心 上下各10% 10万资金
 // @return 原始值乘了100
 func CalculateGridTradingProfit(kline_items []data_center.KlineItem, start int, end int, grid_percent float64) float64 {
-	const kMaxSharesPerTrade int64 = 100000 // 单次交易股数
-	const kTax float64 = 3.0 / 1000.0       // 交易费率 简单处理下
-
-	var grid_price int64 = 0
-	net_buy_count := 0
-	account_money := make([]float64, end-start+1) // 记账
-
-	for i := start; i < end; i++ {
-		// 首次建仓直接买入
-		if i == start {
-			grid_price = kline_items[i].Open
-			account_money[i-start] -= float64(grid_price*kMaxSharesPerTrade) * (1 + kTax)
-			net_buy_count++
-			continue
-		}
-		next_buy_price := int64(float64(grid_price) * (1.0 - grid_percent))
-		next_sell_price := int64(float64(grid_price) * (1.0 + grid_percent))
-
-		if next_buy_price >= kline_items[i].EntityLow { // 买优先
-			grid_price = next_buy_price
-			account_money[i-start] -= float64(grid_price*kMaxSharesPerTrade) * (1 + kTax)
-			net_buy_count++
-		} else if next_sell_price <= kline_items[i].EntityHigh {
-			if net_buy_count > 0 {
-				grid_price = next_sell_price
-				account_money[i-start] += float64(grid_price*kMaxSharesPerTrade) * (1 - kTax)
-				net_buy_count--
-			} else {
-				grid_price = kline_items[i].Close // 更新网格 不更新容易买不到了
-			}
-		}
-	}
+	const kGridBand float64 = 0.1
+	const kGridBudget float64 = 100000
 
-	// 未卖出的以当日实体最高价卖出
-	if net_buy_count > 0 {
-		account_money[end-start-1] += float64(kline_items[end-1].EntityHigh*kMaxSharesPerTrade) * (1 - kTax) * float64(net_buy_count)
+	if start < 0 || end > len(kline_items) || start >= end {
+		return 0.0
 	}
+	config := backtest.NewGridConfig(backtest.GridType_Geometric, kline_items[start].Open, grid_percent, kGridBand, kGridBudget)
+	return CalculateGridTradingProfitWithConfig(data_center.StockItem{}, kline_items, nil, start, end, config)
+}
 
-	invest_money := -(CalculateMinSubArraySum(account_money))
-	profit := CalculateArraySum(account_money)
-	if invest_money > 1e-3 {
-		return profit / invest_money * 100.0
-	} else {
+// @func 按网格配置计算周期内网格交易收益 与backtest.SimulateGrid的指标一致
+// @stock_item 用于判断涨跌停幅度
+// @minute_kline_items 分钟k线 为空时按日k线近似
+// @return 总收益率 原始值乘了100
+func CalculateGridTradingProfitWithConfig(stock_item data_center.StockItem, day_kline_items []data_center.KlineItem, minute_kline_items []data_center.KlineItem, start int, end int, config backtest.GridConfig) float64 {
+	result := backtest.SimulateGrid(stock_item, day_kline_items, minute_kline_items, start, end, config)
+	if len(result.Equity) <= 0 {
 		return 0.0
 	}
+	return backtest.CalculateResultMetrics(result).TotalReturn
 }
 
 // @func 计算最小连续数组和
@@ -182,3 +242,65 @@ func CalculatePeriodStockLhbTimes(stock_item data_center.StockItem, kline_items
 
 	return result
 }
+
+// @func 计算收盘价简单移动平均序列
+// @return 与kline_items等长 数据不足的位置为NaN 单位毫
+func CalculateMaSeries(kline_items []data_center.KlineItem, period int) []float64 {
+	kline_items_len := len(kline_items)
+	result := make([]float64, kline_items_len)
+
+	close_sum := 0.0
+	for i := 0; i < kline_items_len; i++ {
+		close_sum += float64(kline_items[i].Close)
+		if i >= period {
+			close_sum -= float64(kline_items[i-period].Close)
+		}
+
+		if period <= 0 || i+1 < period {
+			result[i] = math.NaN()
+		} else {
+			result[i] = close_sum / float64(period)
+		}
+	}
+
+	return result
+}
+
+// @func 计算收盘价指数移动平均序列
+// @return 与kline_items等长 单位毫
+func CalculateEmaSeries(kline_items []data_center.KlineItem, period int) []float64 {
+	kline_items_len := len(kline_items)
+	result := make([]float64, kline_items_len)
+
+	alpha := 2.0 / float64(period+1)
+	for i := 0; i < kline_items_len; i++ {
+		if i == 0 {
+			result[i] = float64(kline_items[i].Close)
+		} else {
+			result[i] = alpha*float64(kline_items[i].Close) + (1.0-alpha)*result[i-1]
+		}
+	}
+
+	return result
+}
+
+// @func 计算RSI序列 算法同data_center.RSI
+// @return 与kline_items等长 0-100之间
+func CalculateRsiSeries(kline_items []data_center.KlineItem, period int) []float64 {
+	kline_items_len := len(kline_items)
+	result := make([]float64, kline_items_len)
+
+	rsi_red, rsi_all := 1e-6, 1e-6
+	for i := 0; i < kline_items_len; i++ {
+		gap := 0.0
+		if i > 0 {
+			gap = float64(kline_items[i].Close - kline_items[i-1].Close)
+		}
+
+		rsi_red = (float64(period-1)*rsi_red + math.Max(gap, 0.0)) / float64(period)
+		rsi_all = (float64(period-1)*rsi_all + math.Abs(gap)) / float64(period)
+		result[i] = rsi_red / rsi_all * 100
+	}
+
+	return result
+}
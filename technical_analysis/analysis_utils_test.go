@@ -0,0 +1,100 @@
+package technical_analysis
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+func newCloseKlineItems(closes ...int64) []data_center.KlineItem {
+	kline_items := make([]data_center.KlineItem, len(closes))
+	for i, close_price := range closes {
+		kline_items[i] = data_center.KlineItem{Close: close_price}
+	}
+	return kline_items
+}
+
+func TestCalculatePeriodRelativelyPercent(t *testing.T) {
+	cases := []struct {
+		name   string
+		closes []int64
+		start  int
+		end    int
+		target int
+		want   float64
+	}{
+		{"最高价", []int64{1, 2, 3, 4, 5}, 0, 5, 4, 100.0},
+		{"最低价", []int64{5, 4, 3, 2, 1}, 0, 5, 4, 0.0},
+		{"中间价", []int64{1, 2, 3, 4, 5}, 0, 5, 2, 50.0},
+		{"只有一个值", []int64{7}, 0, 1, 0, 50.0},
+		{"全部相同", []int64{3, 3, 3, 3}, 0, 4, 3, 50.0},
+		{"相同取平均排名", []int64{1, 2, 2, 3}, 0, 4, 1, 50.0},
+		{"相同的最低价", []int64{1, 1, 2, 3, 4}, 0, 5, 0, 12.5},
+		{"目标在区间之后", []int64{1, 2, 3, 4}, 0, 3, 3, 100.0},
+		{"目标在区间之前", []int64{0, 2, 3, 4}, 1, 4, 0, 0.0},
+		{"目标在区间外且有相同", []int64{2, 3, 2}, 0, 2, 2, 25.0},
+	}
+
+	for _, c := range cases {
+		got := CalculatePeriodRelativelyPercent(newCloseKlineItems(c.closes...), c.start, c.end, c.target)
+		if math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: got %f want %f", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCalculateRelativelyPercentSeries(t *testing.T) {
+	cases := []struct {
+		name   string
+		closes []int64
+		period int
+		want   []float64
+	}{
+		{"周期为1", []int64{3, 1, 2}, 1, []float64{50.0, 50.0, 50.0}},
+		{"数据不足", []int64{1, 2}, 3, []float64{math.NaN(), math.NaN()}},
+		{"单调上涨", []int64{1, 2, 3, 4}, 3, []float64{math.NaN(), math.NaN(), 100.0, 100.0}},
+		{"相同价格", []int64{2, 2, 1, 2}, 2, []float64{math.NaN(), 50.0, 0.0, 100.0}},
+	}
+
+	for _, c := range cases {
+		got := CalculateRelativelyPercentSeries(newCloseKlineItems(c.closes...), c.period)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %d values want %d", c.name, len(got), len(c.want))
+		}
+		for i := range got {
+			if math.IsNaN(c.want[i]) != math.IsNaN(got[i]) || (!math.IsNaN(got[i]) && math.Abs(got[i]-c.want[i]) > 1e-9) {
+				t.Errorf("%s: index %d got %f want %f", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+// 序列在每个位置都应该和逐个窗口计算的结果一致
+func TestCalculateRelativelyPercentSeriesMatchesPeriod(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		// 价格范围小 保证有足够多的相同价格
+		closes := make([]int64, 1+random.Intn(60))
+		for i := range closes {
+			closes[i] = int64(random.Intn(10))
+		}
+		kline_items := newCloseKlineItems(closes...)
+		period := 1 + random.Intn(len(closes))
+
+		series := CalculateRelativelyPercentSeries(kline_items, period)
+		for i := range kline_items {
+			if i+1 < period {
+				if !math.IsNaN(series[i]) {
+					t.Fatalf("round %d period %d: index %d got %f want NaN", round, period, i, series[i])
+				}
+				continue
+			}
+			want := CalculatePeriodRelativelyPercent(kline_items, i+1-period, i+1, i)
+			if math.Abs(series[i]-want) > 1e-9 {
+				t.Fatalf("round %d period %d: index %d got %f want %f", round, period, i, series[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package technical_analysis
+
+var benchmark_text = "SH000300"                      // 回测对比的基准 默认沪深300
+var backtest_workers = 0                             // 并行回测的协程数 0表示CPU核数
+var optimize_metric = "sharpe"                       // 参数优化的排序指标
+var output_dir = ""                                  // 回测结果导出目录 空表示不导出
+var etf_index_mapping_file = "etf_index_mapping.csv" // ETF跟踪指数的映射文件
+
+// @func 设置回测对比的基准
+// @text 指数或板块的代号或名称 如 SH000300 中证500
+func SetBenchmark(text string) {
+	benchmark_text = text
+}
+
+// @func 设置并行回测的协程数
+// @workers 0表示CPU核数 结果与协程数无关
+func SetBacktestWorkers(workers int) {
+	backtest_workers = workers
+}
+
+// @func 设置参数优化的排序指标
+// @metric sharpe sortino cagr calmar total_return profit_factor expectancy_rate win_rate max_drawdown
+func SetOptimizeMetric(metric string) {
+	optimize_metric = metric
+}
+
+// @func 设置回测结果导出目录
+// @dir 空表示不导出
+func SetOutputDir(dir string) {
+	output_dir = dir
+}
+
+// @func 设置ETF跟踪指数的映射文件
+// @path 每行 ETF代号,指数代号或名称
+func SetEtfIndexMappingFile(path string) {
+	etf_index_mapping_file = path
+}
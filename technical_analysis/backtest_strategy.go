@@ -0,0 +1,249 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+type morningStarSellPlan struct {
+	index  int   // 下卖单的k线索引
+	lot_id int64 // 对应买入批次
+}
+
+// @func 启明星策略 命中次日开盘买入 持有HoldDays个交易日后收盘卖出
+type MorningStarStrategy struct {
+	HoldDays      int                    // 持有交易日 1表示买入次日收盘卖出 0表示只由Config.ExitRules平仓
+	MoneyPerTrade float64                // 单次交易金额 单位元 0表示作为开仓信号由组合仓位模型决定金额
+	Parameters    VenusPatternParameters // 形态阈值
+	sell_plans    map[string][]morningStarSellPlan
+}
+
+func NewMorningStarStrategy(hold_days int, money_per_trade float64) *MorningStarStrategy {
+	return &MorningStarStrategy{
+		HoldDays:      hold_days,
+		MoneyPerTrade: money_per_trade,
+		Parameters:    DefaultVenusPatternParameters(),
+		sell_plans:    make(map[string][]morningStarSellPlan),
+	}
+}
+
+func (strategy *MorningStarStrategy) OnBar(ctx *backtest.BarContext) {
+	// 到期的批次下一根k线收盘卖出
+	sell_plans := strategy.sell_plans[ctx.Stock.Symbol]
+	remain_plans := sell_plans[:0]
+	for _, plan := range sell_plans {
+		if ctx.Index >= plan.index {
+			ctx.SellLot(plan.lot_id, backtest.OrderType_NextClose, "hold_days")
+		} else {
+			remain_plans = append(remain_plans, plan)
+		}
+	}
+
+	if IsVenusPatternWithParameters(ctx.KlineItems, ctx.Index, strategy.Parameters) {
+		var lot_id int64 = 0
+		if strategy.MoneyPerTrade > 0 {
+			lot_id = ctx.BuyValue(strategy.MoneyPerTrade, backtest.OrderType_NextOpen)
+		} else {
+			// 信号多于空余仓位时优先第三根阳线涨幅大的
+			lot_id = ctx.Enter(ctx.KlineItems[ctx.Index].Percent, 0, backtest.OrderType_NextOpen)
+		}
+		if strategy.HoldDays > 0 {
+			remain_plans = append(remain_plans, morningStarSellPlan{index: ctx.Index + strategy.HoldDays, lot_id: lot_id})
+		}
+	}
+
+	strategy.sell_plans[ctx.Stock.Symbol] = remain_plans
+}
+
+// @func 用回测引擎重新实现StartBacktesting 按信号年份统计
+func StartEngineBacktesting() {
+	fmt.Println("StartEngineBacktesting")
+
+	const kMaxSellDays int = 10              // 卖出距离买入日
+	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额
+	const kStartYear int = 2010
+
+	whole_stock_items := data_center.GetWholeStockItems()
+
+	config := backtest.DefaultConfig()
+	config.StartDate = fmt.Sprintf("%d0101", kStartYear)
+	config.Workers = backtest_workers
+	config.ShowProgress = true
+
+	results := make([]backtest.Result, kMaxSellDays)
+	for i := 0; i < kMaxSellDays; i++ {
+		hold_days := i + 1
+		engine := backtest.NewEngine(config)
+		results[i] = engine.RunParallel(func() backtest.Strategy {
+			return NewMorningStarStrategy(hold_days, kMaxMoneyPerTrade)
+		}, whole_stock_items)
+		exportBacktestResult(fmt.Sprintf("engine_hold%d", hold_days), map[string]string{"hold_days": strconv.Itoa(hold_days), "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade)}, config, len(whole_stock_items), results[i])
+	}
+
+	for year := kStartYear; year <= time.Now().Local().Year(); year++ {
+		fmt.Printf("\nFrom Year %d To %d:\n", year, year+1)
+
+		win := make([]uint64, kMaxSellDays)
+		loss := make([]uint64, kMaxSellDays)
+		win_rate := make([]float64, kMaxSellDays)
+		invest_money := make([]float64, kMaxSellDays)
+		profit := make([]float64, kMaxSellDays)
+		profit_rate := make([]float64, kMaxSellDays)
+
+		for i := 0; i < kMaxSellDays; i++ {
+			year_trades := make([]backtest.Trade, 0)
+			for _, trade := range results[i].Trades {
+				signal_date, _ := strconv.Atoi(trade.SignalDate)
+				if signal_date/10000 == year {
+					year_trades = append(year_trades, trade)
+				}
+			}
+
+			for _, trade := range year_trades {
+				if trade.Profit > 0 {
+					win[i]++
+				} else {
+					loss[i]++
+				}
+				profit[i] += trade.Profit
+			}
+
+			if win[i]+loss[i] > 0 {
+				win_rate[i] = float64(win[i]) / float64(win[i]+loss[i]) * 100.0
+			}
+			invest_money[i] = calculateTradesInvestedMoney(year_trades)
+			if invest_money[i] > 1.0e-3 {
+				profit_rate[i] = profit[i] / invest_money[i] * 100.0
+			}
+		}
+
+		fmt.Printf("胜次数: %v\n", win)
+		fmt.Printf("败次数: %v\n", loss)
+		fmt.Printf("胜率: %v\n", win_rate)
+		fmt.Printf("本金投入: %v\n", invest_money)
+		fmt.Printf("收益: %v\n", profit)
+		fmt.Printf("收益率: %v\n", profit_rate)
+	}
+}
+
+// @func 组合回测 有限资金 最大持仓数 对比不同仓位模型 按振幅滑点并限制单日成交量
+func StartPortfolioBacktesting() {
+	fmt.Println("StartPortfolioBacktesting")
+
+	const kInitialCash float64 = 1000000 // 初始资金
+	const kMaxPositions int = 10         // 最大持仓数
+	const kHoldDays int = 5              // 持有交易日
+
+	whole_stock_items := data_center.GetWholeStockItems()
+
+	sizer_names := []string{"等权", "固定比例", "目标波动率", "半凯利"}
+	sizer_ids := []string{"equal_weight", "fixed_fraction", "volatility_target", "half_kelly"}
+	sizers := []backtest.PositionSizer{
+		backtest.EqualWeightSizer{},
+		backtest.FixedFractionSizer{Fraction: 0.1},
+		backtest.VolatilityTargetSizer{TargetVolatility: 0.3, Lookback: 60, MaxWeight: 0.2},
+		backtest.KellySizer{Fraction: 0.5, Cap: 0.2, MinTrades: 30, DefaultWinRate: 0.5, DefaultPayoffRatio: 1.0},
+	}
+
+	for i, sizer := range sizers {
+		config := backtest.DefaultConfig()
+		config.StartDate = "20100101"
+		config.InitialCash = kInitialCash
+		config.MaxPositions = kMaxPositions
+		config.Sizer = sizer
+		config.Workers = backtest_workers
+		config.ShowProgress = true
+		config.FillModel = backtest.VolumeParticipationFill{Rate: 0.05, Inner: backtest.RangeSlippage{Fraction: 0.1}}
+
+		result := backtest.NewEngine(config).Run(NewMorningStarStrategy(kHoldDays, 0), whole_stock_items)
+		if len(result.Equity) <= 0 {
+			continue
+		}
+
+		fmt.Printf("\n%s: 放弃信号 %d\n%s\n", sizer_names[i], result.SkippedSignals, backtest.CalculateResultMetrics(result))
+		printBenchmarkMetrics(result)
+		exportBacktestResult("portfolio_"+sizer_ids[i], map[string]string{"hold_days": strconv.Itoa(kHoldDays)}, config, len(whole_stock_items), result)
+	}
+}
+
+// @func 平仓规则回测 对比不同平仓规则组合 按平仓原因统计
+func StartExitRulesBacktesting() {
+	fmt.Println("StartExitRulesBacktesting")
+
+	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额
+
+	whole_stock_items := data_center.GetWholeStockItems()
+
+	exit_rule_names := []string{"固定止损止盈", "ATR止损+移动止损", "移动止损+看跌吞没"}
+	exit_rule_sets := [][]backtest.ExitRule{
+		{backtest.StopLossExit{Percent: 0.05}, backtest.TakeProfitExit{Percent: 0.1}, backtest.MaxHoldingExit{Bars: 20}},
+		{backtest.AtrStopLossExit{Period: 14, Multiple: 2.0}, backtest.TrailingStopExit{AtrPeriod: 14, AtrMultiple: 3.0}, backtest.MaxHoldingExit{Bars: 60}},
+		{backtest.TrailingStopExit{Percent: 0.08}, backtest.PatternExit{Name: "bearish_engulfing", Match: IsBearishEngulfingPattern}, backtest.MaxHoldingExit{Bars: 60}},
+	}
+
+	for i, exit_rules := range exit_rule_sets {
+		config := backtest.DefaultConfig()
+		config.StartDate = "20100101"
+		config.ExitRules = exit_rules
+		config.Workers = backtest_workers
+		config.ShowProgress = true
+
+		result := backtest.NewEngine(config).RunParallel(func() backtest.Strategy {
+			return NewMorningStarStrategy(0, kMaxMoneyPerTrade)
+		}, whole_stock_items)
+
+		fmt.Printf("\n%s:\n%s\n", exit_rule_names[i], backtest.CalculateResultMetrics(result))
+		printBenchmarkMetrics(result)
+		exportBacktestResult(fmt.Sprintf("exit_rules%d", i+1), map[string]string{"exit_rule_name": exit_rule_names[i], "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade)}, config, len(whole_stock_items), result)
+		for _, stat := range backtest.CalculateExitReasonStats(result.Trades) {
+			fmt.Printf("%s 次数 %d 胜率 %f 平均收益率 %f 总收益 %f 平均持有 %f\n", stat.Reason, stat.Count, stat.WinRate, stat.AvgReturnRate, stat.TotalProfit, stat.AvgHoldingBars)
+		}
+	}
+}
+
+// @func 蒙特卡洛检验 启明星策略的交易重新抽样 丢弃部分交易 叠加随机滑点 看收益是否依赖运气
+func StartMonteCarloBacktesting() {
+	fmt.Println("StartMonteCarloBacktesting")
+
+	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额
+	const kHoldDays int = 5                  // 持有交易日
+
+	whole_stock_items := data_center.GetWholeStockItems()
+
+	config := backtest.DefaultConfig()
+	config.StartDate = "20100101"
+	config.Workers = backtest_workers
+	config.ShowProgress = true
+	result := backtest.NewEngine(config).RunParallel(func() backtest.Strategy {
+		return NewMorningStarStrategy(kHoldDays, kMaxMoneyPerTrade)
+	}, whole_stock_items)
+	fmt.Printf("交易次数 %d\n", len(result.Trades))
+	exportBacktestResult("monte_carlo", map[string]string{"hold_days": strconv.Itoa(kHoldDays), "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade)}, config, len(whole_stock_items), result)
+
+	method_names := []string{"有放回抽样", "打乱顺序"}
+	methods := []backtest.MonteCarloMethod{backtest.MonteCarloMethod_Bootstrap, backtest.MonteCarloMethod_Shuffle}
+	for i, method := range methods {
+		monte_carlo_config := backtest.DefaultMonteCarloConfig()
+		monte_carlo_config.Method = method
+		fmt.Printf("\n%s:\n%s\n", method_names[i], backtest.RunMonteCarlo(result.Trades, monte_carlo_config))
+	}
+}
+
+// @func 按交易的买卖现金流计算占用本金
+func calculateTradesInvestedMoney(trades []backtest.Trade) float64 {
+	dates := make([]string, 0, len(trades)*2)
+	for _, trade := range trades {
+		dates = append(dates, trade.EntryDate, trade.ExitDate)
+	}
+	ledger := backtest.NewLedger(backtest.NewTradingCalendar(dates))
+	for _, trade := range trades {
+		entry_value := float64(trade.Quantity) * backtest.PriceToYuan(trade.EntryPrice)
+		ledger.Add(trade.EntryDate, -entry_value)
+		ledger.Add(trade.ExitDate, entry_value+trade.Profit)
+	}
+	return ledger.InvestedCapital()
+}
@@ -0,0 +1,89 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 在指数 行业板块 概念板块 ETF LOF中按代号或名称查找基准
+func FindBenchmarkStockItem(text string) (data_center.StockItem, bool) {
+	whole_stock_items := [][]data_center.StockItem{
+		data_center.GetWholeIndexStockItems(),
+		data_center.GetWholeIndustryStockItems(),
+		data_center.GetWholeConceptStockItems(),
+		data_center.GetWholeEtfStockItems(),
+		data_center.GetWholeLofStockItems(),
+	}
+	for _, stock_items := range whole_stock_items {
+		for _, iter := range stock_items {
+			if strings.EqualFold(iter.Symbol, text) || iter.Name == text {
+				return iter, true
+			}
+		}
+	}
+	return data_center.StockItem{}, false
+}
+
+// @func 获取回测基准和它的日k线
+// @return 没有设置基准或者找不到时返回false
+func loadBenchmarkKlineItems() (data_center.StockItem, []data_center.KlineItem, bool) {
+	if len(benchmark_text) <= 0 {
+		return data_center.StockItem{}, nil, false
+	}
+	benchmark, ok := FindBenchmarkStockItem(benchmark_text)
+	if !ok {
+		fmt.Printf("没有找到基准 %s\n", benchmark_text)
+		return data_center.StockItem{}, nil, false
+	}
+	return benchmark, data_center.GetKlineItems(data_center.KlineType_Day, benchmark, 250*14), true
+}
+
+// @func 打印回测结果相对基准的指标
+func printBenchmarkMetrics(result backtest.Result) {
+	benchmark, kline_items, ok := loadBenchmarkKlineItems()
+	if !ok {
+		return
+	}
+	fmt.Println(backtest.CalculateResultBenchmarkMetrics(result, benchmark, kline_items))
+}
+
+// @func 导出回测的交易 资金曲线 每日持仓 运行清单和html报告
+// @parameters 策略参数 和回测配置一起写入清单
+func exportBacktestResult(name string, parameters map[string]string, config backtest.Config, stock_count int, result backtest.Result) {
+	exportResultWithConfig(name, parameters, backtest.ConfigParameters(config), stock_count, result)
+}
+
+// @func 导出不经过回测引擎的结果 如网格和定投
+// @parameters 策略参数
+// @config_parameters 配置转换的清单参数 如backtest.GridConfigParameters
+func exportResultWithConfig(name string, parameters map[string]string, config_parameters map[string]string, stock_count int, result backtest.Result) {
+	if len(output_dir) <= 0 {
+		return
+	}
+
+	manifest_parameters := make(map[string]string, len(config_parameters)+len(parameters))
+	for key, value := range config_parameters {
+		manifest_parameters[key] = value
+	}
+	for key, value := range parameters {
+		manifest_parameters["strategy."+key] = value
+	}
+	manifest := backtest.NewRunManifest(name, manifest_parameters, stock_count, result)
+	run_dir, err := backtest.ExportResult(output_dir, manifest, result)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	benchmark, kline_items, _ := loadBenchmarkKlineItems()
+	err = backtest.WriteHtmlReport(filepath.Join(run_dir, "report.html"), manifest, result, benchmark, kline_items)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("回测结果已导出到 %s\n", run_dir)
+}
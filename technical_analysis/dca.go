@@ -0,0 +1,73 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 估值定投倍数 按近几年的价格分位线性映射 分位0时2倍 50时1倍 100时0倍
+// @lookback_days 计算分位的交易日数
+func newValuationMultiplier(lookback_days int) func([]data_center.KlineItem, int) float64 {
+	return func(kline_items []data_center.KlineItem, index int) float64 {
+		start := int(math.Max(float64(index+1-lookback_days), 0))
+		percent := CalculatePeriodRelativelyPercent(kline_items, start, index+1, index)
+		return (100.0 - percent) / 50.0
+	}
+}
+
+// @func 定投回测 对-b指定的指数或基金 比较每周每月 固定金额 价值平均 估值定投 以及同样金额一次性投入
+func StartDcaBacktesting() {
+	fmt.Println("StartDcaBacktesting")
+
+	const kStartDate string = "20150101"
+	const kLookbackDays int = 250 * 5 // 估值分位的统计区间
+
+	stock_item, ok := FindBenchmarkStockItem(benchmark_text)
+	if !ok {
+		fmt.Printf("没有找到定投标的 %s\n", benchmark_text)
+		return
+	}
+	kline_items := data_center.GetKlineItems(data_center.KlineType_Day, stock_item, 250*14)
+	start := 0
+	for start < len(kline_items) && kline_items[start].Date < kStartDate {
+		start++
+	}
+	if start >= len(kline_items) {
+		fmt.Printf("%s(%s) 没有%s之后的k线\n", stock_item.Name, stock_item.Symbol, kStartDate)
+		return
+	}
+
+	schedule_names := []string{"每周", "每月"}
+	schedule_ids := []string{"weekly", "monthly"}
+	schedules := []backtest.DcaSchedule{backtest.DcaSchedule_Weekly, backtest.DcaSchedule_Monthly}
+	schedule_amounts := []float64{250, 1000}
+	mode_names := []string{"固定金额", "价值平均", "估值定投"}
+	mode_ids := []string{"fixed", "value_averaging", "valuation"}
+	modes := []backtest.DcaMode{backtest.DcaMode_Fixed, backtest.DcaMode_ValueAveraging, backtest.DcaMode_Valuation}
+
+	// 指数不能直接买入 不限制整手 按基金费率估算
+	rules := backtest.DefaultEtfTradingRules()
+	rules.LotSize = 1
+
+	fmt.Printf("%s(%s) 从%s开始定投\n", stock_item.Name, stock_item.Symbol, kline_items[start].Date)
+	for i, schedule := range schedules {
+		for j, mode := range modes {
+			config := backtest.DefaultDcaConfig()
+			config.Schedule = schedule
+			config.Amount = schedule_amounts[i]
+			config.Mode = mode
+			config.Multiplier = newValuationMultiplier(kLookbackDays)
+			config.Rules = rules
+
+			result := backtest.SimulateDca(stock_item, kline_items, start, len(kline_items), config)
+			fmt.Printf("\n%s%s:\n%s\n", schedule_names[i], mode_names[j], result)
+
+			// 定投没有完整的买卖交易 导出资金曲线 成交和每日持仓
+			parameters := map[string]string{"symbol": stock_item.Symbol, "lookback_days": fmt.Sprint(kLookbackDays)}
+			exportResultWithConfig(fmt.Sprintf("dca_%s_%s", schedule_ids[i], mode_ids[j]), parameters, backtest.DcaConfigParameters(config), 1, backtest.Result{Equity: result.Equity, Fills: result.Fills})
+		}
+	}
+}
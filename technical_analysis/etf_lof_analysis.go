@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/hsuloong/stock_speculation/backtest"
 	"github.com/hsuloong/stock_speculation/data_center"
 )
 
@@ -102,13 +103,21 @@ func StartEtfLofGridTradingAnalysis() {
 	const kMinRate float64 = -100.0
 	const kMinTradeAmount uint64 = 1e5 * 10000
 	const kMinAvgTradeDays int = 5
+	const kGridBand float64 = 0.1      // 网格上下界相对中心价的幅度
+	const kGridBudget float64 = 100000 // 网格总资金
+
+	// 网格价加5个基点滑点 单日最多成交当日成交量的10%
+	fill_model := backtest.VolumeParticipationFill{Rate: 0.1, Inner: backtest.FixedBpsSlippage{Bps: 5}}
 
 	whole_etf_lof_stock_items := data_center.GetWholeEtfStockItems()
 	for _, iter := range whole_etf_lof_stock_items {
 		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, iter, 250*14)
 		kline_items_len := len(kline_items)
+		// 分钟k线按日期对齐 所有区间共用
+		minute_kline_items := getGridMinuteKlineItems(iter, kMaxMonths*kMonthTradeDays)
 
 		target_profit_rate := -100.0
+		target_metrics := backtest.Metrics{}
 		result := ""
 		for i := 1; i <= kMaxMonths; i++ {
 			days_before := i * kMonthTradeDays
@@ -116,13 +125,18 @@ func StartEtfLofGridTradingAnalysis() {
 				continue
 			}
 
-			loop_profit_rate := CalculateGridTradingProfit(kline_items, kline_items_len-days_before, kline_items_len, 0.01)
+			// 多档网格引擎 以区间开始的开盘价为中心 上下各10%
+			grid_config := backtest.NewGridConfig(backtest.GridType_Geometric, kline_items[kline_items_len-days_before].Open, 0.01, kGridBand, kGridBudget)
+			grid_config.FillModel = fill_model
+			loop_result := backtest.SimulateGrid(iter, kline_items, minute_kline_items, kline_items_len-days_before, kline_items_len, grid_config)
+			loop_metrics := backtest.CalculateResultMetrics(loop_result)
 
 			if kTargetMonth == i {
-				target_profit_rate = loop_profit_rate
+				target_profit_rate = loop_metrics.TotalReturn
+				target_metrics = loop_metrics
 			}
 
-			result += fmt.Sprintf("最近%d月 %f ", i, loop_profit_rate)
+			result += fmt.Sprintf("最近%d月 %f ", i, loop_metrics.TotalReturn)
 		}
 
 		var period_amount_avg uint64 = 0
@@ -133,7 +147,7 @@ func StartEtfLofGridTradingAnalysis() {
 		}
 
 		if target_profit_rate >= kMinRate && period_amount_avg >= kMinTradeAmount {
-			fmt.Printf("%s(%s) %s\n", iter.Name, iter.Symbol, result)
+			fmt.Printf("%s(%s) %s最近%d月最大回撤 %f 夏普 %f 胜率 %f\n", iter.Name, iter.Symbol, result, kTargetMonth, target_metrics.MaxDrawdown, target_metrics.Sharpe, target_metrics.WinRate)
 		}
 	}
 }
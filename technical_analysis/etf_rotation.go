@@ -0,0 +1,228 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 轮动排名 动量已经乘了100
+type rotationScore struct {
+	index    int
+	momentum float64 // 区间涨跌幅
+	score    float64 // 排名依据 风险调整时为动量除以区间波动
+}
+
+// @func ETF动量轮动 每RebalanceDays个交易日按动量排名 持有前TopK只 等权
+// 绝对动量为负的不持有 空出的仓位买入避险ETF 没有避险ETF时持有现金
+type EtfRotationStrategy struct {
+	RebalanceDays  int     // 调仓间隔交易日
+	LookbackDays   int     // 动量的统计交易日
+	TopK           int     // 持有只数
+	RiskAdjusted   bool    // 是否按动量除以波动率排名
+	AbsoluteFilter bool    // 是否要求动量为正
+	SafeIndex      int     // 避险ETF在股票池中的索引 不参与排名 -1表示持有现金
+	MinTradeRate   float64 // 与目标市值相差不到该比例时不调整 减少调仓成本
+
+	day_count    int
+	last_date    string          // 最近一次调仓日期
+	last_ranking []rotationScore // 最近一次调仓的排名
+	last_targets map[int]float64 // 最近一次调仓的目标权重 股票池索引 -> 权重
+}
+
+func NewEtfRotationStrategy(rebalance_days int, lookback_days int, top_k int, safe_index int) *EtfRotationStrategy {
+	return &EtfRotationStrategy{
+		RebalanceDays:  rebalance_days,
+		LookbackDays:   lookback_days,
+		TopK:           top_k,
+		RiskAdjusted:   true,
+		AbsoluteFilter: true,
+		SafeIndex:      safe_index,
+		MinTradeRate:   0.05,
+	}
+}
+
+func (strategy *EtfRotationStrategy) OnBar(ctx *backtest.BarContext) {}
+
+func (strategy *EtfRotationStrategy) OnDate(ctx *backtest.DateContext) {
+	strategy.day_count++
+	if (strategy.day_count-1)%strategy.RebalanceDays != 0 {
+		return
+	}
+
+	ranking := strategy.rank(ctx)
+	if len(ranking) <= 0 {
+		strategy.day_count-- // 数据不足 下一个交易日再试
+		return
+	}
+
+	// 目标权重 不满足绝对动量的仓位转为避险ETF或现金
+	weight := 1.0 / float64(strategy.TopK)
+	targets := make(map[int]float64)
+	for i := 0; i < len(ranking) && i < strategy.TopK; i++ {
+		if strategy.AbsoluteFilter && ranking[i].momentum <= 0 {
+			break
+		}
+		targets[ranking[i].index] = weight
+	}
+	if len(targets) < strategy.TopK && strategy.SafeIndex >= 0 && ctx.HasBar(strategy.SafeIndex) {
+		targets[strategy.SafeIndex] += weight * float64(strategy.TopK-len(targets))
+	}
+	strategy.last_date = ctx.Date
+	strategy.last_ranking = ranking
+	strategy.last_targets = targets
+
+	// 先卖出不在目标中的 再按目标市值调整
+	equity := ctx.Equity()
+	for i := range ctx.Stocks {
+		position := ctx.Position(i)
+		if position == nil {
+			continue
+		}
+		if _, ok := targets[i]; !ok {
+			ctx.SellAll(i, backtest.OrderType_NextOpen, "rotation")
+		}
+	}
+	for i := range ctx.Stocks {
+		target_weight, ok := targets[i]
+		if !ok || !ctx.HasBar(i) {
+			continue
+		}
+		target_value := equity * target_weight
+		current_value := 0.0
+		position := ctx.Position(i)
+		if position != nil {
+			current_value = position.MarketValue()
+		}
+
+		difference := target_value - current_value
+		if math.Abs(difference) < target_value*strategy.MinTradeRate {
+			continue
+		}
+		if difference > 0 {
+			ctx.BuyValue(i, difference, backtest.OrderType_NextOpen)
+		} else if position != nil && position.LastPrice > 0 {
+			// 减仓按整手卖出
+			quantity := ctx.Rules.RoundLot(int64(-difference / backtest.PriceToYuan(position.LastPrice)))
+			if quantity > 0 {
+				ctx.Sell(i, quantity, backtest.OrderType_NextOpen, "rebalance")
+			}
+		}
+	}
+}
+
+// @func 当日有k线且数据足够的ETF按得分从高到低排名 避险ETF不参与
+func (strategy *EtfRotationStrategy) rank(ctx *backtest.DateContext) []rotationScore {
+	result := make([]rotationScore, 0)
+	for i := range ctx.Stocks {
+		if i == strategy.SafeIndex || !ctx.HasBar(i) {
+			continue
+		}
+		kline_items := ctx.KlineItems(i)
+		kline_items_len := len(kline_items)
+		if kline_items_len <= strategy.LookbackDays {
+			continue
+		}
+
+		start := kline_items_len - 1 - strategy.LookbackDays
+		momentum := float64(kline_items[kline_items_len-1].Close)/float64(kline_items[start].Close) - 1.0
+		score := momentum
+		if strategy.RiskAdjusted {
+			returns := make([]float64, 0, strategy.LookbackDays)
+			for j := start + 1; j < kline_items_len; j++ {
+				returns = append(returns, float64(kline_items[j].Close)/float64(kline_items[j-1].Close)-1.0)
+			}
+			volatility := backtest.CalculateStd(returns) * math.Sqrt(float64(strategy.LookbackDays))
+			if volatility > 0 {
+				score = momentum / volatility
+			}
+		}
+		result = append(result, rotationScore{index: i, momentum: momentum * 100.0, score: score})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].score > result[j].score
+	})
+	return result
+}
+
+// @func ETF轮动回测 宽基 海外 商品 债券ETF每20个交易日按风险调整动量轮动 持有前2只
+func StartEtfRotationBacktesting() {
+	fmt.Println("StartEtfRotationBacktesting")
+
+	const kInitialCash float64 = 1000000 // 初始资金
+	const kRebalanceDays int = 20        // 调仓间隔交易日
+	const kLookbackDays int = 20         // 动量的统计交易日
+	const kTopK int = 2                  // 持有只数
+	const kSafeSymbol string = "SH511010"
+
+	// 最后一只为避险ETF
+	pool_symbols := []string{"SH510300", "SH510500", "SZ159915", "SH513100", "SH518880", kSafeSymbol}
+	stock_items := make([]data_center.StockItem, 0)
+	for _, symbol := range pool_symbols {
+		found := false
+		for _, iter := range data_center.GetWholeEtfStockItems() {
+			if strings.EqualFold(iter.Symbol, symbol) {
+				stock_items = append(stock_items, iter)
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Printf("没有找到ETF %s\n", symbol)
+		}
+	}
+	safe_index := -1
+	for i, iter := range stock_items {
+		if strings.EqualFold(iter.Symbol, kSafeSymbol) {
+			safe_index = i
+		}
+	}
+
+	config := backtest.DefaultConfig()
+	config.StartDate = "20150101"
+	config.InitialCash = kInitialCash
+	config.Rules = backtest.DefaultEtfTradingRules()
+	config.FillModel = backtest.FixedBpsSlippage{Bps: 5}
+	config.Workers = backtest_workers
+
+	strategy := NewEtfRotationStrategy(kRebalanceDays, kLookbackDays, kTopK, safe_index)
+	engine := backtest.NewEngine(config)
+	result := engine.Run(strategy, stock_items)
+	if len(result.Equity) <= 0 {
+		return
+	}
+
+	total_fee := 0.0
+	for _, fill := range result.Fills {
+		total_fee += fill.Fee
+	}
+	fmt.Printf("\n调仓成本 %.2f 成交笔数 %d\n%s\n", total_fee, len(result.Fills), backtest.CalculateResultMetrics(result))
+	printBenchmarkMetrics(result)
+
+	fmt.Printf("\n最近一次调仓 %s 排名:\n", strategy.last_date)
+	for i, item := range strategy.last_ranking {
+		fmt.Printf("%d. %s(%s) 动量 %.2f%% 得分 %.2f 目标权重 %.0f%%\n", i+1, stock_items[item.index].Name, stock_items[item.index].Symbol, item.momentum, item.score, strategy.last_targets[item.index]*100.0)
+	}
+	if safe_index >= 0 {
+		fmt.Printf("避险 %s(%s) 目标权重 %.0f%%\n", stock_items[safe_index].Name, stock_items[safe_index].Symbol, strategy.last_targets[safe_index]*100.0)
+	}
+
+	fmt.Println("\n当前持仓:")
+	last_equity := result.Equity[len(result.Equity)-1]
+	for _, position := range engine.Positions() {
+		fmt.Printf("%s(%s) 股数 %d 市值 %.2f 占比 %.2f%%\n", position.Stock.Name, position.Stock.Symbol, position.Quantity(), position.MarketValue(), position.MarketValue()/last_equity.Equity*100.0)
+	}
+	fmt.Printf("现金 %.2f 占比 %.2f%%\n", last_equity.Cash, last_equity.Cash/last_equity.Equity*100.0)
+
+	exportBacktestResult("etf_rotation", map[string]string{
+		"rebalance_days": fmt.Sprint(kRebalanceDays),
+		"lookback_days":  fmt.Sprint(kLookbackDays),
+		"top_k":          fmt.Sprint(kTopK),
+		"pool":           strings.Join(pool_symbols, ","),
+	}, config, len(stock_items), result)
+}
@@ -0,0 +1,190 @@
+package technical_analysis
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 读取ETF跟踪指数的映射文件 每行 ETF代号,指数代号或名称 #之后为注释
+// @return ETF代号大写 -> 指数代号或名称 文件不存在时返回空映射
+func LoadEtfIndexMapping(path string) (map[string]string, error) {
+	result := make(map[string]string)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line_number := 1; scanner.Scan(); line_number++ {
+		line := strings.TrimPrefix(scanner.Text(), "\ufeff")
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) <= 0 {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || len(strings.TrimSpace(fields[0])) <= 0 || len(strings.TrimSpace(fields[1])) <= 0 {
+			return result, fmt.Errorf("%s 第%d行格式错误 应为 ETF代号,指数代号或名称", path, line_number)
+		}
+		result[strings.ToUpper(strings.TrimSpace(fields[0]))] = strings.TrimSpace(fields[1])
+	}
+	return result, scanner.Err()
+}
+
+// @func ETF收盘价转换为资金曲线 用于计算相对指数的指标
+// @days 最近多少个交易日 不含作为期初的前一天
+// @return 资金曲线 期初收盘价 单位元
+func etfCloseEquity(kline_items []data_center.KlineItem, days int) ([]backtest.EquityPoint, float64) {
+	kline_items_len := len(kline_items)
+	if kline_items_len < 2 {
+		return nil, 0.0
+	}
+	start := kline_items_len - days
+	if start < 1 {
+		start = 1
+	}
+
+	equity := make([]backtest.EquityPoint, 0, kline_items_len-start)
+	for i := start; i < kline_items_len; i++ {
+		close_price := backtest.PriceToYuan(kline_items[i].Close)
+		equity = append(equity, backtest.EquityPoint{Date: kline_items[i].Date, PositionValue: close_price, Equity: close_price})
+	}
+	return equity, backtest.PriceToYuan(kline_items[start-1].Close)
+}
+
+// @func 单只ETF的跟踪情况
+type etfTracking struct {
+	stock_item data_center.StockItem
+	index_item data_center.StockItem
+	suggested  bool                      // true表示映射文件中没有 按相关系数推荐的指数
+	metrics    backtest.BenchmarkMetrics // 年化超额即跟踪偏离
+	avg_amount float64                   // 日均成交额 单位毫
+}
+
+// @func ETF跟踪分析 按映射文件或者日收益率相关系数最高的指数 计算最近一年的跟踪偏离 跟踪误差和相关系数
+// 同一指数的ETF按跟踪误差从小到大排列 没有映射的ETF把推荐结果写入映射文件同目录的_suggest文件 确认后可以复制到映射文件
+// ETF使用收盘价 跟踪偏离包含折溢价和分红的影响
+func StartEtfTrackingAnalysis() {
+	fmt.Println("StartEtfTrackingAnalysis")
+
+	const kTrackingDays int = 250   // 统计的交易日
+	const kMinTrackingDays int = 60 // 至少有多少个交易日
+	const kMinAvgTradeDays int = 20 // 日均成交额的统计天数
+
+	mapping, err := LoadEtfIndexMapping(etf_index_mapping_file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	index_items := data_center.GetWholeIndexStockItems()
+	index_kline_items := make([][]data_center.KlineItem, len(index_items))
+	backtest.ParallelFor(len(index_items), backtest_workers, backtest.NewProgress("指数k线", len(index_items)), func(index int) {
+		index_kline_items[index] = data_center.GetKlineItems(data_center.KlineType_Day, index_items[index], 250*14)
+	})
+
+	findIndex := func(text string) int {
+		for i, iter := range index_items {
+			if strings.EqualFold(iter.Symbol, text) || iter.Name == text {
+				return i
+			}
+		}
+		return -1
+	}
+
+	stock_items := data_center.GetWholeEtfStockItems()
+	trackings := make([]*etfTracking, len(stock_items))
+	backtest.ParallelFor(len(stock_items), backtest_workers, backtest.NewProgress("ETF跟踪", len(stock_items)), func(index int) {
+		stock_item := stock_items[index]
+		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, stock_item, 250*14)
+		kline_items_len := len(kline_items)
+		if kline_items_len <= kMinTrackingDays {
+			return
+		}
+		equity, initial_equity := etfCloseEquity(kline_items, kTrackingDays)
+		avg_amount := CalculatePeriodAvgAmount(kline_items, kline_items_len-kMinAvgTradeDays, kline_items_len)
+
+		if text, ok := mapping[strings.ToUpper(stock_item.Symbol)]; ok {
+			i := findIndex(text)
+			if i < 0 {
+				fmt.Printf("%s(%s) 没有找到指数 %s\n", stock_item.Name, stock_item.Symbol, text)
+				return
+			}
+			trackings[index] = &etfTracking{
+				stock_item: stock_item,
+				index_item: index_items[i],
+				metrics:    backtest.CalculateBenchmarkMetrics(equity, initial_equity, index_items[i], index_kline_items[i]),
+				avg_amount: avg_amount,
+			}
+			return
+		}
+
+		// 相关系数最高的指数 数据不足一年的指数不参与
+		var best *etfTracking
+		for i, index_item := range index_items {
+			if len(index_kline_items[i]) <= 0 || index_kline_items[i][0].Date > equity[0].Date {
+				continue
+			}
+			metrics := backtest.CalculateBenchmarkMetrics(equity, initial_equity, index_item, index_kline_items[i])
+			if best == nil || metrics.Correlation > best.metrics.Correlation {
+				best = &etfTracking{stock_item: stock_item, index_item: index_item, suggested: true, metrics: metrics, avg_amount: avg_amount}
+			}
+		}
+		trackings[index] = best
+	})
+
+	result := make([]*etfTracking, 0)
+	for _, tracking := range trackings {
+		if tracking != nil {
+			result = append(result, tracking)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].index_item.Symbol != result[j].index_item.Symbol {
+			return result[i].index_item.Symbol < result[j].index_item.Symbol
+		}
+		return result[i].metrics.TrackingError < result[j].metrics.TrackingError
+	})
+
+	fmt.Printf("\n最近%d个交易日 同一指数按跟踪误差排序:\n", kTrackingDays)
+	fmt.Println("指数名称(代号) ETF名称(代号) 年化跟踪偏离 年化跟踪误差 相关系数 日均成交额(万) 来源")
+	suggestions := make([]*etfTracking, 0)
+	for _, tracking := range result {
+		source := "映射"
+		if tracking.suggested {
+			source = "推荐"
+			suggestions = append(suggestions, tracking)
+		}
+		fmt.Printf("%s(%s) %s(%s) %.2f%% %.2f%% %.4f %.0f %s\n", tracking.index_item.Name, tracking.index_item.Symbol, tracking.stock_item.Name, tracking.stock_item.Symbol,
+			tracking.metrics.AnnualExcessReturn, tracking.metrics.TrackingError, tracking.metrics.Correlation, tracking.avg_amount/10000/10000, source)
+	}
+
+	if len(suggestions) <= 0 {
+		return
+	}
+	extension := filepath.Ext(etf_index_mapping_file)
+	suggest_file := strings.TrimSuffix(etf_index_mapping_file, extension) + "_suggest" + extension
+	content := "# ETF代号,指数代号 按日收益率相关系数推荐 确认后复制到" + etf_index_mapping_file + "\n"
+	for _, tracking := range suggestions {
+		content += fmt.Sprintf("%s,%s # %s %s 相关系数 %.4f\n", tracking.stock_item.Symbol, tracking.index_item.Symbol, tracking.stock_item.Name, tracking.index_item.Name, tracking.metrics.Correlation)
+	}
+	err = os.WriteFile(suggest_file, []byte(content), 0644)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("推荐的映射已写入 %s\n", suggest_file)
+}
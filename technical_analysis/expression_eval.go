@@ -0,0 +1,617 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+type expressionType = int
+
+const (
+	expressionType_Number expressionType = 0
+	expressionType_Bool   expressionType = 1
+	expressionType_String expressionType = 2
+)
+
+var expressionTypeNames = map[expressionType]string{
+	expressionType_Number: "数字",
+	expressionType_Bool:   "布尔",
+	expressionType_String: "字符串",
+}
+
+type expressionValue struct {
+	number  float64
+	boolean bool
+}
+
+// @func 表达式的一帧 即某个周期的k线和指标缓存
+type expressionFrame struct {
+	kline_type   data_center.KlineType
+	kline_items  []data_center.KlineItem
+	align_index  []int // 对齐到基础周期的索引 基础周期为nil
+	series_cache map[string][]float64
+}
+
+// @func 表达式求值上下文 一只股票一个上下文 复用指标缓存
+type ExpressionContext struct {
+	StockItem  data_center.StockItem
+	base_frame *expressionFrame
+	frames     map[data_center.KlineType]*expressionFrame
+}
+
+// @func 创建表达式求值上下文
+// @stock_item 股票 周期限定时用来获取其他周期k线
+// @kline_type kline_items的周期
+// @kline_items 基础周期k线
+func NewExpressionContext(stock_item data_center.StockItem, kline_type data_center.KlineType, kline_items []data_center.KlineItem) *ExpressionContext {
+	base_frame := &expressionFrame{kline_type: kline_type, kline_items: kline_items, series_cache: make(map[string][]float64)}
+	return &ExpressionContext{
+		StockItem:  stock_item,
+		base_frame: base_frame,
+		frames:     map[data_center.KlineType]*expressionFrame{kline_type: base_frame},
+	}
+}
+
+// 各周期获取的k线数量 约14年
+var expressionTimeframeCountMap = map[data_center.KlineType]uint64{
+	data_center.KlineType_Day:     250 * 14,
+	data_center.KlineType_Week:    52 * 14,
+	data_center.KlineType_Month:   12 * 14,
+	data_center.KlineType_Quarter: 4 * 14,
+	data_center.KlineType_Year:    14,
+}
+
+func (ctx *ExpressionContext) getFrame(kline_type data_center.KlineType) *expressionFrame {
+	frame, ok := ctx.frames[kline_type]
+	if ok {
+		return frame
+	}
+
+	kline_items := data_center.GetKlineItems(kline_type, ctx.StockItem, expressionTimeframeCountMap[kline_type])
+	frame = &expressionFrame{
+		kline_type:   kline_type,
+		kline_items:  kline_items,
+		align_index:  AlignKlineItems(ctx.base_frame.kline_items, kline_items, kline_type),
+		series_cache: make(map[string][]float64),
+	}
+	ctx.frames[kline_type] = frame
+
+	return frame
+}
+
+// @func 获取指标序列 每个位置只依赖之前的k线 所以整段计算不会引入未来数据
+func (frame *expressionFrame) getSeries(key string, calculate func([]data_center.KlineItem) []float64) []float64 {
+	series, ok := frame.series_cache[key]
+	if !ok {
+		series = calculate(frame.kline_items)
+		frame.series_cache[key] = series
+	}
+	return series
+}
+
+// 价格单位毫转换为元
+const kExpressionPriceUnit float64 = 10000.0
+
+var expressionFieldMap = map[string]func(data_center.KlineItem) float64{
+	"open":     func(item data_center.KlineItem) float64 { return float64(item.Open) / kExpressionPriceUnit },
+	"high":     func(item data_center.KlineItem) float64 { return float64(item.High) / kExpressionPriceUnit },
+	"low":      func(item data_center.KlineItem) float64 { return float64(item.Low) / kExpressionPriceUnit },
+	"close":    func(item data_center.KlineItem) float64 { return float64(item.Close) / kExpressionPriceUnit },
+	"chg":      func(item data_center.KlineItem) float64 { return float64(item.Chg) / kExpressionPriceUnit },
+	"amount":   func(item data_center.KlineItem) float64 { return float64(item.Amount) / kExpressionPriceUnit },
+	"volume":   func(item data_center.KlineItem) float64 { return float64(item.Volume) },
+	"percent":  func(item data_center.KlineItem) float64 { return item.Percent },
+	"turnover": func(item data_center.KlineItem) float64 { return item.TurnoverRate },
+	"rsi6":     func(item data_center.KlineItem) float64 { return item.RSI6 },
+}
+
+var expressionPatternMap = map[string]func([]data_center.KlineItem, int) bool{
+	"hammer":            IsHammerLinePattern,
+	"bullish_engulfing": IsBullishEngulfingPattern,
+	"bearish_engulfing": IsBearishEngulfingPattern,
+	"piercing":          IsPiercingPattern,
+	"morning_star":      IsIsVenusPattern,
+	"harami":            HaramiPattern,
+	"flat_bottom":       FlatBottomPattern,
+}
+
+// 财务数据 金额单位元
+var expressionFundamentalMap = map[string]func(data_center.FundamentalItem) float64{
+	"revenue":    func(item data_center.FundamentalItem) float64 { return float64(item.Revenue) / kExpressionPriceUnit },
+	"net_profit": func(item data_center.FundamentalItem) float64 { return float64(item.NetProfit) / kExpressionPriceUnit },
+	"operating_cash_flow": func(item data_center.FundamentalItem) float64 {
+		return float64(item.OperatingCashFlow) / kExpressionPriceUnit
+	},
+	"roe":            func(item data_center.FundamentalItem) float64 { return item.Roe },
+	"gross_margin":   func(item data_center.FundamentalItem) float64 { return item.GrossMargin },
+	"revenue_growth": func(item data_center.FundamentalItem) float64 { return item.RevenueGrowth },
+	"profit_growth":  func(item data_center.FundamentalItem) float64 { return item.ProfitGrowth },
+	"debt_ratio":     func(item data_center.FundamentalItem) float64 { return item.DebtRatio },
+}
+
+// @func 计算财务数据序列 每根k线取之前已公布的最新报告期
+// @return 与kline_items等长 还没有公布的位置为NaN
+func calculateFundamentalSeries(fundamental_items []data_center.FundamentalItem, kline_items []data_center.KlineItem, field func(data_center.FundamentalItem) float64) []float64 {
+	result := make([]float64, len(kline_items))
+	found := -1
+	j := 0
+	for i, kline_item := range kline_items {
+		for j < len(fundamental_items) && fundamental_items[j].NoticeDate < kline_item.Date {
+			if found < 0 || fundamental_items[j].ReportDate >= fundamental_items[found].ReportDate {
+				found = j
+			}
+			j++
+		}
+		if found < 0 {
+			result[i] = math.NaN()
+		} else {
+			result[i] = field(fundamental_items[found])
+		}
+	}
+	return result
+}
+
+// @func 表达式函数定义
+type expressionFunction struct {
+	arguments   []expressionType // 参数类型
+	constants   []bool           // 参数是否必须是常量
+	integers    []bool           // 常量参数是否必须是整数 周期和k线数必须是整数
+	result_type expressionType
+	description string
+	evaluate    func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue
+}
+
+// @func 取窗口 [index-period+1, index] 不足返回false
+func expressionWindow(index int, period int) (int, int, bool) {
+	start := index - period + 1
+	if start < 0 {
+		return 0, 0, false
+	}
+	return start, index + 1, true
+}
+
+func expressionSeriesFunction(name string, calculate func([]data_center.KlineItem, int) []float64, scale float64, description string) *expressionFunction {
+	return &expressionFunction{
+		arguments:   []expressionType{expressionType_Number},
+		constants:   []bool{true},
+		integers:    []bool{true},
+		result_type: expressionType_Number,
+		description: description,
+		evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+			period := int(node.constants[0])
+			series := frame.getSeries(fmt.Sprintf("%s_%d", name, period), func(kline_items []data_center.KlineItem) []float64 {
+				return calculate(kline_items, period)
+			})
+			return expressionValue{number: series[index] / scale}
+		},
+	}
+}
+
+func expressionWindowFunction(calculate func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64, description string) *expressionFunction {
+	return &expressionFunction{
+		arguments:   []expressionType{expressionType_Number},
+		constants:   []bool{true},
+		integers:    []bool{true},
+		result_type: expressionType_Number,
+		description: description,
+		evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+			start, end, ok := expressionWindow(index, int(node.constants[0]))
+			if !ok {
+				return expressionValue{number: math.NaN()}
+			}
+			return expressionValue{number: calculate(frame.kline_items, start, end, index, node)}
+		},
+	}
+}
+
+var expressionFunctionMap map[string]*expressionFunction
+
+func init() {
+	expressionFunctionMap = map[string]*expressionFunction{
+		"ma":  expressionSeriesFunction("ma", CalculateMaSeries, kExpressionPriceUnit, "ma(n) 收盘价n日均线 单位元"),
+		"ema": expressionSeriesFunction("ema", CalculateEmaSeries, kExpressionPriceUnit, "ema(n) 收盘价n日指数均线 单位元"),
+		"rsi": expressionSeriesFunction("rsi", CalculateRsiSeries, 1.0, "rsi(n) n日RSI"),
+		"highest": expressionWindowFunction(func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64 {
+			high := kline_items[start].High
+			for i := start; i < end; i++ {
+				if kline_items[i].High > high {
+					high = kline_items[i].High
+				}
+			}
+			return float64(high) / kExpressionPriceUnit
+		}, "highest(n) n日最高价 单位元"),
+		"lowest": expressionWindowFunction(func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64 {
+			low := kline_items[start].Low
+			for i := start; i < end; i++ {
+				if kline_items[i].Low < low {
+					low = kline_items[i].Low
+				}
+			}
+			return float64(low) / kExpressionPriceUnit
+		}, "lowest(n) n日最低价 单位元"),
+		"avg_amount": expressionWindowFunction(func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64 {
+			return CalculatePeriodAvgAmount(kline_items, start, end) / kExpressionPriceUnit
+		}, "avg_amount(n) n日平均成交额 单位元"),
+		"volatility": expressionWindowFunction(func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64 {
+			return CalculatePeriodVolatility(kline_items, start, end)
+		}, "volatility(n) n日涨跌幅标准差"),
+		"percentile": expressionSeriesFunction("percentile", CalculateRelativelyPercentSeries, 1.0, "percentile(n) 收盘价在n日内的分位 0-100 相同价格取平均排名"),
+		"to_lowest": expressionWindowFunction(func(kline_items []data_center.KlineItem, start int, end int, index int, node *expressionNode) float64 {
+			return CalculatePeriodToLowestPercent(kline_items, start, end, index)
+		}, "to_lowest(n) 跌到n日最低收盘价需要的跌幅 0-100"),
+		"limit_up_times": {
+			arguments:   []expressionType{expressionType_Number, expressionType_Number},
+			constants:   []bool{true, true},
+			integers:    []bool{true, false},
+			result_type: expressionType_Number,
+			description: "limit_up_times(n, rate) n日内涨幅不低于rate的次数",
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				start, end, ok := expressionWindow(index, int(node.constants[0]))
+				if !ok {
+					return expressionValue{number: math.NaN()}
+				}
+				return expressionValue{number: float64(CalculateStockLimitUpTimes(frame.kline_items, start, end, node.constants[1]))}
+			},
+		},
+		"ref": {
+			arguments:   []expressionType{expressionType_Number, expressionType_Number},
+			constants:   []bool{false, true},
+			integers:    []bool{false, true},
+			result_type: expressionType_Number,
+			description: "ref(x, n) n根k线之前x的值",
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				ref_index := index - int(node.constants[1])
+				if ref_index < 0 || ref_index > index {
+					return expressionValue{number: math.NaN()}
+				}
+				return node.children[0].evaluate(ctx, frame, ref_index)
+			},
+		},
+		"pattern": {
+			arguments:   []expressionType{expressionType_String},
+			constants:   []bool{true},
+			result_type: expressionType_Bool,
+			description: "pattern(name) 是否命中形态 可选 " + strings.Join(sortedExpressionPatternNames(), " "),
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				return expressionValue{boolean: expressionPatternMap[node.children[0].text](frame.kline_items, index)}
+			},
+		},
+		"fundamental": {
+			arguments:   []expressionType{expressionType_String},
+			constants:   []bool{true},
+			result_type: expressionType_Number,
+			description: "fundamental(name) 之前已公布的最新一期财务数据 比例已经乘了100 金额单位元 可选 " + strings.Join(sortedExpressionFundamentalNames(), " "),
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				name := node.children[0].text
+				series := frame.getSeries("fundamental_"+name, func(kline_items []data_center.KlineItem) []float64 {
+					return calculateFundamentalSeries(data_center.GetFundamentalItems(ctx.StockItem), kline_items, expressionFundamentalMap[name])
+				})
+				return expressionValue{number: series[index]}
+			},
+		},
+		"downtrend": {
+			arguments:   []expressionType{expressionType_Number},
+			constants:   []bool{true},
+			integers:    []bool{true},
+			result_type: expressionType_Bool,
+			description: "downtrend(n) 最近n根k线收盘价逐步走低",
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				start, end, ok := expressionWindow(index, int(node.constants[0]))
+				return expressionValue{boolean: ok && IsDowntrend(frame.kline_items, start, end)}
+			},
+		},
+		"uptrend": {
+			arguments:   []expressionType{expressionType_Number},
+			constants:   []bool{true},
+			integers:    []bool{true},
+			result_type: expressionType_Bool,
+			description: "uptrend(n) 最近n根k线收盘价逐步走高",
+			evaluate: func(ctx *ExpressionContext, frame *expressionFrame, index int, node *expressionNode) expressionValue {
+				start, end, ok := expressionWindow(index, int(node.constants[0]))
+				return expressionValue{boolean: ok && IsUptrend(frame.kline_items, start, end)}
+			},
+		},
+	}
+}
+
+func sortedExpressionPatternNames() []string {
+	result := make([]string, 0, len(expressionPatternMap))
+	for name := range expressionPatternMap {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func sortedExpressionFundamentalNames() []string {
+	result := make([]string, 0, len(expressionFundamentalMap))
+	for name := range expressionFundamentalMap {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// @func 类型检查 填充节点类型和函数
+// @in_timeframe 是否已经在周期限定内 不支持嵌套
+func (node *expressionNode) check(in_timeframe bool) error {
+	switch node.kind {
+	case expressionNode_Number:
+		node.value_type = expressionType_Number
+	case expressionNode_String:
+		node.value_type = expressionType_String
+	case expressionNode_Bool:
+		node.value_type = expressionType_Bool
+	case expressionNode_Field:
+		if _, ok := expressionFieldMap[node.name]; !ok {
+			if _, ok := expressionFunctionMap[node.name]; ok {
+				return newExpressionError(node.position, "%s 是函数 需要加括号和参数", node.name)
+			}
+			return newExpressionError(node.position, "未知字段 %s", node.name)
+		}
+		node.value_type = expressionType_Number
+	case expressionNode_Timeframe:
+		if in_timeframe {
+			return newExpressionError(node.position, "不支持嵌套的周期限定 %s", node.name)
+		}
+		if err := node.children[0].check(true); err != nil {
+			return err
+		}
+		node.value_type = node.children[0].value_type
+	case expressionNode_Unary:
+		if err := node.children[0].check(in_timeframe); err != nil {
+			return err
+		}
+		expected := expressionType_Number
+		if node.operator == "not" {
+			expected = expressionType_Bool
+		}
+		if err := expectExpressionType(node.children[0], expected, node.operator); err != nil {
+			return err
+		}
+		node.value_type = expected
+	case expressionNode_Binary:
+		for _, child := range node.children {
+			if err := child.check(in_timeframe); err != nil {
+				return err
+			}
+		}
+		switch node.operator {
+		case "and", "or":
+			node.value_type = expressionType_Bool
+			for _, child := range node.children {
+				if err := expectExpressionType(child, expressionType_Bool, node.operator); err != nil {
+					return err
+				}
+			}
+		case "==", "!=":
+			node.value_type = expressionType_Bool
+			left_type, right_type := node.children[0].value_type, node.children[1].value_type
+			if left_type == expressionType_String || left_type != right_type {
+				return newExpressionError(node.position, "%s 两边类型必须同为数字或布尔 实际是%s和%s", node.operator, expressionTypeNames[left_type], expressionTypeNames[right_type])
+			}
+		case "<", "<=", ">", ">=":
+			node.value_type = expressionType_Bool
+			for _, child := range node.children {
+				if err := expectExpressionType(child, expressionType_Number, node.operator); err != nil {
+					return err
+				}
+			}
+		default:
+			node.value_type = expressionType_Number
+			for _, child := range node.children {
+				if err := expectExpressionType(child, expressionType_Number, node.operator); err != nil {
+					return err
+				}
+			}
+		}
+	case expressionNode_Call:
+		function, ok := expressionFunctionMap[node.name]
+		if !ok {
+			if _, ok := expressionFieldMap[node.name]; ok {
+				return newExpressionError(node.position, "%s 是字段 不能加括号", node.name)
+			}
+			return newExpressionError(node.position, "未知函数 %s", node.name)
+		}
+		if len(node.children) != len(function.arguments) {
+			return newExpressionError(node.position, "%s 需要%d个参数 实际%d个 用法: %s", node.name, len(function.arguments), len(node.children), function.description)
+		}
+
+		node.constants = make([]float64, len(node.children))
+		for i, child := range node.children {
+			if err := child.check(in_timeframe); err != nil {
+				return err
+			}
+			if child.value_type != function.arguments[i] {
+				return newExpressionError(child.position, "%s 第%d个参数需要%s 实际是%s", node.name, i+1, expressionTypeNames[function.arguments[i]], expressionTypeNames[child.value_type])
+			}
+			if !function.constants[i] {
+				continue
+			}
+			if child.kind == expressionNode_String {
+				continue
+			}
+			if child.kind != expressionNode_Number || child.number <= 0 {
+				return newExpressionError(child.position, "%s 第%d个参数必须是正数常量", node.name, i+1)
+			}
+			if i < len(function.integers) && function.integers[i] && child.number != math.Trunc(child.number) {
+				return newExpressionError(child.position, "%s 第%d个参数必须是整数 实际是%v", node.name, i+1, child.number)
+			}
+			node.constants[i] = child.number
+		}
+
+		if node.name == "pattern" {
+			if _, ok := expressionPatternMap[node.children[0].text]; !ok {
+				return newExpressionError(node.children[0].position, "未知形态 %s 可选 %s", node.children[0].text, strings.Join(sortedExpressionPatternNames(), " "))
+			}
+		}
+
+		if node.name == "fundamental" {
+			if _, ok := expressionFundamentalMap[node.children[0].text]; !ok {
+				return newExpressionError(node.children[0].position, "未知财务数据 %s 可选 %s", node.children[0].text, strings.Join(sortedExpressionFundamentalNames(), " "))
+			}
+		}
+
+		node.function = function
+		node.value_type = function.result_type
+	}
+
+	return nil
+}
+
+func expectExpressionType(node *expressionNode, expected expressionType, operator string) error {
+	if node.value_type != expected {
+		return newExpressionError(node.position, "%s 的操作数需要%s 实际是%s", operator, expressionTypeNames[expected], expressionTypeNames[node.value_type])
+	}
+	return nil
+}
+
+// @func 求值 数据不足的指标为NaN 与NaN比较一律为假
+func (node *expressionNode) evaluate(ctx *ExpressionContext, frame *expressionFrame, index int) expressionValue {
+	switch node.kind {
+	case expressionNode_Number:
+		return expressionValue{number: node.number}
+	case expressionNode_Bool:
+		return expressionValue{boolean: node.boolean}
+	case expressionNode_Field:
+		return expressionValue{number: expressionFieldMap[node.name](frame.kline_items[index])}
+	case expressionNode_Timeframe:
+		timeframe_frame := ctx.getFrame(node.timeframe)
+		timeframe_index := index
+		if timeframe_frame != frame {
+			timeframe_index = timeframe_frame.align_index[index]
+		}
+		if timeframe_index < 0 {
+			return expressionValue{number: math.NaN()}
+		}
+		return node.children[0].evaluate(ctx, timeframe_frame, timeframe_index)
+	case expressionNode_Call:
+		return node.function.evaluate(ctx, frame, index, node)
+	case expressionNode_Unary:
+		child := node.children[0].evaluate(ctx, frame, index)
+		if node.operator == "not" {
+			return expressionValue{boolean: !child.boolean}
+		}
+		return expressionValue{number: -child.number}
+	case expressionNode_Binary:
+		left := node.children[0].evaluate(ctx, frame, index)
+		// 短路求值
+		if node.operator == "and" && !left.boolean {
+			return expressionValue{boolean: false}
+		}
+		if node.operator == "or" && left.boolean {
+			return expressionValue{boolean: true}
+		}
+		right := node.children[1].evaluate(ctx, frame, index)
+
+		switch node.operator {
+		case "and", "or":
+			return expressionValue{boolean: right.boolean}
+		case "+":
+			return expressionValue{number: left.number + right.number}
+		case "-":
+			return expressionValue{number: left.number - right.number}
+		case "*":
+			return expressionValue{number: left.number * right.number}
+		case "/":
+			return expressionValue{number: left.number / right.number}
+		}
+
+		if node.children[0].value_type == expressionType_Bool {
+			equal := left.boolean == right.boolean
+			return expressionValue{boolean: equal == (node.operator == "==")}
+		}
+		if math.IsNaN(left.number) || math.IsNaN(right.number) {
+			return expressionValue{boolean: false}
+		}
+		switch node.operator {
+		case "<":
+			return expressionValue{boolean: left.number < right.number}
+		case "<=":
+			return expressionValue{boolean: left.number <= right.number}
+		case ">":
+			return expressionValue{boolean: left.number > right.number}
+		case ">=":
+			return expressionValue{boolean: left.number >= right.number}
+		case "==":
+			return expressionValue{boolean: left.number == right.number}
+		case "!=":
+			return expressionValue{boolean: left.number != right.number}
+		}
+	}
+
+	return expressionValue{}
+}
+
+// @func 编译后的条件表达式
+type Expression struct {
+	Text string
+	root *expressionNode
+}
+
+// @func 解析并检查条件表达式
+// @text 表达式文本 如 close > ma(20) and rsi(6) < 30 and pattern("hammer")
+// @return 表达式 出错时返回*ExpressionError
+func ParseExpression(text string) (*Expression, error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &expressionParser{tokens: tokens}
+	if parser.peek().kind == expressionToken_End {
+		return nil, newExpressionError(0, "表达式为空")
+	}
+
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().kind != expressionToken_End {
+		return nil, newExpressionError(parser.peek().position, "多余的 %s", describeExpressionToken(parser.peek()))
+	}
+
+	if err := root.check(false); err != nil {
+		return nil, err
+	}
+	if root.value_type != expressionType_Bool {
+		return nil, newExpressionError(root.position, "表达式结果需要是布尔 实际是%s", expressionTypeNames[root.value_type])
+	}
+
+	return &Expression{Text: text, root: root}, nil
+}
+
+// @func 判断第index根k线是否满足条件 只使用index及之前的数据
+func (expression *Expression) Evaluate(ctx *ExpressionContext, index int) bool {
+	if index < 0 || index >= len(ctx.base_frame.kline_items) {
+		return false
+	}
+	return expression.root.evaluate(ctx, ctx.base_frame, index).boolean
+}
+
+// @func 表达式支持的字段和函数说明
+func ExpressionHelp() string {
+	result := "字段(价格单位元): "
+	field_names := make([]string, 0, len(expressionFieldMap))
+	for name := range expressionFieldMap {
+		field_names = append(field_names, name)
+	}
+	sort.Strings(field_names)
+	result += strings.Join(field_names, " ") + "\n函数:\n"
+
+	function_names := make([]string, 0, len(expressionFunctionMap))
+	for name := range expressionFunctionMap {
+		function_names = append(function_names, name)
+	}
+	sort.Strings(function_names)
+	for _, name := range function_names {
+		result += "  " + expressionFunctionMap[name].description + "\n"
+	}
+	result += "周期限定: day. week. month. quarter. year. 如 week.rsi(6) < 30\n"
+
+	return result
+}
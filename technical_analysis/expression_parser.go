@@ -0,0 +1,412 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// 条件表达式语法
+// expression := or
+// or         := and (("or" | "||") and)*
+// and        := not (("and" | "&&") not)*
+// not        := ("not" | "!") not | compare
+// compare    := add (("<" | "<=" | ">" | ">=" | "==" | "!=") add)?
+// add        := mul (("+" | "-") mul)*
+// mul        := unary (("*" | "/") unary)*
+// unary      := "-" unary | primary
+// primary    := number | string | "true" | "false" | "(" expression ")"
+//             | identifier | identifier "(" arguments ")" | timeframe "." primary
+// 示例: close > ma(20) and rsi(6) < 30 and pattern("hammer") and week.rsi(6) < 30
+// #开头到行尾为注释
+
+// @func 表达式错误 带出错位置
+type ExpressionError struct {
+	Position int    // 出错的字符位置 从0开始
+	Message  string // 错误描述
+}
+
+func (err *ExpressionError) Error() string {
+	return fmt.Sprintf("表达式第%d个字符: %s", err.Position+1, err.Message)
+}
+
+func newExpressionError(position int, format string, args ...interface{}) *ExpressionError {
+	return &ExpressionError{Position: position, Message: fmt.Sprintf(format, args...)}
+}
+
+type expressionTokenKind = int
+
+const (
+	expressionToken_End        expressionTokenKind = 0
+	expressionToken_Number     expressionTokenKind = 1
+	expressionToken_String     expressionTokenKind = 2
+	expressionToken_Identifier expressionTokenKind = 3
+	expressionToken_Operator   expressionTokenKind = 4
+)
+
+type expressionToken struct {
+	kind     expressionTokenKind
+	text     string
+	number   float64
+	position int
+}
+
+// @func 词法分析
+func tokenizeExpression(text string) ([]expressionToken, error) {
+	result := make([]expressionToken, 0)
+	runes := []rune(text)
+	runes_len := len(runes)
+
+	for i := 0; i < runes_len; {
+		c := runes[i]
+
+		if unicode.IsSpace(c) {
+			i++
+			continue
+		}
+
+		// 注释到行尾
+		if c == '#' {
+			for i < runes_len && runes[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		start := i
+		if unicode.IsDigit(c) || (c == '.' && i+1 < runes_len && unicode.IsDigit(runes[i+1])) {
+			for i < runes_len && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// 科学计数法 1e7
+			if i < runes_len && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < runes_len && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < runes_len && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			number_text := string(runes[start:i])
+			number, err := strconv.ParseFloat(number_text, 64)
+			if err != nil {
+				return nil, newExpressionError(start, "非法数字 %s", number_text)
+			}
+			result = append(result, expressionToken{kind: expressionToken_Number, text: number_text, number: number, position: start})
+			continue
+		}
+
+		if c == '"' || c == '\'' {
+			i++
+			for i < runes_len && runes[i] != c {
+				i++
+			}
+			if i >= runes_len {
+				return nil, newExpressionError(start, "字符串缺少结束引号")
+			}
+			result = append(result, expressionToken{kind: expressionToken_String, text: string(runes[start+1 : i]), position: start})
+			i++
+			continue
+		}
+
+		if unicode.IsLetter(c) || c == '_' {
+			for i < runes_len && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			result = append(result, expressionToken{kind: expressionToken_Identifier, text: strings.ToLower(string(runes[start:i])), position: start})
+			continue
+		}
+
+		if i+1 < runes_len {
+			two := string(runes[i : i+2])
+			if two == "<=" || two == ">=" || two == "==" || two == "!=" || two == "&&" || two == "||" {
+				result = append(result, expressionToken{kind: expressionToken_Operator, text: two, position: start})
+				i += 2
+				continue
+			}
+		}
+
+		if strings.ContainsRune("()+-*/<>!,.", c) {
+			result = append(result, expressionToken{kind: expressionToken_Operator, text: string(c), position: start})
+			i++
+			continue
+		}
+
+		return nil, newExpressionError(start, "非法字符 %q", c)
+	}
+
+	result = append(result, expressionToken{kind: expressionToken_End, position: runes_len})
+
+	return result, nil
+}
+
+type expressionNodeKind = int
+
+const (
+	expressionNode_Number    expressionNodeKind = 0 // 数字常量
+	expressionNode_String    expressionNodeKind = 1 // 字符串常量
+	expressionNode_Bool      expressionNodeKind = 2 // 布尔常量
+	expressionNode_Field     expressionNodeKind = 3 // k线字段 close volume
+	expressionNode_Call      expressionNodeKind = 4 // 函数调用 ma(20)
+	expressionNode_Unary     expressionNodeKind = 5 // 一元运算 - not
+	expressionNode_Binary    expressionNodeKind = 6 // 二元运算
+	expressionNode_Timeframe expressionNodeKind = 7 // 周期限定 week.rsi(6)
+)
+
+type expressionNode struct {
+	kind      expressionNodeKind
+	position  int
+	operator  string            // 运算符 一元和二元运算使用
+	name      string            // 字段名 函数名 周期名
+	number    float64           // 数字常量
+	text      string            // 字符串常量
+	boolean   bool              // 布尔常量
+	children  []*expressionNode // 子节点 函数参数
+	timeframe data_center.KlineType
+
+	// 以下由类型检查填充
+	value_type expressionType
+	function   *expressionFunction
+	constants  []float64 // 函数的常量参数
+}
+
+var expressionTimeframeMap = map[string]data_center.KlineType{
+	"day":     data_center.KlineType_Day,
+	"week":    data_center.KlineType_Week,
+	"month":   data_center.KlineType_Month,
+	"quarter": data_center.KlineType_Quarter,
+	"year":    data_center.KlineType_Year,
+}
+
+type expressionParser struct {
+	tokens []expressionToken
+	index  int
+}
+
+func (parser *expressionParser) peek() expressionToken {
+	return parser.tokens[parser.index]
+}
+
+func (parser *expressionParser) next() expressionToken {
+	token := parser.tokens[parser.index]
+	if token.kind != expressionToken_End {
+		parser.index++
+	}
+	return token
+}
+
+// @func 当前token是否是指定的运算符或关键字
+func (parser *expressionParser) match(texts ...string) bool {
+	token := parser.peek()
+	if token.kind != expressionToken_Operator && token.kind != expressionToken_Identifier {
+		return false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			return true
+		}
+	}
+	return false
+}
+
+func (parser *expressionParser) expect(text string) error {
+	token := parser.peek()
+	if token.kind != expressionToken_Operator || token.text != text {
+		return newExpressionError(token.position, "期望 %s 实际是 %s", text, describeExpressionToken(token))
+	}
+	parser.next()
+	return nil
+}
+
+func describeExpressionToken(token expressionToken) string {
+	if token.kind == expressionToken_End {
+		return "表达式结尾"
+	}
+	if token.kind == expressionToken_String {
+		return fmt.Sprintf("%q", token.text)
+	}
+	return token.text
+}
+
+func (parser *expressionParser) parseOr() (*expressionNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.match("or", "||") {
+		token := parser.next()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expressionNode{kind: expressionNode_Binary, position: token.position, operator: "or", children: []*expressionNode{left, right}}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseAnd() (*expressionNode, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for parser.match("and", "&&") {
+		token := parser.next()
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &expressionNode{kind: expressionNode_Binary, position: token.position, operator: "and", children: []*expressionNode{left, right}}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseNot() (*expressionNode, error) {
+	if parser.match("not", "!") {
+		token := parser.next()
+		child, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &expressionNode{kind: expressionNode_Unary, position: token.position, operator: "not", children: []*expressionNode{child}}, nil
+	}
+	return parser.parseCompare()
+}
+
+func (parser *expressionParser) parseCompare() (*expressionNode, error) {
+	left, err := parser.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if parser.match("<", "<=", ">", ">=", "==", "!=") {
+		token := parser.next()
+		right, err := parser.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expressionNode{kind: expressionNode_Binary, position: token.position, operator: token.text, children: []*expressionNode{left, right}}
+		if parser.match("<", "<=", ">", ">=", "==", "!=") {
+			return nil, newExpressionError(parser.peek().position, "比较运算不能连用 请用and连接")
+		}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseAdd() (*expressionNode, error) {
+	left, err := parser.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for parser.match("+", "-") {
+		token := parser.next()
+		right, err := parser.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = &expressionNode{kind: expressionNode_Binary, position: token.position, operator: token.text, children: []*expressionNode{left, right}}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseMul() (*expressionNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for parser.match("*", "/") {
+		token := parser.next()
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &expressionNode{kind: expressionNode_Binary, position: token.position, operator: token.text, children: []*expressionNode{left, right}}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseUnary() (*expressionNode, error) {
+	if parser.match("-") {
+		token := parser.next()
+		child, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &expressionNode{kind: expressionNode_Unary, position: token.position, operator: "-", children: []*expressionNode{child}}, nil
+	}
+	return parser.parsePrimary()
+}
+
+func (parser *expressionParser) parsePrimary() (*expressionNode, error) {
+	token := parser.next()
+
+	switch token.kind {
+	case expressionToken_Number:
+		return &expressionNode{kind: expressionNode_Number, position: token.position, number: token.number}, nil
+	case expressionToken_String:
+		return &expressionNode{kind: expressionNode_String, position: token.position, text: token.text}, nil
+	case expressionToken_Identifier:
+		if token.text == "true" || token.text == "false" {
+			return &expressionNode{kind: expressionNode_Bool, position: token.position, boolean: token.text == "true"}, nil
+		}
+		if token.text == "and" || token.text == "or" || token.text == "not" {
+			return nil, newExpressionError(token.position, "%s 缺少操作数", token.text)
+		}
+
+		// 周期限定 week.rsi(6)
+		if parser.match(".") {
+			timeframe, ok := expressionTimeframeMap[token.text]
+			if !ok {
+				return nil, newExpressionError(token.position, "未知周期 %s 可选 day week month quarter year", token.text)
+			}
+			parser.next()
+			child, err := parser.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return &expressionNode{kind: expressionNode_Timeframe, position: token.position, name: token.text, timeframe: timeframe, children: []*expressionNode{child}}, nil
+		}
+
+		// 函数调用
+		if parser.match("(") {
+			parser.next()
+			node := &expressionNode{kind: expressionNode_Call, position: token.position, name: token.text}
+			if parser.match(")") {
+				parser.next()
+				return node, nil
+			}
+			for {
+				argument, err := parser.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				node.children = append(node.children, argument)
+				if parser.match(",") {
+					parser.next()
+					continue
+				}
+				if err := parser.expect(")"); err != nil {
+					return nil, err
+				}
+				return node, nil
+			}
+		}
+
+		return &expressionNode{kind: expressionNode_Field, position: token.position, name: token.text}, nil
+	case expressionToken_Operator:
+		if token.text == "(" {
+			node, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+
+	return nil, newExpressionError(token.position, "意外的 %s", describeExpressionToken(token))
+}
@@ -0,0 +1,172 @@
+package technical_analysis
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// 两周的日k线 2024-01-01到2024-01-12的工作日 收盘价依次为1到10元
+func newExpressionDayKlineItems() []data_center.KlineItem {
+	dates := []string{"20240101", "20240102", "20240103", "20240104", "20240105", "20240108", "20240109", "20240110", "20240111", "20240112"}
+	kline_items := make([]data_center.KlineItem, len(dates))
+	for i, date := range dates {
+		price := int64(i+1) * int64(kExpressionPriceUnit)
+		kline_items[i] = data_center.KlineItem{Date: date, Open: price, High: price, Low: price, Close: price, Volume: 100}
+	}
+	return kline_items
+}
+
+// 测试不访问网络 周k线直接放进上下文
+func newExpressionTestContext() *ExpressionContext {
+	day_kline_items := newExpressionDayKlineItems()
+	week_kline_items := []data_center.KlineItem{
+		{Date: "20240105", Open: 1 * int64(kExpressionPriceUnit), High: 5 * int64(kExpressionPriceUnit), Low: 1 * int64(kExpressionPriceUnit), Close: 5 * int64(kExpressionPriceUnit), Volume: 500},
+		{Date: "20240112", Open: 6 * int64(kExpressionPriceUnit), High: 10 * int64(kExpressionPriceUnit), Low: 6 * int64(kExpressionPriceUnit), Close: 10 * int64(kExpressionPriceUnit), Volume: 500},
+	}
+
+	ctx := NewExpressionContext(data_center.StockItem{Symbol: "SH600000"}, data_center.KlineType_Day, day_kline_items)
+	ctx.frames[data_center.KlineType_Week] = &expressionFrame{
+		kline_type:   data_center.KlineType_Week,
+		kline_items:  week_kline_items,
+		align_index:  AlignKlineItems(day_kline_items, week_kline_items, data_center.KlineType_Week),
+		series_cache: make(map[string][]float64),
+	}
+	return ctx
+}
+
+func TestExpressionPrecedence(t *testing.T) {
+	cases := []struct {
+		text string
+		want bool
+	}{
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"8 / 4 / 2 == 1", true},
+		{"-2 * 3 == -6", true},
+		{"2 - -1 == 3", true},
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"not false and false", false},
+		{"not 1 > 2", true},
+		{"close > 9 and close < 11", true},
+		{"close - ref(close, 1) == 1", true},
+		{"ma(2) == 9.5", true},
+	}
+
+	ctx := newExpressionTestContext()
+	for _, c := range cases {
+		expression, err := ParseExpression(c.text)
+		if err != nil {
+			t.Fatalf("%s: %v", c.text, err)
+		}
+		if got := expression.Evaluate(ctx, 9); got != c.want {
+			t.Errorf("%s: got %v want %v", c.text, got, c.want)
+		}
+	}
+}
+
+// 周期限定只能看到已经走完的周k线
+func TestExpressionTimeframe(t *testing.T) {
+	cases := []struct {
+		text  string
+		index int
+		want  bool
+	}{
+		{"week.close > 0", 3, false}, // 第一周还没走完
+		{"week.close == 5", 4, true}, // 下一根日k线进入新的一周 第一周已经走完
+		{"week.close == 5", 9, true}, // 最后一根日k线无法确认周期走完
+		{"week.close == 10", 9, false},
+		{"week.high - week.low == 4", 6, true},
+		{"day.close == 7", 6, true},
+		{"WEEK.close == 5", 6, true},
+	}
+
+	ctx := newExpressionTestContext()
+	for _, c := range cases {
+		expression, err := ParseExpression(c.text)
+		if err != nil {
+			t.Fatalf("%s: %v", c.text, err)
+		}
+		if got := expression.Evaluate(ctx, c.index); got != c.want {
+			t.Errorf("%s at %d: got %v want %v", c.text, c.index, got, c.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	cases := []struct {
+		text     string
+		position int // 出错的字符位置
+		message  string
+	}{
+		// 未知的函数 字段 形态 周期
+		{"foo(3) > 1", 0, "未知函数 foo"},
+		{"close > foo(3)", 8, "未知函数 foo"},
+		{"close > bar", 8, "未知字段 bar"},
+		{"ma > 1", 0, "是函数"},
+		{"close(1) > 1", 0, "是字段"},
+		{`close > 1 and pattern("nope")`, 22, "未知形态 nope"},
+		{`fundamental("nope") > 1`, 12, "未知财务数据 nope"},
+		{"minute.close > 1", 0, "未知周期 minute"},
+		{"week.month.close > 1", 5, "不支持嵌套的周期限定"},
+
+		// 类型不匹配
+		{"close", 0, "表达式结果需要是布尔"},
+		{"close + true > 1", 8, "的操作数需要数字"},
+		{"not close", 4, "的操作数需要布尔"},
+		{"close and true", 0, "的操作数需要布尔"},
+		{"close == true", 6, "两边类型必须同为数字或布尔"},
+		{`"a" == "a"`, 4, "两边类型必须同为数字或布尔"},
+		{`pattern("hammer") > 1`, 0, "的操作数需要数字"},
+		{`ma("x") > 1`, 3, "第1个参数需要数字"},
+		{"ma(1, 2) > 1", 0, "需要1个参数"},
+
+		// 常量参数
+		{"ma(close) > 1", 3, "必须是正数常量"},
+		{"ma(0) > 1", 3, "必须是正数常量"},
+		{"ma(2.5) > 1", 3, "必须是整数"},
+		{"week.rsi(6.5) < 30", 9, "必须是整数"},
+		{"ref(close, 1.5) > 1", 11, "必须是整数"},
+		{"downtrend(2.5)", 10, "必须是整数"},
+
+		// 语法
+		{"", 0, "表达式为空"},
+		{"close > 1 close", 10, "多余的"},
+		{"1 < 2 < 3", 6, "比较运算不能连用"},
+		{"(close > 1", 10, "期望 )"},
+		{"close > 1 @", 10, "非法字符"},
+		{`pattern("hammer`, 8, "字符串缺少结束引号"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseExpression(c.text)
+		var expression_err *ExpressionError
+		if !errors.As(err, &expression_err) {
+			t.Errorf("%s: got %v want *ExpressionError", c.text, err)
+			continue
+		}
+		if expression_err.Position != c.position {
+			t.Errorf("%s: got position %d want %d (%s)", c.text, expression_err.Position, c.position, expression_err.Message)
+		}
+		if !strings.Contains(expression_err.Message, c.message) {
+			t.Errorf("%s: got message %q want %q", c.text, expression_err.Message, c.message)
+		}
+	}
+}
+
+func TestExpressionIntegerConstant(t *testing.T) {
+	// 涨幅阈值可以是小数
+	if _, err := ParseExpression("limit_up_times(5, 9.5) > 0"); err != nil {
+		t.Errorf("limit_up_times(5, 9.5): %v", err)
+	}
+	if _, err := ParseExpression("limit_up_times(5.5, 9.5) > 0"); err == nil {
+		t.Errorf("limit_up_times(5.5, 9.5): want error")
+	}
+	if _, err := ParseExpression("ma(2.0) > 1"); err != nil {
+		t.Errorf("ma(2.0): %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 5年价格分位因子 100减去收盘价在最近5年的分位 越大表示越接近低点
+func newLowPercentileFactor(days int) func(data_center.StockItem, []data_center.KlineItem, int) float64 {
+	return func(stock_item data_center.StockItem, kline_items []data_center.KlineItem, index int) float64 {
+		if index+1 < days {
+			return math.NaN()
+		}
+		return 100.0 - CalculatePeriodRelativelyPercent(kline_items, index+1-days, index+1, index)
+	}
+}
+
+// @func 龙虎榜净买因子 调仓日之前days个交易日龙虎榜净买入合计除以成交额合计 没有上榜为0
+// 龙虎榜在当天收盘后公布 调仓日收盘时还不知道当天的榜单 所以不含调仓日
+// @lhb_net_buy 日期 -> 股票代号 -> 当天龙虎榜净买入 单位毫
+func newLhbNetBuyFactor(days int, lhb_net_buy map[string]map[string]int64) func(data_center.StockItem, []data_center.KlineItem, int) float64 {
+	return func(stock_item data_center.StockItem, kline_items []data_center.KlineItem, index int) float64 {
+		if index < days {
+			return math.NaN()
+		}
+		net_buy_sum, amount_sum := 0.0, 0.0
+		for i := index - days; i < index; i++ {
+			net_buy_sum += float64(lhb_net_buy[kline_items[i].Date][stock_item.Symbol])
+			amount_sum += float64(kline_items[i].Amount)
+		}
+		if amount_sum <= 0 {
+			return math.NaN()
+		}
+		return net_buy_sum / amount_sum * 100.0
+	}
+}
+
+// @func 截面因子研究 全A股从2018年起每20个交易日计算因子 申万二级行业中性化 输出IC序列 IC衰减和分组收益
+// 交易日历使用-b指定的基准
+// 行业成分和股票池都是当前的快照 历史调仓日使用的是今天的行业归属 且不含已退市的股票 结果有一定的幸存者偏差
+func StartFactorResearch() {
+	fmt.Println("StartFactorResearch")
+
+	const kStartDate string = "20180101"
+	const kPercentileDays int = 250 * 5 // 价格分位的统计交易日
+	const kLhbDays int = 20             // 龙虎榜净买的统计交易日
+	const kNeutralize bool = true       // 是否行业中性化
+
+	_, benchmark_kline_items, ok := loadBenchmarkKlineItems()
+	if !ok {
+		return
+	}
+	calendar := make([]string, len(benchmark_kline_items))
+	for i, kline_item := range benchmark_kline_items {
+		calendar[i] = kline_item.Date
+	}
+
+	industries := make(map[string]string)
+	if kNeutralize {
+		for _, industry_item := range data_center.GetWholeIndustryStockItems() {
+			for _, stock_item := range data_center.GetIndexContainStockItems(industry_item) {
+				industries[stock_item.Symbol] = industry_item.Name
+			}
+		}
+	}
+
+	// 预先加载龙虎榜 避免各协程重复请求同一天
+	lhb_dates := make([]string, 0)
+	for i, date := range calendar {
+		if date >= kStartDate || (i+kLhbDays < len(calendar) && calendar[i+kLhbDays] >= kStartDate) {
+			lhb_dates = append(lhb_dates, date)
+		}
+	}
+	lhb_stock_items := make([][]data_center.LhbStockItem, len(lhb_dates))
+	backtest.ParallelFor(len(lhb_dates), backtest_workers, backtest.NewProgress("龙虎榜", len(lhb_dates)), func(index int) {
+		lhb_stock_items[index] = data_center.GetLhbStockItems(lhb_dates[index])
+	})
+	lhb_net_buy := make(map[string]map[string]int64, len(lhb_dates))
+	for i, date := range lhb_dates {
+		lhb_net_buy[date] = make(map[string]int64)
+		for _, lhb_item := range lhb_stock_items[i] {
+			lhb_net_buy[date][lhb_item.Stock.Symbol] += lhb_item.NetBuyTotal
+		}
+	}
+
+	factors := []struct {
+		name  string
+		value func(data_center.StockItem, []data_center.KlineItem, int) float64
+	}{
+		{"low_percentile_5y", newLowPercentileFactor(kPercentileDays)},
+		{"lhb_net_buy_20d", newLhbNetBuyFactor(kLhbDays, lhb_net_buy)},
+	}
+
+	stock_items := data_center.GetWholeStockItems()
+	for _, factor := range factors {
+		config := backtest.DefaultFactorConfig()
+		config.Name = factor.name
+		config.Value = factor.value
+		config.StartDate = kStartDate
+		config.Industries = industries
+		config.Workers = backtest_workers
+		config.ShowProgress = true
+		config.ReleaseKlineItems = true
+
+		result := backtest.RunFactorResearch(config, stock_items, calendar)
+		fmt.Printf("\n因子 %s IC序列:\n", factor.name)
+		fmt.Println("日期 股票数 IC RankIC 多空收益")
+		for _, period := range result.Periods {
+			fmt.Printf("%s %d %.2f%% %.2f%% %.2f%%\n", period.Date, period.Stocks, period.Ic, period.RankIc, period.LongShort)
+		}
+		fmt.Println(result)
+	}
+}
@@ -0,0 +1,128 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 场内基金每天的溢价率
+type PremiumItem struct {
+	Date    string
+	Close   int64   // 收盘价 单位毫
+	Nav     int64   // 单位净值 单位毫
+	Premium float64 // 溢价率 负数为折价 已经乘了100
+}
+
+// @func 按日期对齐收盘价和净值计算溢价率序列 没有净值的交易日跳过
+// @return 按照时间顺序
+func CalculatePremiumSeries(kline_items []data_center.KlineItem, nav_items []data_center.FundNavItem) []PremiumItem {
+	nav_map := make(map[string]int64, len(nav_items))
+	for _, nav_item := range nav_items {
+		nav_map[nav_item.Date] = nav_item.Nav
+	}
+
+	result := make([]PremiumItem, 0, len(nav_items))
+	for _, kline_item := range kline_items {
+		nav, ok := nav_map[kline_item.Date]
+		if !ok || nav <= 0 || kline_item.Close <= 0 {
+			continue
+		}
+		result = append(result, PremiumItem{
+			Date:    kline_item.Date,
+			Close:   kline_item.Close,
+			Nav:     nav,
+			Premium: (float64(kline_item.Close)/float64(nav) - 1.0) * 100.0,
+		})
+	}
+
+	return result
+}
+
+// @func 单只基金的溢价异常
+type premiumAnomaly struct {
+	stock_item data_center.StockItem
+	latest     PremiumItem
+	avg        float64 // 历史溢价率均值 不含最新一天
+	std        float64 // 历史溢价率标准差 不含最新一天
+	z_score    float64 // 最新溢价率偏离均值几个标准差
+	avg_amount float64 // 日均成交额 单位毫
+}
+
+// @func 折溢价分析 最新溢价率偏离历史均值超过N个标准差的ETF/LOF 按偏离程度排序
+// 场内基金没有历史IOPV 使用当天公布的单位净值
+func StartEtfLofPremiumAnalysis() {
+	fmt.Println("StartEtfLofPremiumAnalysis")
+
+	const kHistoryDays uint64 = 250             // 统计的净值天数
+	const kMinHistoryDays int = 60              // 至少有多少天的溢价率
+	const kStdCount float64 = 2.0               // 偏离的标准差个数
+	const kMinTradeAmount float64 = 1e6 * 10000 // 日均成交额下限 单位毫
+	const kMinAvgTradeDays int = 20             // 日均成交额的统计天数
+
+	stock_items := append(data_center.GetWholeEtfStockItems(), data_center.GetWholeLofStockItems()...)
+	anomalies := make([]*premiumAnomaly, len(stock_items))
+	backtest.ParallelFor(len(stock_items), backtest_workers, backtest.NewProgress("折溢价分析", len(stock_items)), func(index int) {
+		stock_item := stock_items[index]
+		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, stock_item, kHistoryDays+uint64(kMinAvgTradeDays))
+		kline_items_len := len(kline_items)
+		if kline_items_len < kMinAvgTradeDays {
+			return
+		}
+		avg_amount := CalculatePeriodAvgAmount(kline_items, kline_items_len-kMinAvgTradeDays, kline_items_len)
+		if avg_amount < kMinTradeAmount {
+			return
+		}
+
+		premium_items := CalculatePremiumSeries(kline_items, data_center.GetFundNavItems(stock_item, kHistoryDays))
+		premium_items_len := len(premium_items)
+		if premium_items_len < kMinHistoryDays {
+			return
+		}
+
+		history := make([]float64, premium_items_len-1)
+		for i := 0; i < premium_items_len-1; i++ {
+			history[i] = premium_items[i].Premium
+		}
+		std := backtest.CalculateStd(history)
+		if std <= 0 {
+			return
+		}
+		avg := CalculateArraySum(history) / float64(len(history))
+		latest := premium_items[premium_items_len-1]
+
+		z_score := (latest.Premium - avg) / std
+		if math.Abs(z_score) < kStdCount {
+			return
+		}
+		anomalies[index] = &premiumAnomaly{
+			stock_item: stock_item,
+			latest:     latest,
+			avg:        avg,
+			std:        std,
+			z_score:    z_score,
+			avg_amount: avg_amount,
+		}
+	})
+
+	result := make([]*premiumAnomaly, 0)
+	for _, anomaly := range anomalies {
+		if anomaly != nil {
+			result = append(result, anomaly)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return math.Abs(result[i].z_score) > math.Abs(result[j].z_score)
+	})
+
+	fmt.Printf("\n最近%d天溢价率偏离超过%.1f个标准差:\n", kHistoryDays, kStdCount)
+	fmt.Println("名称(代号) 日期 收盘价 单位净值 溢价率 历史均值 历史标准差 偏离 日均成交额(万)")
+	for _, anomaly := range result {
+		fmt.Printf("%s(%s) %s %.3f %.4f %.2f%% %.2f%% %.2f%% %.2f %.0f\n", anomaly.stock_item.Name, anomaly.stock_item.Symbol, anomaly.latest.Date,
+			backtest.PriceToYuan(anomaly.latest.Close), backtest.PriceToYuan(anomaly.latest.Nav), anomaly.latest.Premium, anomaly.avg, anomaly.std, anomaly.z_score,
+			anomaly.avg_amount/10000/10000)
+	}
+}
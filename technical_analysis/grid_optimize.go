@@ -0,0 +1,141 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 网格参数范围 每格涨跌幅 上下界幅度 每格金额
+func gridParameterRanges() []backtest.ParameterRange {
+	return []backtest.ParameterRange{
+		{Name: "step", Values: []float64{0.005, 0.01, 0.015, 0.02, 0.03}},
+		{Name: "band", Values: []float64{0.05, 0.1, 0.15, 0.2}},
+		{Name: "grid_money", Values: []float64{5000, 10000, 20000}},
+	}
+}
+
+// @func 按参数生成网格配置 总资金为每格金额乘格数再加上预留现金
+// @center_price 网格中心价 单位毫
+func newGridConfigWithParameters(parameters backtest.Parameters, center_price int64, fill_model backtest.FillModel) backtest.GridConfig {
+	config := backtest.NewGridConfig(backtest.GridType_Geometric, center_price, parameters["step"], parameters["band"], 0.0)
+	config.Budget = parameters["grid_money"] * float64(config.GridCount) / (1.0 - config.Reserve)
+	config.FillModel = fill_model
+	return config
+}
+
+// @func 单只基金的网格推荐
+type gridRecommendation struct {
+	stock_item      data_center.StockItem
+	train           backtest.OptimizationRun
+	holdout         backtest.Metrics
+	holdout_result  backtest.Result
+	holdout_config  backtest.GridConfig
+	holdout_score   float64
+	budget          float64 // 资金需求 单位元
+	grid_count      int
+	trades_by_month float64 // 检验期月均交易次数
+}
+
+// @func 网格参数优化 每只活跃的ETF/LOF在训练期搜索每格涨跌幅 上下界幅度和每格金额 在检验期验证 按检验期得分排名
+func StartEtfLofGridOptimize() {
+	fmt.Println("StartEtfLofGridOptimize")
+
+	const kTrainDays int = 250                  // 训练期交易日
+	const kHoldoutDays int = 60                 // 检验期交易日 紧接训练期 到最近一天
+	const kMonthTradeDays float64 = 21          // 每月交易日
+	const kMinTradeAmount float64 = 1e5 * 10000 // 日均成交额下限 单位毫
+	const kMinAvgTradeDays int = 20             // 日均成交额的统计天数
+	const kExportCount int = 10                 // 导出排名前几的检验期结果
+
+	objective, ok := backtest.MetricObjective(optimize_metric)
+	if !ok {
+		fmt.Printf("不支持的指标 %s\n", optimize_metric)
+		return
+	}
+
+	// 网格价加5个基点滑点 单日最多成交当日成交量的10%
+	fill_model := backtest.VolumeParticipationFill{Rate: 0.1, Inner: backtest.FixedBpsSlippage{Bps: 5}}
+	parameter_sets := backtest.GridSearchParameters(gridParameterRanges())
+
+	stock_items := append(data_center.GetWholeEtfStockItems(), data_center.GetWholeLofStockItems()...)
+	recommendations := make([]*gridRecommendation, len(stock_items))
+	backtest.ParallelFor(len(stock_items), backtest_workers, backtest.NewProgress("网格优化", len(stock_items)), func(index int) {
+		stock_item := stock_items[index]
+		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, stock_item, 250*14)
+		kline_items_len := len(kline_items)
+		if kline_items_len < kTrainDays+kHoldoutDays {
+			return
+		}
+		if CalculatePeriodAvgAmount(kline_items, kline_items_len-kMinAvgTradeDays, kline_items_len) < kMinTradeAmount {
+			return
+		}
+
+		minute_kline_items := getGridMinuteKlineItems(stock_item, kTrainDays+kHoldoutDays)
+
+		// 日期转换为索引 网格中心取区间第一天的开盘价
+		date_index := make(map[string]int, kline_items_len)
+		for i, kline_item := range kline_items {
+			date_index[kline_item.Date] = i
+		}
+		runner := func(parameters backtest.Parameters, start_date string, end_date string) backtest.Result {
+			start := date_index[start_date]
+			end := kline_items_len
+			if len(end_date) > 0 {
+				end = date_index[end_date]
+			}
+			config := newGridConfigWithParameters(parameters, kline_items[start].Open, fill_model)
+			return backtest.SimulateGrid(stock_item, kline_items, minute_kline_items, start, end, config)
+		}
+
+		holdout_start := kline_items_len - kHoldoutDays
+		train_start := holdout_start - kTrainDays
+		runs := backtest.Optimize(parameter_sets, runner, objective, kline_items[train_start].Date, kline_items[holdout_start].Date, 1, nil)
+		if len(runs) <= 0 {
+			return
+		}
+
+		best := runs[0]
+		holdout_result := runner(best.Parameters, kline_items[holdout_start].Date, "")
+		holdout := backtest.CalculateResultMetrics(holdout_result)
+		config := newGridConfigWithParameters(best.Parameters, kline_items[holdout_start].Open, fill_model)
+		recommendations[index] = &gridRecommendation{
+			stock_item:      stock_item,
+			train:           best,
+			holdout:         holdout,
+			holdout_result:  holdout_result,
+			holdout_config:  config,
+			holdout_score:   objective(holdout),
+			budget:          config.Budget,
+			grid_count:      config.GridCount,
+			trades_by_month: float64(holdout.TradeCount) / (float64(kHoldoutDays) / kMonthTradeDays),
+		}
+	})
+
+	result := make([]*gridRecommendation, 0)
+	for _, recommendation := range recommendations {
+		if recommendation != nil {
+			result = append(result, recommendation)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].holdout_score > result[j].holdout_score
+	})
+
+	fmt.Printf("\n训练期%d天 检验期%d天 按检验期%s排名:\n", kTrainDays, kHoldoutDays, optimize_metric)
+	fmt.Println("排名 名称(代号) 每格涨跌幅 上下界幅度 格数 每格金额 资金需求 训练得分 检验得分 检验收益率 检验最大回撤 月均交易次数")
+	for i, recommendation := range result {
+		parameters := recommendation.train.Parameters
+		fmt.Printf("%d %s(%s) %.2f%% %.0f%% %d %.0f %.0f %.2f %.2f %.2f%% %.2f%% %.1f\n", i+1, recommendation.stock_item.Name, recommendation.stock_item.Symbol,
+			parameters["step"]*100.0, parameters["band"]*100.0, recommendation.grid_count, parameters["grid_money"], recommendation.budget,
+			recommendation.train.Score, recommendation.holdout_score, recommendation.holdout.TotalReturn, recommendation.holdout.MaxDrawdown, recommendation.trades_by_month)
+	}
+
+	for i := 0; i < len(result) && i < kExportCount; i++ {
+		recommendation := result[i]
+		parameters := map[string]string{"rank": fmt.Sprint(i + 1), "metric": optimize_metric, "parameters": recommendation.train.Parameters.String(), "holdout_days": fmt.Sprint(kHoldoutDays)}
+		exportResultWithConfig("grid_optimize_"+recommendation.stock_item.Symbol, parameters, backtest.GridConfigParameters(recommendation.holdout_config), 1, recommendation.holdout_result)
+	}
+}
@@ -0,0 +1,12 @@
+package technical_analysis
+
+import (
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 获取多档网格引擎使用的5分钟k线 一天48根 接口拿不到时返回空 引擎会按日k线近似
+// @days 最近多少个交易日
+func getGridMinuteKlineItems(stock_item data_center.StockItem, days int) []data_center.KlineItem {
+	const kMinuteBarsPerDay int = 48
+	return data_center.GetKlineItems(data_center.KlineType_5Min, stock_item, uint64(days*kMinuteBarsPerDay))
+}
@@ -0,0 +1,136 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 计算日期所属的周期编号 同一周期内编号相同 周期越晚编号越大
+// @kline_type 周期类型 日k及以下按天计算
+// @date 字符串日期 20240523 分钟k只取前8位
+// @return 周期编号 日期非法返回-1
+func CalculateKlinePeriodKey(kline_type data_center.KlineType, date string) int64 {
+	if len(date) < 8 {
+		return -1
+	}
+
+	date_time, err := time.ParseInLocation("20060102", date[0:8], time.Local)
+	if err != nil {
+		return -1
+	}
+
+	year := int64(date_time.Year())
+	month := int64(date_time.Month())
+	switch kline_type {
+	case data_center.KlineType_Week:
+		iso_year, iso_week := date_time.ISOWeek()
+		return int64(iso_year)*100 + int64(iso_week)
+	case data_center.KlineType_Month:
+		return year*100 + month
+	case data_center.KlineType_Quarter:
+		return year*10 + (month-1)/3 + 1
+	case data_center.KlineType_Year:
+		return year
+	default:
+		return year*10000 + month*100 + int64(date_time.Day())
+	}
+}
+
+// @func 将高周期k线对齐到低周期k线 只使用已经走完的高周期k线 避免未来函数
+// @base_kline_items 低周期k线 如日k
+// @higher_kline_items 高周期k线 如周k月k
+// @higher_kline_type 高周期类型
+// @return 与base_kline_items等长的高周期索引 -1表示当时还没有走完的高周期k线
+func AlignKlineItems(base_kline_items []data_center.KlineItem, higher_kline_items []data_center.KlineItem, higher_kline_type data_center.KlineType) []int {
+	base_kline_items_len := len(base_kline_items)
+	align_index := make([]int, base_kline_items_len)
+
+	higher_index := -1
+	for i := 0; i < base_kline_items_len; i++ {
+		period_key := CalculateKlinePeriodKey(higher_kline_type, base_kline_items[i].Date)
+
+		// 下一根低周期k线进入新周期才说明当前周期走完 只看日期不看价格
+		// 最后一根低周期k线无法确认周期是否走完 保守处理为未走完
+		period_closed := i+1 < base_kline_items_len && CalculateKlinePeriodKey(higher_kline_type, base_kline_items[i+1].Date) != period_key
+
+		for higher_index+1 < len(higher_kline_items) {
+			higher_period_key := CalculateKlinePeriodKey(higher_kline_type, higher_kline_items[higher_index+1].Date)
+			if higher_period_key < period_key || (higher_period_key == period_key && period_closed) {
+				higher_index++
+			} else {
+				break
+			}
+		}
+
+		align_index[i] = higher_index
+	}
+
+	return align_index
+}
+
+// @func 获取多周期对齐的k线
+// @stock_item 股票
+// @base_kline_type 低周期类型
+// @higher_kline_type 高周期类型
+// @base_count 低周期数据总量
+// @higher_count 高周期数据总量
+// @return 低周期k线 高周期k线 对齐索引
+func GetMultiTimeframeKlineItems(stock_item data_center.StockItem, base_kline_type data_center.KlineType, higher_kline_type data_center.KlineType, base_count uint64, higher_count uint64) ([]data_center.KlineItem, []data_center.KlineItem, []int) {
+	base_kline_items := data_center.GetKlineItems(base_kline_type, stock_item, base_count)
+	higher_kline_items := data_center.GetKlineItems(higher_kline_type, stock_item, higher_count)
+
+	return base_kline_items, higher_kline_items, AlignKlineItems(base_kline_items, higher_kline_items, higher_kline_type)
+}
+
+// @func 获取低周期某根k线可见的最近一根已走完的高周期k线
+// @return 高周期k线 是否存在
+func GetHigherTimeframeKlineItem(higher_kline_items []data_center.KlineItem, align_index []int, index int) (data_center.KlineItem, bool) {
+	if index < 0 || index >= len(align_index) || align_index[index] < 0 {
+		return data_center.KlineItem{}, false
+	}
+
+	return higher_kline_items[align_index[index]], true
+}
+
+// @func 在高周期上判断形态 只能看到低周期当时已走完的高周期k线
+// @higher_kline_items 高周期k线
+// @align_index 对齐索引
+// @index 低周期判定k线元素索引
+// @match 形态判断函数 如IsHammerLinePattern
+// @return 是否命中
+func MatchHigherTimeframe(higher_kline_items []data_center.KlineItem, align_index []int, index int, match func([]data_center.KlineItem, int) bool) bool {
+	if index < 0 || index >= len(align_index) || align_index[index] < 0 {
+		return false
+	}
+
+	higher_index := align_index[index]
+	return match(higher_kline_items[0:higher_index+1], higher_index)
+}
+
+// @func 多周期选股 周线RSI6超卖且日线锤子线
+func StartMultiTimeframeSelectStock() {
+	fmt.Println("StartMultiTimeframeSelectStock")
+
+	const kMaxWeekRSI6 float64 = 30.0
+
+	whole_stock_items := data_center.GetWholeStockItems()
+	for _, iter := range whole_stock_items {
+		kline_items, week_kline_items, align_index := GetMultiTimeframeKlineItems(iter, data_center.KlineType_Day, data_center.KlineType_Week, 250*14, 50*14)
+		kline_items_len := len(kline_items)
+		if kline_items_len <= 0 {
+			continue
+		}
+
+		index := kline_items_len - 1
+		week_kline_item, ok := GetHigherTimeframeKlineItem(week_kline_items, align_index, index)
+		if !ok || week_kline_item.RSI6 >= kMaxWeekRSI6 {
+			continue
+		}
+
+		if IsHammerLinePattern(kline_items, index) {
+			fmt.Printf("%s(%s) day %s week %s RSI6 %f\n", iter.Name, iter.Symbol, kline_items[index].Date, week_kline_item.Date, week_kline_item.RSI6)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package technical_analysis
+
+import (
+	"fmt"
+
+	"github.com/hsuloong/stock_speculation/backtest"
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 启明星策略的参数范围
+func morningStarParameterRanges() []backtest.ParameterRange {
+	return []backtest.ParameterRange{
+		{Name: "hold_days", Min: 1, Max: 10, Step: 1},
+		{Name: "first_body_rate", Min: 0.02, Max: 0.05, Step: 0.01},
+		{Name: "second_body_rate", Min: 0.005, Max: 0.02, Step: 0.005},
+		{Name: "penetration_rate", Min: 0.3, Max: 0.7, Step: 0.1},
+		{Name: "third_body_rate", Min: 0.02, Max: 0.05, Step: 0.01},
+	}
+}
+
+// @func 按参数创建启明星策略
+func newMorningStarStrategyWithParameters(parameters backtest.Parameters, money_per_trade float64) *MorningStarStrategy {
+	strategy := NewMorningStarStrategy(int(parameters["hold_days"]), money_per_trade)
+	strategy.Parameters.FirstBodyRate = parameters["first_body_rate"]
+	strategy.Parameters.SecondBodyRate = parameters["second_body_rate"]
+	strategy.Parameters.PenetrationRate = parameters["penetration_rate"]
+	strategy.Parameters.ThirdBodyRate = parameters["third_body_rate"]
+	return strategy
+}
+
+// @func 启明星策略参数优化 随机搜索全区间排名 再做滚动样本内优化样本外检验
+func StartOptimizeBacktesting() {
+	fmt.Println("StartOptimizeBacktesting")
+
+	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额
+	const kSearchCount int = 30              // 随机搜索的参数组数
+	const kSearchSeed int64 = 20100101       // 随机种子 保证结果可复现
+	const kStartDate string = "20100101"
+	const kInSampleDays int = 250 * 3 // 样本内交易日
+	const kOutSampleDays int = 250    // 样本外交易日
+	const kTopRuns int = 10           // 打印排名前几的参数
+
+	objective, ok := backtest.MetricObjective(optimize_metric)
+	if !ok {
+		fmt.Printf("不支持的指标 %s\n", optimize_metric)
+		return
+	}
+
+	whole_stock_items := data_center.GetWholeStockItems()
+	newConfig := func(start_date string, end_date string) backtest.Config {
+		config := backtest.DefaultConfig()
+		config.StartDate = start_date
+		config.EndDate = end_date
+		config.Workers = backtest_workers
+		return config
+	}
+	runner := func(parameters backtest.Parameters, start_date string, end_date string) backtest.Result {
+		config := newConfig(start_date, end_date)
+		return backtest.NewEngine(config).RunParallel(func() backtest.Strategy {
+			return newMorningStarStrategyWithParameters(parameters, kMaxMoneyPerTrade)
+		}, whole_stock_items)
+	}
+
+	// 参数组之间串行 每次回测内部按股票并行
+	parameter_sets := backtest.RandomSearchParameters(morningStarParameterRanges(), kSearchCount, kSearchSeed)
+	runs := backtest.Optimize(parameter_sets, runner, objective, kStartDate, "", 1, backtest.NewProgress("参数优化", len(parameter_sets)))
+
+	fmt.Printf("\n按%s排名:\n", optimize_metric)
+	for i := 0; i < len(runs) && i < kTopRuns; i++ {
+		fmt.Printf("%d. %s 得分 %f 年化收益率 %f 最大回撤 %f 交易次数 %d\n", i+1, runs[i].Parameters, runs[i].Score, runs[i].Metrics.Cagr, runs[i].Metrics.MaxDrawdown, runs[i].Metrics.TradeCount)
+	}
+
+	// 优化结果只保留指标 导出时用最优参数重新回测一次
+	if len(output_dir) > 0 && len(runs) > 0 {
+		best_parameters := map[string]string{"metric": optimize_metric, "parameters": runs[0].Parameters.String(), "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade)}
+		exportBacktestResult("optimize_best", best_parameters, newConfig(kStartDate, ""), len(whole_stock_items), runner(runs[0].Parameters, kStartDate, ""))
+	}
+
+	// 交易日取基准的k线日期
+	benchmark, ok := FindBenchmarkStockItem(benchmark_text)
+	if !ok {
+		fmt.Printf("没有找到基准 %s 无法滚动优化\n", benchmark_text)
+		return
+	}
+	dates := make([]string, 0)
+	for _, kline_item := range data_center.GetKlineItems(data_center.KlineType_Day, benchmark, 250*14) {
+		if kline_item.Date >= kStartDate {
+			dates = append(dates, kline_item.Date)
+		}
+	}
+
+	walk_forward := backtest.WalkForward(parameter_sets, runner, objective, dates, kInSampleDays, kOutSampleDays, 1)
+	fmt.Printf("\n滚动优化 样本内%d天 样本外%d天:\n", kInSampleDays, kOutSampleDays)
+	for _, window := range walk_forward.Windows {
+		fmt.Printf("%s-%s 最优 %s 样本内得分 %f 样本外得分 %f\n", window.InSampleStart, window.InSampleEnd, window.Best.Parameters, window.Best.Score, objective(window.OutSample))
+	}
+	fmt.Printf("样本外拼接:\n%s\n样本外与样本内得分比 %f\n", walk_forward.Metrics, walk_forward.Efficiency)
+
+	walk_forward_parameters := map[string]string{"metric": optimize_metric, "in_sample_days": fmt.Sprint(kInSampleDays), "out_sample_days": fmt.Sprint(kOutSampleDays), "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade)}
+	exportBacktestResult("optimize_walk_forward", walk_forward_parameters, newConfig(kStartDate, ""), len(whole_stock_items), walk_forward.Result())
+}
@@ -1,86 +1,185 @@
-package technic_alanalysis
+package technical_analysis
 
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/hsuloong/stock_speculation/backtest"
 	"github.com/hsuloong/stock_speculation/data_center"
 )
 
 func StartBacktesting() {
 	fmt.Println("StartBacktesting")
+	startBacktesting("backtesting", map[string]string{"pattern": "morning_star"}, func(stock_item data_center.StockItem, kline_items []data_center.KlineItem) func(int) bool {
+		return func(index int) bool {
+			return IsIsVenusPattern(kline_items, index)
+		}
+	})
+}
+
+// @func 按条件表达式回测
+// @expression_text 条件表达式 如 close > ma(20) and rsi(6) < 30
+func StartBacktestingWithExpression(expression_text string) {
+	fmt.Println("StartBacktestingWithExpression")
+	expression, err := ParseExpression(expression_text)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Print(ExpressionHelp())
+		return
+	}
+
+	startBacktesting("backtesting_expression", map[string]string{"expression": expression_text}, func(stock_item data_center.StockItem, kline_items []data_center.KlineItem) func(int) bool {
+		ctx := NewExpressionContext(stock_item, data_center.KlineType_Day, kline_items)
+		return func(index int) bool {
+			return expression.Evaluate(ctx, index)
+		}
+	})
+}
+
+// @func 回测 买入点为命中次日开盘 卖出点为之后多个交易日的收盘
+// 按A股股票默认规则成交 一字涨停或停牌买不进的跳过 一字跌停或停牌卖不出的顺延到下一个交易日收盘
+// 每只股票只加载一次 多个协程并行 现金流按信号年份记到各自的账本 每个卖点导出一次结果
+// @name 导出的名称
+// @parameters 策略参数 写入导出的清单
+// @new_matcher 为每只股票创建命中判断函数
+func startBacktesting(name string, parameters map[string]string, new_matcher func(data_center.StockItem, []data_center.KlineItem) func(int) bool) {
 
 	const kMaxSellDays int = 10              // 卖出距离买入日
-	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额
-	const kTax float64 = 0.2 / 100.0         // 交易费率 简单处理下
-
-	for year, year_gap := 2010, 1; year <= time.Now().Local().Year(); year += year_gap {
-		year_begin := year
-		year_end := year + year_gap
-		fmt.Printf("\nFrom Year %d To %d:\n", year, year+year_gap)
-
-		win := make([]uint64, kMaxSellDays)
-		loss := make([]uint64, kMaxSellDays)
-		account_money := make([][20351231]float64, kMaxSellDays)
-
-		whole_stock_items := data_center.GetWholeStockItems()
-		for _, iter := range whole_stock_items {
-			kline_items := data_center.GetKlineItems(data_center.KlineType_Day, iter.Symbol, 250*14)
-			kline_items_len := len(kline_items)
-
-			for i := 0; i < kline_items_len; i++ {
-				kline_item_date, _ := strconv.Atoi(kline_items[i].Date)
-				kline_item_year := kline_item_date / 10000
-
-				// 按照年来回测
-				if kline_item_year < year_begin || kline_item_year >= year_end {
-					continue
-				}
+	const kMaxMoneyPerTrade float64 = 100000 // 单次交易金额 按整手向下取整
+	const kStartYear int = 2010
 
-				// 判断命中条件
-				is_match := IsIsVenusPattern(kline_items, i)
-				if !is_match {
-					continue
-				}
+	rules := backtest.DefaultStockTradingRules()
+
+	type yearStat struct {
+		win     []uint64
+		loss    []uint64
+		ledgers []*backtest.Ledger // 每个卖点一个账本
+	}
+
+	end_year := time.Now().Local().Year()
+	year_stats := make([]yearStat, end_year-kStartYear+1)
+	for i := range year_stats {
+		year_stats[i].win = make([]uint64, kMaxSellDays)
+		year_stats[i].loss = make([]uint64, kMaxSellDays)
+	}
+
+	// 每只股票的命中 按股票顺序记账 结果与协程数无关
+	whole_stock_items := data_center.GetWholeStockItems()
+	stock_matches := make([][]backtestMatch, len(whole_stock_items))
+	progress := backtest.NewProgress("回测", len(whole_stock_items))
+	backtest.ParallelFor(len(whole_stock_items), backtest_workers, progress, func(index int) {
+		iter := whole_stock_items[index]
+		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, iter, 250*14)
+		kline_items_len := len(kline_items)
+		matcher := new_matcher(iter, kline_items)
 
-				// 最后一个item无法计算买卖点
-				if i+1 >= kline_items_len {
-					continue
+		// 最后一个item无法计算买卖点
+		for i := 0; i+1 < kline_items_len; i++ {
+			kline_item_date, _ := strconv.Atoi(kline_items[i].Date)
+			kline_item_year := kline_item_date / 10000
+
+			// 按照年来回测
+			if kline_item_year < kStartYear || kline_item_year > end_year {
+				continue
+			}
+
+			// 判断命中条件
+			is_match := matcher(i)
+			if !is_match {
+				continue
+			}
+
+			buy_index := i + 1 // 买入点 | 开盘买入
+			buy_price := kline_items[buy_index].Open
+			if !rules.CanBuy(iter, kline_items[buy_index], buy_price) {
+				continue
+			}
+			quantity := rules.AffordableQuantity(kMaxMoneyPerTrade, buy_price)
+			if quantity <= 0 {
+				continue
+			}
+
+			// 遍历多个卖点 卖不出时顺延
+			match := backtestMatch{stock_item: iter, signal_date: kline_items[i].Date, year: kline_item_year, buy_price: buy_price, quantity: quantity}
+			last_index := buy_index
+			for j := 0; j < kMaxSellDays; j++ {
+				sell_index := i + j + 2 // 卖出点 | 收盘卖出
+				for sell_index < kline_items_len && !rules.CanSell(iter, kline_items[sell_index], kline_items[sell_index].Close) {
+					sell_index++
+				}
+				if sell_index >= kline_items_len {
+					break
 				}
+				match.exits = append(match.exits, sell_index-buy_index)
+				if sell_index > last_index {
+					last_index = sell_index
+				}
+			}
+
+			// 买入日到最后一个卖出日的收盘价 用于导出资金曲线和持仓
+			for j := buy_index; j <= last_index; j++ {
+				match.dates = append(match.dates, kline_items[j].Date)
+				match.closes = append(match.closes, kline_items[j].Close)
+			}
+			stock_matches[index] = append(stock_matches[index], match)
+		}
 
-				buy_index := i + 1 // 买入点 | 开盘买入
-				buy_date, _ := strconv.Atoi(kline_items[buy_index].Date)
-
-				// 遍历多个卖点
-				for j := 0; j < kMaxSellDays; j++ {
-					sell_index := i + j + 2 // 卖出点 | 收盘卖出
-					if sell_index >= kline_items_len {
-						break
-					}
-					sell_date, _ := strconv.Atoi(kline_items[sell_index].Date)
-					rate := float64(kline_items[sell_index].Close) / float64(kline_items[buy_index].Open)
-
-					// 记录胜率
-					if rate > 1.0 {
-						win[j]++
-					} else {
-						loss[j]++
-					}
-
-					// 记录交易金额
-					account_money[j][buy_date] -= kMaxMoneyPerTrade * (1.0 + kTax)
-					account_money[j][sell_date] += kMaxMoneyPerTrade * rate * (1.0 - kTax)
+		// 该股票已经回测完 释放k线缓存
+		data_center.ReleaseKlineItems(iter)
+	})
+
+	// 所有买卖日期组成交易日历 账本按日历中的位置记账 所有账本共用
+	signal_dates := make([]string, 0)
+	for _, matches := range stock_matches {
+		for _, match := range matches {
+			signal_dates = append(signal_dates, match.dates[0])
+			for _, exit := range match.exits {
+				signal_dates = append(signal_dates, match.dates[exit])
+			}
+		}
+	}
+	calendar := backtest.NewTradingCalendar(signal_dates)
+	for i := range year_stats {
+		year_stats[i].ledgers = make([]*backtest.Ledger, kMaxSellDays)
+		for j := 0; j < kMaxSellDays; j++ {
+			year_stats[i].ledgers[j] = backtest.NewLedger(calendar)
+		}
+	}
+
+	for _, matches := range stock_matches {
+		for _, match := range matches {
+			stat := &year_stats[match.year-kStartYear]
+			for j := range match.exits {
+				buy_value, sell_value := match.values(j + 1)
+				cost := buy_value + rules.Fees.Calculate(backtest.OrderSide_Buy, buy_value)
+				proceeds := sell_value - rules.Fees.Calculate(backtest.OrderSide_Sell, sell_value)
+
+				// 记录胜率 扣除费用后计算
+				if proceeds > cost {
+					stat.win[j]++
+				} else {
+					stat.loss[j]++
 				}
+
+				// 记录交易金额
+				stat.ledgers[j].Add(match.dates[0], -cost)
+				stat.ledgers[j].Add(match.dates[match.exits[j]], proceeds)
 			}
 		}
+	}
+
+	for year := kStartYear; year <= end_year; year++ {
+		fmt.Printf("\nFrom Year %d To %d:\n", year, year+1)
+		stat := year_stats[year-kStartYear]
 
 		// 计算胜率
 		win_rate := make([]float64, kMaxSellDays)
 		for i := 0; i < kMaxSellDays; i++ {
-			if win[i]+loss[i] > 0 {
-				win_rate[i] = float64(win[i]) / float64(win[i]+loss[i]) * 100.0
+			if stat.win[i]+stat.loss[i] > 0 {
+				win_rate[i] = float64(stat.win[i]) / float64(stat.win[i]+stat.loss[i]) * 100.0
 			}
 		}
 
@@ -89,23 +188,134 @@ func StartBacktesting() {
 		profit := make([]float64, kMaxSellDays)
 		profit_rate := make([]float64, kMaxSellDays)
 		for i := 0; i < kMaxSellDays; i++ {
-			invest_money[i] = (-MinSubArraySum(account_money[i][0:]))
-			for j := 0; j < len(account_money[i]); j++ {
-				profit[i] += account_money[i][j]
-			}
-			if invest_money[i] > 1.0e-3 {
-				profit_rate[i] = profit[i] / invest_money[i] * 100.0
-			}
+			invest_money[i] = stat.ledgers[i].InvestedCapital()
+			profit[i] = stat.ledgers[i].Profit()
+			profit_rate[i] = stat.ledgers[i].ProfitRate()
 		}
 
 		// 打印结果
-		fmt.Printf("胜次数: %v\n", win)
-		fmt.Printf("败次数: %v\n", loss)
+		fmt.Printf("胜次数: %v\n", stat.win)
+		fmt.Printf("败次数: %v\n", stat.loss)
 		fmt.Printf("胜率: %v\n", win_rate)
 		fmt.Printf("本金投入: %v\n", invest_money)
 		fmt.Printf("收益: %v\n", profit)
 		fmt.Printf("收益率: %v\n", profit_rate)
 	}
+
+	config_parameters := map[string]string{"trading_rules": fmt.Sprintf("%+v", rules)}
+	for j := 0; j < kMaxSellDays; j++ {
+		hold_parameters := map[string]string{"hold_days": strconv.Itoa(j + 1), "money_per_trade": fmt.Sprint(kMaxMoneyPerTrade), "start_year": strconv.Itoa(kStartYear)}
+		for key, value := range parameters {
+			hold_parameters[key] = value
+		}
+		result := newHoldDaysResult(stock_matches, j+1, rules.Fees)
+		exportResultWithConfig(fmt.Sprintf("%s_hold%d", name, j+1), hold_parameters, config_parameters, len(whole_stock_items), result)
+	}
+}
+
+// @func 一次命中 买入日开盘价和之后每天的收盘价
+type backtestMatch struct {
+	stock_item  data_center.StockItem
+	signal_date string
+	year        int      // 命中的年份 用于分年统计
+	buy_price   int64    // 买入日开盘价 单位毫
+	quantity    int64    // 买入股数 整手
+	dates       []string // 买入日到最后一个卖出日的交易日
+	closes      []int64  // 对应的收盘价 单位毫
+	exits       []int    // 第j+1个卖点实际卖出在dates中的位置 跌停或停牌时顺延 之后都卖不出的卖点没有
+}
+
+// @func 按持有天数计算买入和卖出的成交金额
+// @hold_days 第几个卖点 从1开始
+// @return 买入金额 卖出金额 单位元 不含费用
+func (match backtestMatch) values(hold_days int) (float64, float64) {
+	buy_value := float64(match.quantity) * backtest.PriceToYuan(match.buy_price)
+	sell_value := float64(match.quantity) * backtest.PriceToYuan(match.closes[match.exits[hold_days-1]])
+	return buy_value, sell_value
+}
+
+// @func 按持有天数生成固定金额 不限制资金的回测结果 用于导出
+// 买入日开盘买入 第hold_days个交易日收盘卖出 卖不出时顺延 持仓市值按股数和收盘价计算
+// @stock_matches 每只股票的命中 按股票顺序
+// @fees 交易费用
+func newHoldDaysResult(stock_matches [][]backtestMatch, hold_days int, fees backtest.FeeSchedule) backtest.Result {
+	dates := make([]string, 0)
+	for _, matches := range stock_matches {
+		for _, match := range matches {
+			if len(match.exits) >= hold_days {
+				dates = append(dates, match.dates[:match.exits[hold_days-1]+1]...)
+			}
+		}
+	}
+	calendar := backtest.NewTradingCalendar(dates)
+	flows := make([]float64, len(calendar))
+	values := make([]float64, len(calendar))
+	holdings := make([][]backtest.Holding, len(calendar))
+
+	result := backtest.Result{}
+	for _, matches := range stock_matches {
+		for _, match := range matches {
+			if len(match.exits) < hold_days {
+				continue
+			}
+			exit := match.exits[hold_days-1]
+			buy_value, sell_value := match.values(hold_days)
+			entry_fee := fees.Calculate(backtest.OrderSide_Buy, buy_value)
+			exit_fee := fees.Calculate(backtest.OrderSide_Sell, sell_value)
+			profit := sell_value - exit_fee - buy_value - entry_fee
+			result.Trades = append(result.Trades, backtest.Trade{
+				Stock:       match.stock_item,
+				SignalDate:  match.signal_date,
+				EntryDate:   match.dates[0],
+				ExitDate:    match.dates[exit],
+				EntryPrice:  match.buy_price,
+				ExitPrice:   match.closes[exit],
+				Quantity:    match.quantity,
+				Fee:         entry_fee + exit_fee,
+				Profit:      profit,
+				ReturnRate:  profit / (buy_value + entry_fee) * 100.0,
+				HoldingBars: exit,
+				ExitReason:  "hold",
+			})
+
+			flows[sort.SearchStrings(calendar, match.dates[0])] -= buy_value + entry_fee
+			flows[sort.SearchStrings(calendar, match.dates[exit])] += sell_value - exit_fee
+			for d := 0; d < exit; d++ {
+				k := sort.SearchStrings(calendar, match.dates[d])
+				value := float64(match.quantity) * backtest.PriceToYuan(match.closes[d])
+				values[k] += value
+				// 同一只股票的命中连续处理 重叠持有时合并为一条持仓
+				last := len(holdings[k]) - 1
+				if last >= 0 && holdings[k][last].Stock.Symbol == match.stock_item.Symbol {
+					holdings[k][last].Quantity += match.quantity
+					holdings[k][last].Value += value
+				} else {
+					holdings[k] = append(holdings[k], backtest.Holding{Stock: match.stock_item, Quantity: match.quantity, Value: value})
+				}
+			}
+		}
+	}
+	sort.SliceStable(result.Trades, func(i, j int) bool {
+		return result.Trades[i].ExitDate < result.Trades[j].ExitDate
+	})
+
+	cash := 0.0
+	for k, date := range calendar {
+		cash += flows[k]
+		sort.Slice(holdings[k], func(i, j int) bool {
+			return holdings[k][i].Stock.Symbol < holdings[k][j].Stock.Symbol
+		})
+		result.Equity = append(result.Equity, backtest.EquityPoint{
+			Date:          date,
+			Cash:          cash,
+			PositionValue: values[k],
+			Equity:        cash + values[k],
+			Positions:     len(holdings[k]),
+			Holdings:      holdings[k],
+		})
+	}
+
+	return result
 }
 
 func MinSubArraySum(array []float64) float64 {
@@ -127,15 +337,45 @@ func MinSubArraySum(array []float64) float64 {
 
 func StartSelectStock() {
 	fmt.Println("StartSelectStock")
+	startSelectStock(func(stock_item data_center.StockItem, kline_items []data_center.KlineItem) func(int) bool {
+		return func(index int) bool {
+			return IsBullishEngulfingPattern(kline_items, index)
+		}
+	})
+}
+
+// @func 按条件表达式选股
+// @expression_text 条件表达式 如 close > ma(20) and rsi(6) < 30 and pattern("hammer")
+func StartSelectStockWithExpression(expression_text string) {
+	fmt.Println("StartSelectStockWithExpression")
+	expression, err := ParseExpression(expression_text)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Print(ExpressionHelp())
+		return
+	}
+
+	startSelectStock(func(stock_item data_center.StockItem, kline_items []data_center.KlineItem) func(int) bool {
+		ctx := NewExpressionContext(stock_item, data_center.KlineType_Day, kline_items)
+		return func(index int) bool {
+			return expression.Evaluate(ctx, index)
+		}
+	})
+}
+
+// @func 选股 判断最后一个交易日是否命中
+// @new_matcher 为每只股票创建命中判断函数
+func startSelectStock(new_matcher func(data_center.StockItem, []data_center.KlineItem) func(int) bool) {
 	whole_stock_items := data_center.GetWholeStockItems()
 
 	for _, iter := range whole_stock_items {
-		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, iter.Symbol, 250*14)
+		kline_items := data_center.GetKlineItems(data_center.KlineType_Day, iter, 250*14)
 
 		kline_items_len := len(kline_items)
+		matcher := new_matcher(iter, kline_items)
 
 		for i := kline_items_len - 1; i < kline_items_len; i++ {
-			is_match := IsBullishEngulfingPattern(kline_items, i)
+			is_match := matcher(i)
 			if is_match {
 				fmt.Printf("%s %d day %s\n", iter.Name, i, kline_items[i].Date)
 			}
@@ -156,6 +396,19 @@ func IsDowntrend(kline_items []data_center.KlineItem, start int, end int) bool {
 	return true
 }
 
+func IsUptrend(kline_items []data_center.KlineItem, start int, end int) bool {
+	if start < 0 || end > len(kline_items) {
+		return false
+	}
+
+	for i := start + 1; i < end; i++ {
+		if kline_items[i].Close < kline_items[i-1].Close {
+			return false
+		}
+	}
+	return true
+}
+
 // @func 判断是否是锤子线
 // @kline_items k线数组
 // @index 判定日期的数组索引
@@ -225,6 +478,41 @@ func IsBullishEngulfingPattern(kline_items []data_center.KlineItem, index int) b
 	return true
 }
 
+// @func 是否是看跌吞没
+// @kline_items k线数组
+// @index 判定k线元素索引
+// @return 是否是看跌吞没
+func IsBearishEngulfingPattern(kline_items []data_center.KlineItem, index int) bool {
+	if index < 1 {
+		return false
+	}
+
+	first_kline_item := kline_items[index-1]
+	second_kline_item := kline_items[index]
+
+	// 第一根阳线
+	if first_kline_item.Close < first_kline_item.Open {
+		return false
+	}
+
+	// 第二根阴线
+	if second_kline_item.Close > second_kline_item.Open {
+		return false
+	}
+
+	// 实体吞没
+	if second_kline_item.EntityHigh <= first_kline_item.EntityHigh || second_kline_item.EntityLow >= first_kline_item.EntityLow {
+		return false
+	}
+
+	// 上升趋势
+	if !IsUptrend(kline_items, index-5, index) {
+		return false
+	}
+
+	return true
+}
+
 // @func 是否是刺透形态
 // @kline_items k线数组
 // @index 判定k线元素索引
@@ -260,11 +548,40 @@ func IsPiercingPattern(kline_items []data_center.KlineItem, index int) bool {
 	return true
 }
 
+// @func 启明星形态的可调参数
+type VenusPatternParameters struct {
+	FirstBodyRate   float64 // 第一根长阴线实体最小比例
+	SecondBodyRate  float64 // 第二根小实体最大比例
+	PenetrationRate float64 // 第三根阳线推进到第一根实体内部的最小比例
+	ThirdBodyRate   float64 // 第三根长阳线实体最小比例
+	DowntrendDays   int     // 之前下降趋势的k线数
+}
+
+// @func 启明星形态默认参数
+func DefaultVenusPatternParameters() VenusPatternParameters {
+	return VenusPatternParameters{
+		FirstBodyRate:   0.03,
+		SecondBodyRate:  0.01,
+		PenetrationRate: 0.3,
+		ThirdBodyRate:   0.03,
+		DowntrendDays:   5,
+	}
+}
+
 // @func 是否是启明星形态
 // @kline_items k线数组
 // @index 判定k线元素索引
 // @return 是否是启明星形态
 func IsIsVenusPattern(kline_items []data_center.KlineItem, index int) bool {
+	return IsVenusPatternWithParameters(kline_items, index, DefaultVenusPatternParameters())
+}
+
+// @func 按参数判断是否是启明星形态
+// @kline_items k线数组
+// @index 判定k线元素索引
+// @parameters 形态阈值
+// @return 是否是启明星形态
+func IsVenusPatternWithParameters(kline_items []data_center.KlineItem, index int, parameters VenusPatternParameters) bool {
 	if index < 2 {
 		return false
 	}
@@ -279,7 +596,7 @@ func IsIsVenusPattern(kline_items []data_center.KlineItem, index int) bool {
 	}
 
 	// 第一根长阴线 | 参数可调
-	if float64(first_kline_item.EntityHigh-first_kline_item.EntityLow)/float64(first_kline_item.EntityLow) < 0.03 {
+	if float64(first_kline_item.EntityHigh-first_kline_item.EntityLow)/float64(first_kline_item.EntityLow) < parameters.FirstBodyRate {
 		return false
 	}
 
@@ -289,7 +606,7 @@ func IsIsVenusPattern(kline_items []data_center.KlineItem, index int) bool {
 	}
 
 	// 第二根小实体 | 参数可调
-	if float64(second_kline_item.EntityHigh-second_kline_item.EntityLow)/float64(second_kline_item.EntityLow) > 0.01 {
+	if float64(second_kline_item.EntityHigh-second_kline_item.EntityLow)/float64(second_kline_item.EntityLow) > parameters.SecondBodyRate {
 		return false
 	}
 
@@ -304,17 +621,17 @@ func IsIsVenusPattern(kline_items []data_center.KlineItem, index int) bool {
 	}
 
 	// 第三根阳线推进到第一个内部 | 参数可调
-	if float64(third_kline_item.EntityHigh-first_kline_item.EntityLow)/float64(first_kline_item.EntityHigh-first_kline_item.EntityLow) < 0.3 {
+	if float64(third_kline_item.EntityHigh-first_kline_item.EntityLow)/float64(first_kline_item.EntityHigh-first_kline_item.EntityLow) < parameters.PenetrationRate {
 		return false
 	}
 
 	// 第三根长阳线 | 参数可调
-	if float64(third_kline_item.EntityHigh-third_kline_item.EntityLow)/float64(third_kline_item.EntityLow) < 0.03 {
+	if float64(third_kline_item.EntityHigh-third_kline_item.EntityLow)/float64(third_kline_item.EntityLow) < parameters.ThirdBodyRate {
 		return false
 	}
 
 	// 下降趋势
-	if !IsDowntrend(kline_items, index-5, index) {
+	if !IsDowntrend(kline_items, index-parameters.DowntrendDays, index) {
 		return false
 	}
 
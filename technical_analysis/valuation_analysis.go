@@ -0,0 +1,115 @@
+package technical_analysis
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hsuloong/stock_speculation/data_center"
+)
+
+// @func 计算估值的历史分位 分位定义同CalculatePeriodRelativelyPercent 0和负数表示没有数据或者亏损 不参与
+// @return 0-100之间 目标没有数据时为NaN
+func CalculatePeriodValuationPercent(values []float64, start int, end int, target int) float64 {
+	if values[target] <= 0 {
+		return math.NaN()
+	}
+
+	less, equal, count := 0, 0, 0
+	for i := start; i < end; i++ {
+		if values[i] <= 0 {
+			continue
+		}
+		count++
+		if values[i] < values[target] {
+			less++
+		} else if values[i] == values[target] {
+			equal++
+		}
+	}
+
+	return calculatePercentRank(less, equal, count)
+}
+
+// @func 估值分析 指数和行业板块的滚动市盈率 市净率 股息率在最近5年 10年的分位
+// 5年市盈率和市净率分位都不超过kMaxRate时输出 股息率分位越高越便宜
+// 接口只有蛋卷基金覆盖的主要指数的市盈率和市净率 行业板块和股息率必须由本地估值文件提供 缺失的在最后列出
+func StartValuationAnalysis() {
+	const kMaxRate float64 = 20.0
+	const kTargetYear int = 5
+
+	missing_valuation := make([]string, 0) // 没有估值数据 被跳过的
+	missing_dividend := make([]string, 0)  // 有估值但是没有股息率的
+
+	target_years := []int{kTargetYear, 10}
+	whole_stock_items := append(data_center.GetWholeIndexStockItems(), data_center.GetWholeIndustryStockItems()...)
+	for _, iter := range whole_stock_items {
+		valuation_items := data_center.GetValuationItems(iter)
+		valuation_items_len := len(valuation_items)
+		if valuation_items_len <= 0 {
+			missing_valuation = append(missing_valuation, fmt.Sprintf("%s(%s)", iter.Name, iter.Symbol))
+			continue
+		}
+
+		pe_values := make([]float64, valuation_items_len)
+		pb_values := make([]float64, valuation_items_len)
+		dividend_values := make([]float64, valuation_items_len)
+		for i, valuation_item := range valuation_items {
+			pe_values[i] = valuation_item.PeTtm
+			pb_values[i] = valuation_item.Pb
+			dividend_values[i] = valuation_item.DividendYield
+		}
+
+		last := valuation_items_len - 1
+		if dividend_values[last] <= 0 {
+			missing_dividend = append(missing_dividend, fmt.Sprintf("%s(%s)", iter.Name, iter.Symbol))
+		}
+		last_date, err := time.ParseInLocation("20060102", valuation_items[last].Date, time.Local)
+		if err != nil {
+			continue
+		}
+
+		target_pe_rate := math.MaxFloat64
+		target_pb_rate := math.MaxFloat64
+		result := ""
+		for _, years := range target_years {
+			// 按日期取区间 历史不足的跳过
+			start_date := last_date.AddDate(-years, 0, 0).Format("20060102")
+			if valuation_items[0].Date > start_date {
+				continue
+			}
+			start := 0
+			for start < valuation_items_len && valuation_items[start].Date < start_date {
+				start++
+			}
+
+			loop_pe_rate := CalculatePeriodValuationPercent(pe_values, start, valuation_items_len, last)
+			loop_pb_rate := CalculatePeriodValuationPercent(pb_values, start, valuation_items_len, last)
+			loop_dividend_rate := CalculatePeriodValuationPercent(dividend_values, start, valuation_items_len, last)
+
+			if kTargetYear == years {
+				target_pe_rate = loop_pe_rate
+				target_pb_rate = loop_pb_rate
+			}
+
+			result += fmt.Sprintf("最近%d年 %f|%f|%f ", years, loop_pe_rate, loop_pb_rate, loop_dividend_rate)
+		}
+
+		if target_pe_rate <= kMaxRate && target_pb_rate <= kMaxRate {
+			dividend := "无"
+			if dividend_values[last] > 0 {
+				dividend = fmt.Sprintf("%.2f%%", dividend_values[last])
+			}
+			fmt.Printf("%s(%s) PE %.2f PB %.2f 股息率 %s %s\n", iter.Name, iter.Symbol, pe_values[last], pb_values[last], dividend, result)
+		}
+	}
+
+	// 接口覆盖不到的 提示补充本地估值文件 data_center\valuation\代号.csv
+	if len(missing_valuation) > 0 {
+		fmt.Printf("\n%d个没有估值数据 已跳过 需要本地估值文件: %s\n", len(missing_valuation), strings.Join(missing_valuation, " "))
+	}
+	if len(missing_dividend) > 0 {
+		fmt.Printf("\n%d个没有股息率 股息率分位为NaN 需要本地估值文件: %s\n", len(missing_dividend), strings.Join(missing_dividend, " "))
+	}
+}